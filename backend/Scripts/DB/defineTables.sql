@@ -1,3 +1,22 @@
+DROP TABLE IF EXISTS moderation_audit_log CASCADE;
+DROP TABLE IF EXISTS reports CASCADE;
+DROP TABLE IF EXISTS user_blocks CASCADE;
+DROP TABLE IF EXISTS function_ratings CASCADE;
+DROP TABLE IF EXISTS function_tags CASCADE;
+DROP TABLE IF EXISTS user_tags CASCADE;
+DROP TABLE IF EXISTS tag_aliases CASCADE;
+DROP TABLE IF EXISTS tags CASCADE;
+DROP TABLE IF EXISTS location_history CASCADE;
+DROP TABLE IF EXISTS oidc_login_states CASCADE;
+DROP TABLE IF EXISTS user_identities CASCADE;
+DROP TABLE IF EXISTS totp_recovery_codes CASCADE;
+DROP TABLE IF EXISTS user_totp CASCADE;
+DROP TABLE IF EXISTS auth_audit_log CASCADE;
+DROP TABLE IF EXISTS login_throttle CASCADE;
+DROP TABLE IF EXISTS password_reset_tokens CASCADE;
+DROP TABLE IF EXISTS one_time_codes CASCADE;
+DROP TABLE IF EXISTS refresh_tokens CASCADE;
+DROP TABLE IF EXISTS sessions CASCADE;
 DROP TABLE IF EXISTS function_attendees CASCADE;
 DROP TABLE IF EXISTS functions CASCADE;
 DROP TABLE IF EXISTS friendships CASCADE;
@@ -11,20 +30,47 @@ DROP TABLE IF EXISTS users CASCADE;
 DROP TYPE IF EXISTS functiontype CASCADE;
 DROP TYPE IF EXISTS attendancestatus CASCADE;
 DROP TYPE IF EXISTS friendshipstatus CASCADE;
+DROP TYPE IF EXISTS visibilitymode CASCADE;
+DROP TYPE IF EXISTS locationprecision CASCADE;
+DROP TYPE IF EXISTS blockkind CASCADE;
+DROP TYPE IF EXISTS reporttarget CASCADE;
+DROP TYPE IF EXISTS reportreason CASCADE;
+DROP TYPE IF EXISTS reportstatus CASCADE;
+DROP TYPE IF EXISTS userrole CASCADE;
+DROP TYPE IF EXISTS profileaudience CASCADE;
+DROP TYPE IF EXISTS ratingtag CASCADE;
+DROP TYPE IF EXISTS tagcategory CASCADE;
 
 
 CREATE TYPE functiontype AS ENUM ('meetup', 'linkup', 'gangup', 'pullup');
 CREATE TYPE friendshipstatus AS ENUM ('requested', 'accepted');
 CREATE TYPE attendancestatus AS ENUM ('invited', 'going', 'already there');
+CREATE TYPE visibilitymode AS ENUM ('visible', 'friends', 'hidden');
+CREATE TYPE locationprecision AS ENUM ('exact', '100m', '500m', 'campus');
+CREATE TYPE blockkind AS ENUM ('block', 'mute');
+CREATE TYPE reporttarget AS ENUM ('user', 'meetup', 'linkup');
+CREATE TYPE reportreason AS ENUM ('harassment', 'spam', 'safety', 'other');
+CREATE TYPE reportstatus AS ENUM ('open', 'assigned', 'resolved', 'dismissed');
+CREATE TYPE userrole AS ENUM ('user', 'moderator', 'campus_admin', 'superadmin');
+CREATE TYPE profileaudience AS ENUM ('everyone', 'friends', 'nobody');
+CREATE TYPE ratingtag AS ENUM ('on time', 'friendly', 'fun', 'respectful', 'good conversation');
+CREATE TYPE tagcategory AS ENUM ('sports', 'outdoors', 'fitness', 'hangout', 'food', 'music', 'arts', 'games', 'tech', 'study', 'nightlife', 'languages', 'other');
 
 
 CREATE TABLE users (
     user_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     username VARCHAR(50) UNIQUE NOT NULL,
+    username_changed_at TIMESTAMP WITH TIME ZONE, -- last change after signup, for the rate limit
     email VARCHAR(255) UNIQUE NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
     name VARCHAR(255) NOT NULL,
-    phone_number VARCHAR(15) UNIQUE NOT NULL
+    phone_number VARCHAR(15) UNIQUE NOT NULL,
+    deleted_at TIMESTAMP WITH TIME ZONE, -- set while the account waits to be purged
+    suspended_at TIMESTAMP WITH TIME ZONE, -- set while a moderator has the account suspended
+    suspended_until TIMESTAMP WITH TIME ZONE, -- NULL means suspended until lifted
+    role userrole NOT NULL DEFAULT 'user',
+    role_university_id UUID, -- the university a campus_admin administers
+    CHECK ((role = 'campus_admin') = (role_university_id IS NOT NULL))
 );
 
 CREATE TABLE universities (
@@ -33,31 +79,48 @@ CREATE TABLE universities (
     area geometry
 );
 
+ALTER TABLE users ADD FOREIGN KEY (role_university_id) REFERENCES universities(university_id);
+
 CREATE TABLE user_profiles (
     user_id UUID PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
-    active BOOLEAN DEFAULT true,
+    active BOOLEAN DEFAULT true, -- false while hidden, suspended or waiting to be deleted
+    visibility visibilitymode NOT NULL DEFAULT 'visible',
+    hidden_until TIMESTAMP WITH TIME ZONE, -- NULL means hidden until turned off
     bio TEXT DEFAULT 'Hi!',
     birthdate DATE,
-    hobbies VARCHAR(63)[] DEFAULT '{}',
+    avatar_key TEXT, -- avatars/<user_id>/<avatar_id>, sizes stored under it; NULL without a photo
     friends UUID[] DEFAULT '{}',
-    last_active_location geography(Point, 4326),
+    last_active_location geography(Point, 4326), -- exact, never sent to other users as is
+    location_precision locationprecision NOT NULL DEFAULT '100m',
+    -- Who sees each part of the profile; the owner always sees all of it
+    age_audience profileaudience NOT NULL DEFAULT 'everyone', -- friends get the birthdate, everyone else the age
+    location_audience profileaudience NOT NULL DEFAULT 'friends' CHECK (location_audience <> 'everyone'),
+    last_active_audience profileaudience NOT NULL DEFAULT 'friends',
+    friends_audience profileaudience NOT NULL DEFAULT 'friends',
+    school_audience profileaudience NOT NULL DEFAULT 'everyone',
     last_active TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
     school_id UUID REFERENCES universities(university_id),
     verified_email BOOLEAN DEFAULT false,
+    verification_email_sent_at TIMESTAMP WITH TIME ZONE,
     verified_phone_number BOOLEAN DEFAULT false,
     functions_attended smallint DEFAULT 0,
-    rating smallint DEFAULT 0
+    rating NUMERIC(3, 2), -- Bayesian average kept up to date by a trigger on function_ratings; NULL before the first rating
+    rating_count INTEGER NOT NULL DEFAULT 0
 );
 
 CREATE TABLE friendships (
     user_id1 UUID NOT NULL,
     user_id2 UUID NOT NULL,
     friendship_status friendshipstatus NOT NULL,
+    requested_by UUID NOT NULL, -- which of the two sent the request
+    requested_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    accepted_at TIMESTAMP WITH TIME ZONE,
     PRIMARY KEY (user_id1, user_id2),
     FOREIGN KEY (user_id1) REFERENCES users(user_id) ON DELETE CASCADE,
     FOREIGN KEY (user_id2) REFERENCES users(user_id) ON DELETE CASCADE,
     CHECK (user_id1 != user_id2),
-    CHECK (user_id1 < user_id2)
+    CHECK (user_id1 < user_id2),
+    CHECK (requested_by IN (user_id1, user_id2))
 );
 
 CREATE TABLE buildings (
@@ -91,12 +154,196 @@ CREATE TABLE function_attendees (
     PRIMARY KEY (user_id, function_id)
 );
 
+-- Interest tags. The taxonomy itself is in seedTags.sql
+CREATE TABLE tags (
+    tag_id VARCHAR(63) PRIMARY KEY CHECK (tag_id ~ '^[a-z0-9]+(-[a-z0-9]+)*$'), -- e.g. 'board-games'
+    name VARCHAR(63) NOT NULL, -- shown to users, e.g. 'Board Games'
+    category tagcategory NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Other ways of writing a tag, like 'bball' for basketball; always lower case
+CREATE TABLE tag_aliases (
+    alias VARCHAR(63) PRIMARY KEY CHECK (alias = LOWER(alias)),
+    tag_id VARCHAR(63) NOT NULL REFERENCES tags(tag_id) ON DELETE CASCADE
+);
+
+CREATE TABLE user_tags (
+    user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    tag_id VARCHAR(63) NOT NULL REFERENCES tags(tag_id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, tag_id)
+);
+
+CREATE TABLE function_tags (
+    function_id UUID NOT NULL REFERENCES functions(function_id) ON DELETE CASCADE,
+    tag_id VARCHAR(63) NOT NULL REFERENCES tags(tag_id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (function_id, tag_id)
+);
+
+-- One rating per rater, person rated and function
+CREATE TABLE function_ratings (
+    function_id UUID NOT NULL REFERENCES functions(function_id) ON DELETE CASCADE,
+    rater_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    ratee_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    score smallint NOT NULL CHECK (score BETWEEN 1 AND 5),
+    tags ratingtag[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (function_id, rater_id, ratee_id),
+    CHECK (rater_id <> ratee_id)
+);
+
+CREATE TABLE sessions (
+    session_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    device_name VARCHAR(100) NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE refresh_tokens (
+    token_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    session_id UUID NOT NULL REFERENCES sessions(session_id) ON DELETE CASCADE,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    replaced_by UUID REFERENCES refresh_tokens(token_id) ON DELETE SET NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE one_time_codes (
+    code_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    purpose VARCHAR(31) NOT NULL,
+    destination VARCHAR(255) NOT NULL,
+    code_hash VARCHAR(255) NOT NULL,
+    attempts smallint NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    consumed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE password_reset_tokens (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Failed login counters, keyed by 'user:<id>', 'identifier:<login name>' or 'ip:<address>'
+CREATE TABLE login_throttle (
+    throttle_key VARCHAR(300) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE auth_audit_log (
+    event_id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(31) NOT NULL,
+    throttle_key VARCHAR(300) NOT NULL,
+    user_id UUID REFERENCES users(user_id) ON DELETE SET NULL,
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- enabled_at stays NULL until the user proves their app has the secret
+CREATE TABLE user_totp (
+    user_id UUID PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    secret VARCHAR(64) NOT NULL,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    enabled_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE totp_recovery_codes (
+    code_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    code_hash VARCHAR(255) NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE user_identities (
+    provider VARCHAR(50) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    email VARCHAR(100),
+    linked_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE TABLE oidc_login_states (
+    state VARCHAR(64) PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    device_name VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Append-only; the server creates one partition per day (location_history_YYYYMMDD)
+-- and drops them after the retention period
+CREATE TABLE location_history (
+    user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    recorded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    location geography(Point, 4326) NOT NULL
+) PARTITION BY RANGE (recorded_at);
+
+-- Catches points for days without a partition (the server was down or maintenance
+-- fell behind) so location updates keep working; maintenance moves them out when
+-- it creates the day's partition
+CREATE TABLE location_history_default PARTITION OF location_history DEFAULT;
+
+-- A block hides both users from each other; a mute only hides blocked_id from blocker_id
+CREATE TABLE user_blocks (
+    blocker_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    blocked_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    kind blockkind NOT NULL DEFAULT 'block',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (blocker_id, blocked_id),
+    CHECK (blocker_id <> blocked_id)
+);
+
+-- target_id is a user or a function; no foreign key so reports outlive what they're about
+CREATE TABLE reports (
+    report_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    reporter_id UUID REFERENCES users(user_id) ON DELETE SET NULL,
+    target_type reporttarget NOT NULL,
+    target_id UUID NOT NULL,
+    reason reportreason NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    status reportstatus NOT NULL DEFAULT 'open',
+    assigned_to UUID REFERENCES users(user_id) ON DELETE SET NULL,
+    resolution TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Append-only, see the trigger below. No foreign keys, so no cascade can touch it either
+CREATE TABLE moderation_audit_log (
+    entry_id BIGSERIAL PRIMARY KEY,
+    moderator_id UUID NOT NULL,
+    action VARCHAR(31) NOT NULL,
+    report_id UUID,
+    target_type VARCHAR(31) NOT NULL DEFAULT '',
+    target_id UUID,
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
 -- Make sure a user profile is created whenever a user signs up
 CREATE OR REPLACE FUNCTION create_user_profile()
 RETURNS TRIGGER AS $$
 BEGIN
-    INSERT INTO user_profiles (user_id, active, last_active, verified_email, verified_phone_number, functions_attended, rating)
-    VALUES (NEW.user_id, true, NOW(), false, false, 0, 0);
+    INSERT INTO user_profiles (user_id, active, last_active, verified_email, verified_phone_number, functions_attended)
+    VALUES (NEW.user_id, true, NOW(), false, false, 0);
     RETURN NEW;
 END;
 $$ LANGUAGE plpgsql;
@@ -106,7 +353,81 @@ CREATE TRIGGER trigger_create_user_profile
     FOR EACH ROW
     EXECUTE FUNCTION create_user_profile();
 
+-- Moderation history can only ever be added to
+CREATE OR REPLACE FUNCTION reject_moderation_audit_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'moderation_audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_moderation_audit_immutable
+    BEFORE UPDATE OR DELETE ON moderation_audit_log
+    FOR EACH ROW
+    EXECUTE FUNCTION reject_moderation_audit_change();
+
+CREATE TRIGGER trigger_moderation_audit_no_truncate
+    BEFORE TRUNCATE ON moderation_audit_log
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION reject_moderation_audit_change();
+
+-- Keep user_profiles.rating a Bayesian average of the ratings the user got:
+-- 5 made-up ratings of 3.5 pull users with only a few ratings towards the
+-- middle, so one friend's 5 stars can't top the list. The profile row is
+-- locked first so concurrent ratings of the same user all get counted.
+CREATE OR REPLACE FUNCTION update_user_rating()
+RETURNS TRIGGER AS $$
+DECLARE
+    rated_user UUID := CASE WHEN TG_OP = 'DELETE' THEN OLD.ratee_id ELSE NEW.ratee_id END;
+BEGIN
+    PERFORM 1 FROM user_profiles WHERE user_id = rated_user FOR UPDATE;
+
+    UPDATE user_profiles profile
+    SET rating_count = totals.count,
+        rating = CASE WHEN totals.count = 0 THEN NULL
+                      ELSE ROUND((5 * 3.5 + totals.total) / (5 + totals.count), 2) END
+    FROM (
+        SELECT COUNT(*) AS count, COALESCE(SUM(score), 0) AS total
+        FROM function_ratings WHERE ratee_id = rated_user
+    ) totals
+    WHERE profile.user_id = rated_user;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_update_user_rating
+    AFTER INSERT OR UPDATE OR DELETE ON function_ratings
+    FOR EACH ROW
+    EXECUTE FUNCTION update_user_rating();
+
+CREATE UNIQUE INDEX idx_users_username_lower ON users(LOWER(username));
+CREATE INDEX idx_friendships_user_id2 ON friendships(user_id2);
 CREATE INDEX idx_function_attendees_function_id ON function_attendees(function_id);
 CREATE INDEX idx_function_attendees_user_id ON function_attendees(user_id);
+CREATE INDEX idx_refresh_tokens_session_id ON refresh_tokens(session_id);
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX idx_password_reset_tokens_user_id ON password_reset_tokens(user_id, created_at DESC);
+CREATE INDEX idx_totp_recovery_codes_user_id ON totp_recovery_codes(user_id);
+CREATE INDEX idx_auth_audit_log_created_at ON auth_audit_log(created_at DESC);
+CREATE INDEX idx_one_time_codes_user_purpose ON one_time_codes(user_id, purpose, created_at DESC);
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+CREATE INDEX idx_buildings_location ON buildings USING GIST (location);
+CREATE INDEX idx_user_profiles_hidden_until ON user_profiles(hidden_until) WHERE visibility = 'hidden';
+CREATE INDEX idx_location_history_user_id ON location_history(user_id, recorded_at DESC);
+CREATE INDEX idx_users_deleted_at ON users(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_user_blocks_blocked_id ON user_blocks(blocked_id);
+CREATE INDEX idx_reports_queue ON reports(status, created_at);
+CREATE UNIQUE INDEX idx_reports_one_open_per_reporter ON reports(reporter_id, target_type, target_id) WHERE status IN ('open', 'assigned');
+CREATE INDEX idx_moderation_audit_log_target_id ON moderation_audit_log(target_id);
+CREATE INDEX idx_function_ratings_ratee_id ON function_ratings(ratee_id);
+CREATE INDEX idx_function_ratings_rater_id ON function_ratings(rater_id);
+CREATE INDEX idx_tag_aliases_tag_id ON tag_aliases(tag_id);
+CREATE INDEX idx_user_tags_tag_id ON user_tags(tag_id, created_at);
+CREATE INDEX idx_function_tags_tag_id ON function_tags(tag_id, created_at);
+
+-- Trigram indexes for user search
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX idx_users_username_trgm ON users USING GIN (LOWER(username) gin_trgm_ops);
+CREATE INDEX idx_users_name_trgm ON users USING GIN (LOWER(name) gin_trgm_ops);
 
-CREATE EXTENSION IF NOT EXISTS POSTGIS;
\ No newline at end of file
+CREATE EXTENSION IF NOT EXISTS POSTGIS;
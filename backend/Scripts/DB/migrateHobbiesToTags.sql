@@ -0,0 +1,94 @@
+-- Moves a database from free-text hobbies (user_profiles.hobbies) to interest
+-- tags. Run once with psql from this directory:
+--
+--     psql -d linkup_data -f migrateHobbiesToTags.sql
+--
+-- Each hobby is matched, ignoring case and surrounding spaces, against tag
+-- ids, tag names and aliases, so "Basketball", "basketball " and "bball" all
+-- become the basketball tag. Hobbies that match nothing are kept in
+-- hobby_migration_leftovers for a moderator to turn into tags or aliases.
+-- Everything runs in one transaction: either all of it happens or none.
+
+\set ON_ERROR_STOP on
+
+BEGIN;
+
+DO $$
+BEGIN
+    CREATE TYPE tagcategory AS ENUM ('sports', 'outdoors', 'fitness', 'hangout', 'food', 'music', 'arts', 'games', 'tech', 'study', 'nightlife', 'languages', 'other');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+CREATE TABLE IF NOT EXISTS tags (
+    tag_id VARCHAR(63) PRIMARY KEY CHECK (tag_id ~ '^[a-z0-9]+(-[a-z0-9]+)*$'),
+    name VARCHAR(63) NOT NULL,
+    category tagcategory NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS tag_aliases (
+    alias VARCHAR(63) PRIMARY KEY CHECK (alias = LOWER(alias)),
+    tag_id VARCHAR(63) NOT NULL REFERENCES tags(tag_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_tags (
+    user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    tag_id VARCHAR(63) NOT NULL REFERENCES tags(tag_id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, tag_id)
+);
+
+CREATE TABLE IF NOT EXISTS function_tags (
+    function_id UUID NOT NULL REFERENCES functions(function_id) ON DELETE CASCADE,
+    tag_id VARCHAR(63) NOT NULL REFERENCES tags(tag_id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (function_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tag_aliases_tag_id ON tag_aliases(tag_id);
+CREATE INDEX IF NOT EXISTS idx_user_tags_tag_id ON user_tags(tag_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_function_tags_tag_id ON function_tags(tag_id, created_at);
+
+\ir seedTags.sql
+
+CREATE TEMPORARY TABLE hobby_matches ON COMMIT DROP AS
+SELECT hobby.user_id, hobby.text,
+       COALESCE(
+           (SELECT t.tag_id FROM tags t WHERE t.tag_id = LOWER(TRIM(hobby.text))),
+           (SELECT t.tag_id FROM tags t WHERE LOWER(t.name) = LOWER(TRIM(hobby.text)) LIMIT 1),
+           (SELECT a.tag_id FROM tag_aliases a WHERE a.alias = LOWER(TRIM(hobby.text)))
+       ) AS tag_id
+FROM (
+    SELECT profile.user_id, UNNEST(profile.hobbies) AS text
+    FROM user_profiles profile
+) hobby
+WHERE TRIM(hobby.text) <> '';
+
+-- Dated at the epoch so the migration itself doesn't show up as trending
+INSERT INTO user_tags (user_id, tag_id, created_at)
+SELECT DISTINCT user_id, tag_id, TIMESTAMP WITH TIME ZONE 'epoch'
+FROM hobby_matches
+WHERE tag_id IS NOT NULL
+ON CONFLICT (user_id, tag_id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS hobby_migration_leftovers (
+    user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    hobby VARCHAR(63) NOT NULL,
+    PRIMARY KEY (user_id, hobby)
+);
+
+INSERT INTO hobby_migration_leftovers (user_id, hobby)
+SELECT DISTINCT user_id, TRIM(text)
+FROM hobby_matches
+WHERE tag_id IS NULL
+ON CONFLICT (user_id, hobby) DO NOTHING;
+
+ALTER TABLE user_profiles DROP COLUMN hobbies;
+
+COMMIT;
+
+-- What didn't match, most common first
+SELECT LOWER(hobby) AS hobby, COUNT(*) AS users
+FROM hobby_migration_leftovers
+GROUP BY LOWER(hobby)
+ORDER BY users DESC, hobby;
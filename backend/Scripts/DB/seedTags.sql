@@ -0,0 +1,98 @@
+-- The interest tag taxonomy. Run after defineTables.sql; safe to run again,
+-- it only adds what's missing. New tags and aliases can also be added through
+-- the admin API (POST /api/admin/tags).
+
+INSERT INTO tags (tag_id, name, category) VALUES
+    ('basketball', 'Basketball', 'sports'),
+    ('soccer', 'Soccer', 'sports'),
+    ('football', 'Football', 'sports'),
+    ('volleyball', 'Volleyball', 'sports'),
+    ('tennis', 'Tennis', 'sports'),
+    ('pickleball', 'Pickleball', 'sports'),
+    ('frisbee', 'Frisbee', 'sports'),
+    ('swimming', 'Swimming', 'sports'),
+    ('hiking', 'Hiking', 'outdoors'),
+    ('climbing', 'Climbing', 'outdoors'),
+    ('camping', 'Camping', 'outdoors'),
+    ('cycling', 'Cycling', 'outdoors'),
+    ('running', 'Running', 'fitness'),
+    ('gym', 'Gym', 'fitness'),
+    ('yoga', 'Yoga', 'fitness'),
+    ('coffee-chat', 'Coffee Chat', 'hangout'),
+    ('movies', 'Movies', 'hangout'),
+    ('travel', 'Travel', 'hangout'),
+    ('book-club', 'Book Club', 'hangout'),
+    ('reading', 'Reading', 'hangout'),
+    ('cooking', 'Cooking', 'food'),
+    ('baking', 'Baking', 'food'),
+    ('foodie', 'Trying Restaurants', 'food'),
+    ('music', 'Music', 'music'),
+    ('concerts', 'Concerts', 'music'),
+    ('dj', 'DJ', 'music'),
+    ('karaoke', 'Karaoke', 'music'),
+    ('dancing', 'Dancing', 'music'),
+    ('art', 'Art', 'arts'),
+    ('photography', 'Photography', 'arts'),
+    ('theater', 'Theater', 'arts'),
+    ('board-games', 'Board Games', 'games'),
+    ('video-games', 'Video Games', 'games'),
+    ('league-of-legends', 'League of Legends', 'games'),
+    ('chess', 'Chess', 'games'),
+    ('coding', 'Coding', 'tech'),
+    ('hackathons', 'Hackathons', 'tech'),
+    ('study-group', 'Study Group', 'study'),
+    ('parties', 'Parties', 'nightlife'),
+    ('spanish', 'Spanish', 'languages'),
+    ('french', 'French', 'languages'),
+    ('mandarin', 'Mandarin', 'languages'),
+    ('hindi', 'Hindi', 'languages')
+ON CONFLICT (tag_id) DO NOTHING;
+
+INSERT INTO tag_aliases (alias, tag_id) VALUES
+    ('bball', 'basketball'),
+    ('hoops', 'basketball'),
+    ('futbol', 'soccer'),
+    ('american football', 'football'),
+    ('ultimate', 'frisbee'),
+    ('ultimate frisbee', 'frisbee'),
+    ('swim', 'swimming'),
+    ('hike', 'hiking'),
+    ('bouldering', 'climbing'),
+    ('rock climbing', 'climbing'),
+    ('biking', 'cycling'),
+    ('bike', 'cycling'),
+    ('jogging', 'running'),
+    ('run', 'running'),
+    ('working out', 'gym'),
+    ('workout', 'gym'),
+    ('lifting', 'gym'),
+    ('fitness', 'gym'),
+    ('coffee', 'coffee-chat'),
+    ('movie', 'movies'),
+    ('watch a movie', 'movies'),
+    ('film', 'movies'),
+    ('books', 'reading'),
+    ('cook', 'cooking'),
+    ('food', 'foodie'),
+    ('restaurants', 'foodie'),
+    ('gigs', 'concerts'),
+    ('dance', 'dancing'),
+    ('drawing', 'art'),
+    ('painting', 'art'),
+    ('photos', 'photography'),
+    ('theatre', 'theater'),
+    ('plays', 'theater'),
+    ('shows', 'theater'),
+    ('boardgames', 'board-games'),
+    ('gaming', 'video-games'),
+    ('videogames', 'video-games'),
+    ('lol', 'league-of-legends'),
+    ('programming', 'coding'),
+    ('tech', 'coding'),
+    ('study', 'study-group'),
+    ('studying', 'study-group'),
+    ('frat parties', 'parties'),
+    ('party', 'parties'),
+    ('español', 'spanish'),
+    ('chinese', 'mandarin')
+ON CONFLICT (alias) DO NOTHING;
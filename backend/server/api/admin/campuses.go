@@ -0,0 +1,659 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	auth "server/api/userauth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type University struct {
+	UniversityID uuid.UUID `json:"university_id"`
+	Name         string    `json:"name"`
+	Buildings    int       `json:"buildings"`
+	Students     int       `json:"students"`
+}
+
+type Building struct {
+	BuildingID   uuid.UUID `json:"building_id"`
+	UniversityID uuid.UUID `json:"university_id"`
+	Name         string    `json:"name"`
+	Latitude     float64   `json:"latitude"`
+	Longitude    float64   `json:"longitude"`
+}
+
+// inCampusScope reports whether the caller may manage universityID, answering
+// the request with 404 when not.
+func inCampusScope(c *gin.Context, universityID uuid.UUID) bool {
+	if scope := auth.CampusScope(c); scope != nil && *scope != universityID {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "University not found"})
+		return false
+	}
+	return true
+}
+
+func validCoordinates(latitude float64, longitude float64) bool {
+	return latitude >= -90 && latitude <= 90 && longitude >= -180 && longitude <= 180
+}
+
+/*
+====================
+ListUniversities
+
+Purpose: Every university, or just their own for a campus admin.
+
+Endpoint: GET /api/admin/universities
+Authorization: Bearer token required (moderator, campus admin or superadmin)
+
+Response:
+	- Success: 200 OK
+		{
+			"universities": [
+				{
+					"university_id": "uuid",
+					"name": "University of Michigan",
+					"buildings": 212,
+					"students": 1830
+				}
+			]
+		}
+	- Server Error: 500
+*/
+func ListUniversities(c *gin.Context) {
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT university.university_id, university.name,
+			(SELECT COUNT(*) FROM buildings b WHERE b.university_id = university.university_id),
+			(SELECT COUNT(*) FROM user_profiles profile WHERE profile.school_id = university.university_id)
+		FROM universities university
+		WHERE $1::UUID IS NULL OR university.university_id = $1
+		ORDER BY university.name;
+	`
+
+	rows, err := db.Query(ctx, query, auth.CampusScope(c))
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer rows.Close()
+
+	universities := []University{}
+	for rows.Next() {
+		var university University
+		if err := rows.Scan(&university.UniversityID, &university.Name, &university.Buildings, &university.Students); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		universities = append(universities, university)
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"universities": universities})
+}
+
+/*
+====================
+CreateUniversity
+
+Purpose: Add a university users can pick as their school.
+
+Endpoint: POST /api/admin/universities
+Authorization: Bearer token required (superadmin)
+
+Body (JSON):
+	{
+		"name": "University of Michigan"
+	}
+
+Response:
+	- Success: 201 Created (the university)
+	- Bad Request: 400 (missing or too long name)
+	- Server Error: 500
+*/
+func CreateUniversity(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		Name string `json:"name" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	university := University{Name: strings.TrimSpace(request.Name)}
+	if university.Name == "" || len(university.Name) > 255 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Name must be 1 to 255 characters"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `INSERT INTO universities (name) VALUES ($1) RETURNING university_id;`, university.Name).Scan(&university.UniversityID)
+	if err != nil {
+		fmt.Printf("Error creating university: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if !writeAudit(c, ctx, tx, moderatorID, "create_university", "university", university.UniversityID, university.Name) {
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, university)
+}
+
+/*
+====================
+UpdateUniversity
+
+Purpose: Rename a university.
+
+Endpoint: PUT /api/admin/universities/:id
+Authorization: Bearer token required (that university's campus admin, or superadmin)
+
+Body (JSON):
+	{
+		"name": "University of Michigan - Ann Arbor"
+	}
+
+Response:
+	- Success: 200 OK
+	- Bad Request: 400 (invalid id, missing or too long name)
+	- Not Found: 404 (no such university, or not the campus admin's)
+	- Server Error: 500
+*/
+func UpdateUniversity(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	universityID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid university ID"})
+		return
+	}
+	if !inCampusScope(c, universityID) {
+		return
+	}
+
+	var request struct {
+		Name string `json:"name" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	name := strings.TrimSpace(request.Name)
+	if name == "" || len(name) > 255 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Name must be 1 to 255 characters"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var oldName string
+	err = tx.QueryRow(ctx, `SELECT name FROM universities WHERE university_id = $1 FOR UPDATE;`, universityID).Scan(&oldName)
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "University not found"})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if _, err = tx.Exec(ctx, `UPDATE universities SET name = $2 WHERE university_id = $1;`, universityID, name); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if !writeAudit(c, ctx, tx, moderatorID, "update_university", "university", universityID, oldName+" -> "+name) {
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "University updated"})
+}
+
+/*
+====================
+DeleteUniversity
+
+Purpose: Remove a university nobody uses: no buildings left and no student has
+it as their school.
+
+Endpoint: DELETE /api/admin/universities/:id
+Authorization: Bearer token required (superadmin)
+
+Response:
+	- Success: 200 OK
+	- Bad Request: 400 (invalid id)
+	- Not Found: 404 (no such university)
+	- Conflict: 409 (it still has buildings, students or a campus admin)
+	- Server Error: 500
+*/
+func DeleteUniversity(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	universityID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid university ID"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var name string
+	err = tx.QueryRow(ctx, `DELETE FROM universities WHERE university_id = $1 RETURNING name;`, universityID).Scan(&name)
+
+	if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23503" {
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "University still has buildings, students or a campus admin"})
+		return
+	}
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "University not found"})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if !writeAudit(c, ctx, tx, moderatorID, "delete_university", "university", universityID, name) {
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "University deleted"})
+}
+
+/*
+====================
+ListBuildings
+
+Purpose: A university's buildings, by name.
+
+Endpoint: GET /api/admin/universities/:id/buildings
+Authorization: Bearer token required (moderator, that university's campus admin, or superadmin)
+
+Response:
+	- Success: 200 OK
+		{
+			"buildings": [
+				{
+					"building_id": "uuid",
+					"university_id": "uuid",
+					"name": "Mason Hall",
+					"latitude": 42.2768,
+					"longitude": -83.7404
+				}
+			]
+		}
+	- Bad Request: 400 (invalid id)
+	- Not Found: 404 (not the campus admin's university)
+	- Server Error: 500
+*/
+func ListBuildings(c *gin.Context) {
+	universityID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid university ID"})
+		return
+	}
+	if !inCampusScope(c, universityID) {
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+		SELECT building_id, university_id, name, ST_Y(location::geometry), ST_X(location::geometry)
+		FROM buildings
+		WHERE university_id = $1
+		ORDER BY name;
+	`, universityID)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer rows.Close()
+
+	buildings := []Building{}
+	for rows.Next() {
+		var building Building
+		if err := rows.Scan(&building.BuildingID, &building.UniversityID, &building.Name, &building.Latitude, &building.Longitude); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		buildings = append(buildings, building)
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"buildings": buildings})
+}
+
+/*
+====================
+CreateBuilding
+
+Purpose: Add a building to a university. Buildings name the places shared
+locations snap to.
+
+Endpoint: POST /api/admin/universities/:id/buildings
+Authorization: Bearer token required (that university's campus admin, or superadmin)
+
+Body (JSON):
+	{
+		"name": "Mason Hall",
+		"latitude": 42.2768,
+		"longitude": -83.7404
+	}
+
+Response:
+	- Success: 201 Created (the building)
+	- Bad Request: 400 (invalid id, missing or too long name, coordinates out of range)
+	- Not Found: 404 (no such university, or not the campus admin's)
+	- Server Error: 500
+*/
+func CreateBuilding(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	universityID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid university ID"})
+		return
+	}
+	if !inCampusScope(c, universityID) {
+		return
+	}
+
+	var request struct {
+		Name      string   `json:"name" binding:"required"`
+		Latitude  *float64 `json:"latitude" binding:"required"`
+		Longitude *float64 `json:"longitude" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	building := Building{
+		UniversityID: universityID,
+		Name:         strings.TrimSpace(request.Name),
+		Latitude:     *request.Latitude,
+		Longitude:    *request.Longitude,
+	}
+
+	if building.Name == "" || len(building.Name) > 255 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Name must be 1 to 255 characters"})
+		return
+	}
+	if !validCoordinates(building.Latitude, building.Longitude) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Coordinates out of range"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO buildings (university_id, name, location)
+		SELECT university_id, $2, ST_SetSRID(ST_MakePoint($3, $4), 4326)::geography
+		FROM universities WHERE university_id = $1
+		RETURNING building_id;
+	`, universityID, building.Name, building.Longitude, building.Latitude).Scan(&building.BuildingID)
+
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "University not found"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error creating building: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if !writeAudit(c, ctx, tx, moderatorID, "create_building", "building", building.BuildingID, building.Name) {
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, building)
+}
+
+// lockBuilding loads the building in the :id param for update, within the
+// caller's campus scope, answering the request itself when not found.
+func lockBuilding(c *gin.Context, ctx context.Context, tx pgx.Tx) (Building, bool) {
+	var building Building
+
+	buildingID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid building ID"})
+		return building, false
+	}
+
+	err = tx.QueryRow(ctx, `
+		SELECT building_id, university_id, name, ST_Y(location::geometry), ST_X(location::geometry)
+		FROM buildings
+		WHERE building_id = $1 AND ($2::UUID IS NULL OR university_id = $2)
+		FOR UPDATE;
+	`, buildingID, auth.CampusScope(c)).Scan(&building.BuildingID, &building.UniversityID, &building.Name, &building.Latitude, &building.Longitude)
+
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "Building not found"})
+		return building, false
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return building, false
+	}
+	return building, true
+}
+
+/*
+====================
+UpdateBuilding
+
+Purpose: Rename or move a building. Only the fields sent change.
+
+Endpoint: PUT /api/admin/buildings/:id
+Authorization: Bearer token required (that university's campus admin, or superadmin)
+
+Body (JSON):
+	{
+		"name": "Mason Hall",   // optional
+		"latitude": 42.2768,    // optional
+		"longitude": -83.7404   // optional
+	}
+
+Response:
+	- Success: 200 OK (the building)
+	- Bad Request: 400 (invalid id, empty or too long name, coordinates out of range)
+	- Not Found: 404 (no such building, or not in the campus admin's university)
+	- Server Error: 500
+*/
+func UpdateBuilding(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		Name      *string  `json:"name"`
+		Latitude  *float64 `json:"latitude"`
+		Longitude *float64 `json:"longitude"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	building, ok := lockBuilding(c, ctx, tx)
+	if !ok {
+		return
+	}
+
+	before := fmt.Sprintf("%s (%.5f, %.5f)", building.Name, building.Latitude, building.Longitude)
+
+	if request.Name != nil {
+		building.Name = strings.TrimSpace(*request.Name)
+	}
+	if request.Latitude != nil {
+		building.Latitude = *request.Latitude
+	}
+	if request.Longitude != nil {
+		building.Longitude = *request.Longitude
+	}
+
+	if building.Name == "" || len(building.Name) > 255 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Name must be 1 to 255 characters"})
+		return
+	}
+	if !validCoordinates(building.Latitude, building.Longitude) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Coordinates out of range"})
+		return
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE buildings
+		SET name = $2, location = ST_SetSRID(ST_MakePoint($3, $4), 4326)::geography
+		WHERE building_id = $1;
+	`, building.BuildingID, building.Name, building.Longitude, building.Latitude)
+	if err != nil {
+		fmt.Printf("Error updating building: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	after := fmt.Sprintf("%s (%.5f, %.5f)", building.Name, building.Latitude, building.Longitude)
+	if !writeAudit(c, ctx, tx, moderatorID, "update_building", "building", building.BuildingID, before+" -> "+after) {
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, building)
+}
+
+/*
+====================
+DeleteBuilding
+
+Purpose: Remove a building.
+
+Endpoint: DELETE /api/admin/buildings/:id
+Authorization: Bearer token required (that university's campus admin, or superadmin)
+
+Response:
+	- Success: 200 OK
+	- Bad Request: 400 (invalid id)
+	- Not Found: 404 (no such building, or not in the campus admin's university)
+	- Server Error: 500
+*/
+func DeleteBuilding(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	building, ok := lockBuilding(c, ctx, tx)
+	if !ok {
+		return
+	}
+
+	if _, err = tx.Exec(ctx, `DELETE FROM buildings WHERE building_id = $1;`, building.BuildingID); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if !writeAudit(c, ctx, tx, moderatorID, "delete_building", "building", building.BuildingID, building.Name) {
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "Building deleted"})
+}
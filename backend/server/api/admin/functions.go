@@ -0,0 +1,412 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	auth "server/api/userauth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AdminFunction struct {
+	FunctionID   uuid.UUID  `json:"function_id"`
+	FunctionType string     `json:"function_type"`
+	Name         string     `json:"name"`
+	Host         uuid.UUID  `json:"host"`
+	HostUsername string     `json:"host_username"`
+	SecondHost   *uuid.UUID `json:"host1"`
+	PlaceID      string     `json:"place_id"`
+	Vibe         *string    `json:"vibe"`
+	StartTime    time.Time  `json:"start_time"`
+	EndTime      *time.Time `json:"end_time"`
+	Attendees    int        `json:"attendees"`
+	OpenReports  int        `json:"open_reports"`
+}
+
+// Campus admins only see functions hosted by their university's users.
+const adminFunctionColumns = `
+	f.function_id, f.function_type::TEXT, f.function_name, f.host, host.username, f.host1,
+	f.place_id, f.vibe, f.starts_at, f.ends_at,
+	(SELECT COUNT(*) FROM function_attendees a WHERE a.function_id = f.function_id AND a.attendance_status <> 'invited'),
+	(SELECT COUNT(*) FROM reports r WHERE r.target_type <> 'user' AND r.target_id = f.function_id AND r.status IN ('open', 'assigned'))
+`
+
+const adminFunctionJoins = `
+	JOIN users host ON f.host = host.user_id
+	JOIN user_profiles host_profile ON f.host = host_profile.user_id
+`
+
+func scanAdminFunction(row pgx.Row) (AdminFunction, error) {
+	var function AdminFunction
+	err := row.Scan(&function.FunctionID, &function.FunctionType, &function.Name, &function.Host, &function.HostUsername, &function.SecondHost,
+		&function.PlaceID, &function.Vibe, &function.StartTime, &function.EndTime, &function.Attendees, &function.OpenReports)
+	return function, err
+}
+
+/*
+====================
+ListFunctions
+
+Purpose: Browse meetups and linkups, newest start time first.
+
+Endpoint: GET /api/admin/functions
+Authorization: Bearer token required (moderator, campus admin or superadmin)
+
+Query Params:
+	- host_id: only functions this user hosts or co-hosts (optional)
+	- type: "meetup", "linkup", "gangup" or "pullup" (optional)
+	- reported: "true" for only functions with open reports (optional)
+	- limit: max functions, default 50, max 200 (optional)
+
+Response:
+	- Success: 200 OK
+		{
+			"functions": [
+				{
+					"function_id": "uuid",
+					"function_type": "meetup",
+					"name": "Study group",
+					"host": "uuid",
+					"host_username": "someone",
+					"host1": null,
+					"place_id": "ChIJ...",
+					"vibe": "chill",
+					"start_time": "2024-11-02T18:00:00Z",
+					"end_time": null,
+					"attendees": 4,
+					"open_reports": 1
+				}
+			]
+		}
+	- Bad Request: 400 (invalid host_id)
+	- Server Error: 500
+*/
+func ListFunctions(c *gin.Context) {
+	var hostID *uuid.UUID
+	if value := c.Query("host_id"); value != "" {
+		parsed, err := uuid.Parse(value)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid host_id"})
+			return
+		}
+		hostID = &parsed
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT ` + adminFunctionColumns + `
+		FROM functions f
+		` + adminFunctionJoins + `
+		WHERE ($1::UUID IS NULL OR host_profile.school_id = $1)
+		  AND ($2::UUID IS NULL OR $2 IN (f.host, f.host1))
+		  AND ($3 = '' OR f.function_type::TEXT = $3)
+		  AND (NOT $4 OR EXISTS (
+		      SELECT 1 FROM reports r
+		      WHERE r.target_type <> 'user' AND r.target_id = f.function_id AND r.status IN ('open', 'assigned')
+		  ))
+		ORDER BY f.starts_at DESC
+		LIMIT $5;
+	`
+
+	rows, err := db.Query(ctx, query, auth.CampusScope(c), hostID, c.Query("type"), c.Query("reported") == "true", limit)
+	if err != nil {
+		fmt.Printf("Error listing functions: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer rows.Close()
+
+	functions := []AdminFunction{}
+	for rows.Next() {
+		function, err := scanAdminFunction(rows)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		functions = append(functions, function)
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"functions": functions})
+}
+
+/*
+====================
+GetFunction
+
+Purpose: One function, as in ListFunctions.
+
+Endpoint: GET /api/admin/functions/:id
+Authorization: Bearer token required (moderator, campus admin or superadmin)
+
+Response:
+	- Success: 200 OK (the function)
+	- Bad Request: 400 (invalid function id)
+	- Not Found: 404 (no such function, or hosted outside the campus admin's university)
+	- Server Error: 500
+*/
+func GetFunction(c *gin.Context) {
+	functionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	function, err := scanAdminFunction(db.QueryRow(ctx, `
+		SELECT `+adminFunctionColumns+`
+		FROM functions f
+		`+adminFunctionJoins+`
+		WHERE f.function_id = $1
+		  AND ($2::UUID IS NULL OR host_profile.school_id = $2);
+	`, functionID, auth.CampusScope(c)))
+
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, function)
+}
+
+// lockTargetFunction loads the function in the :id param for update, within
+// the caller's campus scope, answering the request itself when not found.
+func lockTargetFunction(c *gin.Context, ctx context.Context, tx pgx.Tx) (AdminFunction, bool) {
+	functionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return AdminFunction{}, false
+	}
+
+	function, err := scanAdminFunction(tx.QueryRow(ctx, `
+		SELECT `+adminFunctionColumns+`
+		FROM functions f
+		`+adminFunctionJoins+`
+		WHERE f.function_id = $1
+		  AND ($2::UUID IS NULL OR host_profile.school_id = $2)
+		FOR UPDATE OF f;
+	`, functionID, auth.CampusScope(c)))
+
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return function, false
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return function, false
+	}
+	return function, true
+}
+
+/*
+====================
+UpdateFunction
+
+Purpose: Edit a function's details, e.g. to take an offensive name down
+without removing the whole meetup. Only the fields sent change.
+
+Endpoint: PATCH /api/admin/functions/:id
+Authorization: Bearer token required (moderator, campus admin or superadmin)
+
+Body (JSON):
+	{
+		"name": "Study group",       // optional
+		"vibe": "chill",             // optional
+		"place_id": "ChIJ...",       // optional
+		"start_time": "2024-11-02T18:00:00Z",  // optional
+		"end_time": "2024-11-02T20:00:00Z",    // optional
+		"note": "Removed a slur from the name"  // optional, for the audit log
+	}
+
+Response:
+	- Success: 200 OK (the function)
+	- Bad Request: 400 (invalid function id, empty name or place, or ending before it starts)
+	- Not Found: 404 (no such function, or hosted outside the campus admin's university)
+	- Server Error: 500
+*/
+func UpdateFunction(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		Name      *string    `json:"name"`
+		Vibe      *string    `json:"vibe"`
+		PlaceID   *string    `json:"place_id"`
+		StartTime *time.Time `json:"start_time"`
+		EndTime   *time.Time `json:"end_time"`
+		Note      string     `json:"note"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	function, ok := lockTargetFunction(c, ctx, tx)
+	if !ok {
+		return
+	}
+
+	var changed []string
+	if request.Name != nil {
+		function.Name = strings.TrimSpace(*request.Name)
+		changed = append(changed, "name")
+	}
+	if request.Vibe != nil {
+		function.Vibe = request.Vibe
+		changed = append(changed, "vibe")
+	}
+	if request.PlaceID != nil {
+		function.PlaceID = strings.TrimSpace(*request.PlaceID)
+		changed = append(changed, "place_id")
+	}
+	if request.StartTime != nil {
+		function.StartTime = *request.StartTime
+		changed = append(changed, "start_time")
+	}
+	if request.EndTime != nil {
+		function.EndTime = request.EndTime
+		changed = append(changed, "end_time")
+	}
+
+	if function.Name == "" || function.PlaceID == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Name and place can't be empty"})
+		return
+	}
+	if function.EndTime != nil && function.EndTime.Before(function.StartTime) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "A function can't end before it starts"})
+		return
+	}
+	if len(changed) == 0 {
+		c.IndentedJSON(http.StatusOK, function)
+		return
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE functions
+		SET function_name = $2, vibe = $3, place_id = $4, starts_at = $5, ends_at = $6
+		WHERE function_id = $1;
+	`, function.FunctionID, function.Name, function.Vibe, function.PlaceID, function.StartTime, function.EndTime)
+	if err != nil {
+		fmt.Printf("Error updating function: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	details := "changed " + strings.Join(changed, ", ")
+	if note := strings.TrimSpace(request.Note); note != "" {
+		details += ": " + note
+	}
+	if !writeAudit(c, ctx, tx, moderatorID, "edit_function", function.FunctionType, function.FunctionID, details) {
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, function)
+}
+
+/*
+====================
+DeleteFunction
+
+Purpose: Remove a function outside of a report. Its attendees go with it.
+
+Endpoint: DELETE /api/admin/functions/:id
+Authorization: Bearer token required (moderator, campus admin or superadmin)
+
+Body (JSON):
+	{
+		"note": "Spam, see ticket 41"  // optional, for the audit log
+	}
+
+Response:
+	- Success: 200 OK
+	- Bad Request: 400 (invalid function id)
+	- Not Found: 404 (no such function, or hosted outside the campus admin's university)
+	- Server Error: 500
+*/
+func DeleteFunction(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		Note string `json:"note"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	function, ok := lockTargetFunction(c, ctx, tx)
+	if !ok {
+		return
+	}
+
+	if _, err = tx.Exec(ctx, `DELETE FROM functions WHERE function_id = $1;`, function.FunctionID); err != nil {
+		fmt.Printf("Error removing function: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	details := "removed " + function.Name
+	if note := strings.TrimSpace(request.Note); note != "" {
+		details += ": " + note
+	}
+	if !writeAudit(c, ctx, tx, moderatorID, "remove_function", function.FunctionType, function.FunctionID, details) {
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "Function removed"})
+}
@@ -0,0 +1,254 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"server/api/moderation"
+	"server/api/tags"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var tagIDPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+const maxTagLength = 63 // tag ids, names and aliases are VARCHAR(63)
+
+// cleanAliases lower-cases aliases and drops repeats, rejecting empty or too long ones.
+func cleanAliases(aliases []string) ([]string, error) {
+	for _, alias := range aliases {
+		alias = strings.TrimSpace(alias)
+		if alias == "" || utf8.RuneCountInString(alias) > maxTagLength {
+			return nil, fmt.Errorf("aliases must be 1 to %d characters", maxTagLength)
+		}
+	}
+	return tags.Clean(aliases), nil
+}
+
+// writeTagAudit records a change to the taxonomy and commits tx. Tags have
+// no uuid, so the tag id goes in the details.
+func writeTagAudit(c *gin.Context, ctx context.Context, tx pgx.Tx, moderatorID uuid.UUID, action string, details string) bool {
+	err := moderation.WriteAudit(ctx, tx, moderation.AuditEntry{
+		ModeratorID: moderatorID,
+		Action:      action,
+		TargetType:  "tag",
+		Details:     details,
+	})
+	if err != nil {
+		fmt.Printf("Error writing moderation audit: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return false
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return false
+	}
+	return true
+}
+
+/*
+====================
+CreateTag
+
+Purpose: Add a canonical tag to the interest taxonomy, with any aliases.
+
+Endpoint: POST /api/admin/tags
+Authorization: Bearer token required (moderator or superadmin)
+
+Body (JSON):
+	{
+		"id": "pickleball",          // lower case letters and digits, words joined by -
+		"name": "Pickleball",        // shown to users, 1-63 characters
+		"category": "sports",        // one of the tagcategory values
+		"aliases": ["pickle ball"]   // optional
+	}
+
+Response:
+	- Success: 201 Created
+		{
+			"id": "pickleball",
+			"name": "Pickleball",
+			"category": "sports",
+			"aliases": ["pickle ball"]
+		}
+	- Bad Request: 400 (invalid id, name, category or alias)
+	- Conflict: 409 (the id or an alias is taken)
+	- Server Error: 500
+*/
+func CreateTag(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		ID       string   `json:"id" binding:"required"`
+		Name     string   `json:"name" binding:"required"`
+		Category string   `json:"category" binding:"required"`
+		Aliases  []string `json:"aliases"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+	if !tagIDPattern.MatchString(request.ID) || len(request.ID) > maxTagLength {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "id must be lower case letters and digits, words joined by -"})
+		return
+	}
+	if request.Name == "" || utf8.RuneCountInString(request.Name) > maxTagLength {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Name must be 1 to 63 characters"})
+		return
+	}
+
+	aliases, err := cleanAliases(request.Aliases)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `INSERT INTO tags (tag_id, name, category) VALUES ($1, $2, $3::TEXT::tagcategory);`,
+		request.ID, request.Name, request.Category)
+	if err == nil {
+		_, err = tx.Exec(ctx, `INSERT INTO tag_aliases (alias, tag_id) SELECT UNNEST($2::TEXT[]), $1;`, request.ID, aliases)
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			c.IndentedJSON(http.StatusConflict, gin.H{"error": "That id or alias is taken"})
+			return
+		case "22P02":
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Unknown category"})
+			return
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error creating tag: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if !writeTagAudit(c, ctx, tx, moderatorID, "create_tag", request.ID) {
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, gin.H{
+		"id":       request.ID,
+		"name":     request.Name,
+		"category": request.Category,
+		"aliases":  aliases,
+	})
+}
+
+/*
+====================
+AddTagAlias
+
+Purpose: Teach the taxonomy another way of writing a tag, so searches,
+autocomplete and older clients sending names find it.
+
+Endpoint: POST /api/admin/tags/:id/aliases
+Authorization: Bearer token required (moderator or superadmin)
+
+Body (JSON):
+	{
+		"alias": "bball"
+	}
+
+Response:
+	- Success: 201 Created
+		{
+			"id": "basketball",
+			"alias": "bball"
+		}
+	- Bad Request: 400 (missing, empty or too long alias)
+	- Not Found: 404 (no such tag)
+	- Conflict: 409 (the alias already belongs to a tag)
+	- Server Error: 500
+*/
+func AddTagAlias(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		Alias string `json:"alias" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	aliases, err := cleanAliases([]string{request.Alias})
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	alias := aliases[0]
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `INSERT INTO tag_aliases (alias, tag_id) VALUES ($1, $2);`, alias, c.Param("id"))
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			c.IndentedJSON(http.StatusConflict, gin.H{"error": "That alias already belongs to a tag"})
+			return
+		case "23503":
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+			return
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error adding tag alias: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if !writeTagAudit(c, ctx, tx, moderatorID, "add_tag_alias", c.Param("id")+": "+alias) {
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, gin.H{"id": c.Param("id"), "alias": alias})
+}
@@ -0,0 +1,603 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/api/media"
+	"server/api/moderation"
+	auth "server/api/userauth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+Admin endpoints for moderators, campus admins and superadmins. Campus admins
+only see and act on users of their own university (and functions those users
+host); see auth.CampusScope. Only superadmins act on other staff accounts.
+Every change is written to the moderation audit log in the same transaction.
+*/
+
+type AdminUser struct {
+	UserID           uuid.UUID  `json:"user_id"`
+	Username         string     `json:"username"`
+	Name             string     `json:"name"`
+	AvatarURL        string     `json:"avatar_url"`
+	Email            string     `json:"email"`
+	PhoneNumber      string     `json:"phone_number"`
+	Role             string     `json:"role"`
+	RoleUniversityID *uuid.UUID `json:"role_university_id"`
+	SchoolID         *uuid.UUID `json:"school_id"`
+	Active           bool       `json:"active"`
+	VerifiedEmail    bool       `json:"verified_email"`
+	LastActive       time.Time  `json:"last_active"`
+	DeletedAt        *time.Time `json:"deleted_at"`
+	SuspendedAt      *time.Time `json:"suspended_at"`
+	SuspendedUntil   *time.Time `json:"suspended_until"`
+	OpenReports      int        `json:"open_reports"`
+}
+
+// adminUserColumns selects an AdminUser from users u joined with user_profiles profile.
+const adminUserColumns = `
+	u.user_id, u.username, u.name, profile.avatar_key, u.email, u.phone_number, u.role::TEXT, u.role_university_id,
+	profile.school_id, COALESCE(profile.active, false), COALESCE(profile.verified_email, false), profile.last_active,
+	u.deleted_at, u.suspended_at, u.suspended_until,
+	(SELECT COUNT(*) FROM reports r WHERE r.target_type = 'user' AND r.target_id = u.user_id AND r.status IN ('open', 'assigned'))
+`
+
+func scanAdminUser(c *gin.Context, row pgx.Row) (AdminUser, error) {
+	var user AdminUser
+	var avatarKey *string
+	err := row.Scan(&user.UserID, &user.Username, &user.Name, &avatarKey, &user.Email, &user.PhoneNumber, &user.Role, &user.RoleUniversityID,
+		&user.SchoolID, &user.Active, &user.VerifiedEmail, &user.LastActive,
+		&user.DeletedAt, &user.SuspendedAt, &user.SuspendedUntil, &user.OpenReports)
+	user.AvatarURL = media.AvatarURL(c, avatarKey)
+	return user, err
+}
+
+// lockTargetUser loads the user in the :id param for update and checks the
+// caller may act on them, answering the request itself when not.
+func lockTargetUser(c *gin.Context, ctx context.Context, tx pgx.Tx) (AdminUser, bool) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return AdminUser{}, false
+	}
+
+	user, err := scanAdminUser(c, tx.QueryRow(ctx, `
+		SELECT `+adminUserColumns+`
+		FROM users u
+		JOIN user_profiles profile ON u.user_id = profile.user_id
+		WHERE u.user_id = $1
+		  AND ($2::UUID IS NULL OR profile.school_id = $2)
+		FOR UPDATE OF u;
+	`, userID, auth.CampusScope(c)))
+
+	// Outside a campus admin's university looks the same as not existing
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return user, false
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return user, false
+	}
+
+	if user.UserID.String() == c.MustGet("user_id").(string) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "You can't do this to your own account"})
+		return user, false
+	}
+	if user.Role != auth.RoleUser && c.GetString("role") != auth.RoleSuperadmin {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Only superadmins can act on staff accounts"})
+		return user, false
+	}
+	return user, true
+}
+
+/*
+====================
+LookupUsers
+
+Purpose: Find accounts by user id, or by the start of a username, name, email
+or phone number.
+
+Endpoint: GET /api/admin/users
+Authorization: Bearer token required (moderator, campus admin or superadmin)
+
+Query Params:
+	- q: what to look for (required)
+	- limit: max users, default 20, max 100 (optional)
+
+Response:
+	- Success: 200 OK
+		{
+			"users": [
+				{
+					"user_id": "uuid",
+					"username": "someone",
+					"name": "Some One",
+					"avatar_url": "https://.../medium.jpg",
+					"email": "someone@umich.edu",
+					"phone_number": "7345550100",
+					"role": "user",
+					"role_university_id": null,
+					"school_id": "uuid",
+					"active": true,
+					"verified_email": true,
+					"last_active": "2024-11-02T15:00:00Z",
+					"deleted_at": null,
+					"suspended_at": null,
+					"suspended_until": null,
+					"open_reports": 0
+				}
+			]
+		}
+	- Bad Request: 400 (missing q)
+	- Server Error: 500
+*/
+func LookupUsers(c *gin.Context) {
+	search := strings.TrimSpace(c.Query("q"))
+	if search == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "missing q query"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	var exactID *uuid.UUID
+	if parsed, err := uuid.Parse(search); err == nil {
+		exactID = &parsed
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT ` + adminUserColumns + `
+		FROM users u
+		JOIN user_profiles profile ON u.user_id = profile.user_id
+		WHERE ($3::UUID IS NULL OR profile.school_id = $3)
+		  AND (u.user_id = $2
+		       OR LOWER(u.username) LIKE LOWER($1)
+		       OR LOWER(u.name) LIKE LOWER($1)
+		       OR LOWER(u.email) LIKE LOWER($1)
+		       OR u.phone_number LIKE $1)
+		ORDER BY u.username
+		LIMIT $4;
+	`
+
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(search)
+	rows, err := db.Query(ctx, query, escaped+"%", exactID, auth.CampusScope(c), limit)
+	if err != nil {
+		fmt.Printf("Error looking up users: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer rows.Close()
+
+	users := []AdminUser{}
+	for rows.Next() {
+		user, err := scanAdminUser(c, rows)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		users = append(users, user)
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"users": users})
+}
+
+/*
+====================
+GetUser
+
+Purpose: One account, as in LookupUsers, plus how many live sessions it has.
+
+Endpoint: GET /api/admin/users/:id
+Authorization: Bearer token required (moderator, campus admin or superadmin)
+
+Response:
+	- Success: 200 OK
+		{
+			"user": { ... },
+			"live_sessions": 2
+		}
+	- Bad Request: 400 (invalid user id)
+	- Not Found: 404 (no such user, or outside the campus admin's university)
+	- Server Error: 500
+*/
+func GetUser(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	user, err := scanAdminUser(c, db.QueryRow(ctx, `
+		SELECT `+adminUserColumns+`
+		FROM users u
+		JOIN user_profiles profile ON u.user_id = profile.user_id
+		WHERE u.user_id = $1
+		  AND ($2::UUID IS NULL OR profile.school_id = $2);
+	`, userID, auth.CampusScope(c)))
+
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	var liveSessions int
+	err = db.QueryRow(ctx, `SELECT COUNT(*) FROM sessions WHERE user_id = $1 AND revoked_at IS NULL;`, userID).Scan(&liveSessions)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"user": user, "live_sessions": liveSessions})
+}
+
+/*
+====================
+SuspendUser
+
+Purpose: Suspend an account outside of a report: it is logged out everywhere,
+hidden, and can't log in until the suspension ends or is lifted. Suspending an
+already suspended account replaces the end time.
+
+Endpoint: POST /api/admin/users/:id/suspend
+Authorization: Bearer token required (moderator, campus admin or superadmin)
+
+Body (JSON):
+	{
+		"duration_hours": 72,  // optional; omit to suspend until lifted
+		"note": "Threatening messages, see ticket 41"
+	}
+
+Response:
+	- Success: 200 OK
+		{
+			"suspended_until": "2024-11-05T15:00:00Z"  // null when until lifted
+		}
+	- Bad Request: 400 (invalid user id, negative duration, or your own account)
+	- Forbidden: 403 (a staff account, unless you're a superadmin)
+	- Not Found: 404 (no such user, or outside the campus admin's university)
+	- Server Error: 500
+*/
+func SuspendUser(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		DurationHours int    `json:"duration_hours"`
+		Note          string `json:"note"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	if request.DurationHours < 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Duration can't be negative"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	user, ok := lockTargetUser(c, ctx, tx)
+	if !ok {
+		return
+	}
+
+	var until *time.Time
+	details := "suspended until lifted"
+	if request.DurationHours > 0 {
+		end := time.Now().Add(time.Duration(request.DurationHours) * time.Hour)
+		until = &end
+		details = fmt.Sprintf("suspended for %dh", request.DurationHours)
+	}
+	if note := strings.TrimSpace(request.Note); note != "" {
+		details += ": " + note
+	}
+
+	if _, err = auth.SuspendAccount(ctx, tx, user.UserID, until); err != nil {
+		fmt.Printf("Error suspending account: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if !writeAudit(c, ctx, tx, moderatorID, "suspend", "user", user.UserID, details) {
+		return
+	}
+
+	if _, err := auth.RevokeUserSessions(ctx, db, user.UserID); err != nil {
+		fmt.Printf("Error logging out suspended user %s: %v\n", user.UserID, err)
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"suspended_until": until})
+}
+
+/*
+====================
+UnsuspendUser
+
+Purpose: Lift a suspension early. The user can log in again right away.
+
+Endpoint: POST /api/admin/users/:id/unsuspend
+Authorization: Bearer token required (moderator, campus admin or superadmin)
+
+Body (JSON):
+	{
+		"note": "Appeal accepted"  // optional
+	}
+
+Response:
+	- Success: 200 OK
+	- Bad Request: 400 (invalid user id, or your own account)
+	- Forbidden: 403 (a staff account, unless you're a superadmin)
+	- Not Found: 404 (no such user, or outside the campus admin's university)
+	- Conflict: 409 (the user isn't suspended)
+	- Server Error: 500
+*/
+func UnsuspendUser(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		Note string `json:"note"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	user, ok := lockTargetUser(c, ctx, tx)
+	if !ok {
+		return
+	}
+
+	lifted, err := auth.LiftSuspension(ctx, tx, user.UserID)
+	if err != nil {
+		fmt.Printf("Error lifting suspension: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	if !lifted {
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "User isn't suspended"})
+		return
+	}
+
+	if !writeAudit(c, ctx, tx, moderatorID, "unsuspend", "user", user.UserID, strings.TrimSpace(request.Note)) {
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "Suspension lifted"})
+}
+
+/*
+====================
+ForceLogout
+
+Purpose: Revoke every session of an account, e.g. when it looks compromised.
+The user can log straight back in.
+
+Endpoint: POST /api/admin/users/:id/logout
+Authorization: Bearer token required (moderator, campus admin or superadmin)
+
+Response:
+	- Success: 200 OK
+		{
+			"revoked_sessions": 2
+		}
+	- Bad Request: 400 (invalid user id, or your own account)
+	- Forbidden: 403 (a staff account, unless you're a superadmin)
+	- Not Found: 404 (no such user, or outside the campus admin's university)
+	- Server Error: 500
+*/
+func ForceLogout(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	user, ok := lockTargetUser(c, ctx, tx)
+	if !ok {
+		return
+	}
+
+	if !writeAudit(c, ctx, tx, moderatorID, "force_logout", "user", user.UserID, "") {
+		return
+	}
+
+	revoked, err := auth.RevokeUserSessions(ctx, db, user.UserID)
+	if err != nil {
+		fmt.Printf("Error revoking sessions: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"revoked_sessions": len(revoked)})
+}
+
+/*
+====================
+SetUserRole
+
+Purpose: Change an account's role. Campus admins need the university they
+administer. The user is logged out so their next token carries the new role.
+
+Endpoint: PUT /api/admin/users/:id/role
+Authorization: Bearer token required (superadmin)
+
+Body (JSON):
+	{
+		"role": "campus_admin",  // or "user", "moderator", "superadmin"
+		"university_id": "uuid"  // campus_admin only
+	}
+
+Response:
+	- Success: 200 OK
+	- Bad Request: 400 (unknown role, missing or unexpected university_id, or your own account)
+	- Not Found: 404 (no such user or university)
+	- Server Error: 500
+*/
+func SetUserRole(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		Role         string     `json:"role" binding:"required"`
+		UniversityID *uuid.UUID `json:"university_id"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	if !auth.ValidRole(request.Role) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Role must be user, moderator, campus_admin or superadmin"})
+		return
+	}
+	if (request.Role == auth.RoleCampusAdmin) != (request.UniversityID != nil) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "A university_id goes with the campus_admin role, and only with it"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	user, ok := lockTargetUser(c, ctx, tx)
+	if !ok {
+		return
+	}
+
+	if request.UniversityID != nil {
+		var exists bool
+		err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM universities WHERE university_id = $1);`, request.UniversityID).Scan(&exists)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		if !exists {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": "University not found"})
+			return
+		}
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE users SET role = $2, role_university_id = $3 WHERE user_id = $1;`, user.UserID, request.Role, request.UniversityID)
+	if err != nil {
+		fmt.Printf("Error setting role: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	details := user.Role + " -> " + request.Role
+	if request.UniversityID != nil {
+		details += " of " + request.UniversityID.String()
+	}
+	if !writeAudit(c, ctx, tx, moderatorID, "set_role", "user", user.UserID, details) {
+		return
+	}
+
+	if _, err := auth.RevokeUserSessions(ctx, db, user.UserID); err != nil {
+		fmt.Printf("Error logging out user %s after role change: %v\n", user.UserID, err)
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "Role updated"})
+}
+
+// writeAudit records an admin action and commits tx, answering the request
+// itself and returning false if either fails.
+func writeAudit(c *gin.Context, ctx context.Context, tx pgx.Tx, moderatorID uuid.UUID, action string, targetType string, targetID uuid.UUID, details string) bool {
+	err := moderation.WriteAudit(ctx, tx, moderation.AuditEntry{
+		ModeratorID: moderatorID,
+		Action:      action,
+		TargetType:  targetType,
+		TargetID:    &targetID,
+		Details:     details,
+	})
+	if err != nil {
+		fmt.Printf("Error writing moderation audit: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return false
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return false
+	}
+	return true
+}
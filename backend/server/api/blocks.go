@@ -0,0 +1,283 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"server/api/media"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+A row in user_blocks is one user blocking or muting another:
+
+	- block: the two never see each other again. The blocked user isn't told;
+	  to them the blocker just looks gone (missing from search and nearby
+	  linkups, profile not found), and friend requests or invites they send
+	  appear to go through but are dropped. Blocking also ends any friendship
+	  and cancels pending linkup invites between them.
+	- mute: lighter; the muted user only disappears from the muter's discovery
+	  (search, linkup invites and nearby linkups). Nothing else changes.
+
+Queries enforce this inline. For a viewer $1 looking at another user X,
+discovery excludes X when
+	EXISTS (SELECT 1 FROM user_blocks b
+	        WHERE (b.blocker_id = $1 AND b.blocked_id = X)
+	           OR (b.blocker_id = X AND b.blocked_id = $1 AND b.kind = 'block'))
+*/
+
+type BlockedUser struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	Name      string    `json:"name"`
+	AvatarURL string    `json:"avatar_url"`
+	Kind      string    `json:"kind"` // "block" or "mute"
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// blockKind returns "block" or "mute" if blocker has blocked or muted
+// blocked, or "" if neither.
+func blockKind(ctx context.Context, db *pgxpool.Pool, blocker uuid.UUID, blocked uuid.UUID) (string, error) {
+	var kind string
+	err := db.QueryRow(ctx, `
+		SELECT kind::TEXT FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2;
+	`, blocker, blocked).Scan(&kind)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	return kind, err
+}
+
+/*
+====================
+BlockUser
+
+Purpose: Block or mute another user. Blocking someone already muted (or the
+other way round) changes the kind.
+
+Endpoint: POST /api/users/blocks
+Authorization: Bearer token required
+
+Body (JSON):
+	{
+		"user_id": "uuid-to-block",
+		"kind": "block"  // or "mute", default "block"
+	}
+
+Response:
+	- Success: 201 Created
+	- Bad Request: 400 (bad user id or kind, or blocking yourself)
+	- Not Found: 404 (no such user)
+	- Server Error: 500
+*/
+func BlockUser(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		UserID uuid.UUID `json:"user_id" binding:"required"`
+		Kind   string    `json:"kind"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	if request.Kind == "" {
+		request.Kind = "block"
+	}
+	if request.Kind != "block" && request.Kind != "mute" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Kind must be block or mute"})
+		return
+	}
+	if request.UserID == userID {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "You can't block yourself"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var blockedID uuid.UUID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO user_blocks (blocker_id, blocked_id, kind)
+		SELECT $1, user_id, $3 FROM users WHERE user_id = $2
+		ON CONFLICT (blocker_id, blocked_id) DO UPDATE SET kind = EXCLUDED.kind, created_at = NOW()
+		RETURNING blocked_id;
+	`, userID, request.UserID, request.Kind).Scan(&blockedID)
+
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error blocking user: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if request.Kind == "block" {
+		cleanup := []string{
+			// No friendship or pending request survives a block
+			`DELETE FROM friendships WHERE user_id1 = LEAST($1, $2)::UUID AND user_id2 = GREATEST($1, $2)::UUID;`,
+			`UPDATE user_profiles SET friends = array_remove(friends, $2) WHERE user_id = $1;`,
+			`UPDATE user_profiles SET friends = array_remove(friends, $1) WHERE user_id = $2;`,
+			// Nor an open invite to the other's events
+			`DELETE FROM function_attendees attendee
+			 USING functions f
+			 WHERE attendee.function_id = f.function_id
+			   AND attendee.attendance_status = 'invited'
+			   AND ((attendee.user_id = $1 AND $2 IN (f.host, f.host1))
+			     OR (attendee.user_id = $2 AND $1 IN (f.host, f.host1)));`,
+		}
+		for _, statement := range cleanup {
+			if _, err = tx.Exec(ctx, statement, userID, blockedID); err != nil {
+				fmt.Printf("Error cleaning up after block: %v\n", err)
+				c.IndentedJSON(http.StatusInternalServerError, nil)
+				return
+			}
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, nil)
+}
+
+/*
+====================
+UnblockUser
+
+Purpose: Remove a block or mute. A friendship ended by the block doesn't come back.
+
+Endpoint: DELETE /api/users/blocks/:id
+Authorization: Bearer token required
+
+Response:
+	- Success: 200 OK
+	- Bad Request: 400 (bad user id)
+	- Not Found: 404 (that user isn't blocked or muted)
+	- Server Error: 500
+*/
+func UnblockUser(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	blockedID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	result, err := db.Exec(ctx, `DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2;`, userID, blockedID)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if result.RowsAffected() == 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "User isn't blocked or muted"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, nil)
+}
+
+/*
+====================
+ListBlockedUsers
+
+Purpose: Users the authenticated user has blocked or muted, newest first.
+
+Endpoint: GET /api/users/blocks
+Authorization: Bearer token required
+
+Query Params:
+	- kind: "block" or "mute" (optional, default both)
+
+Response:
+	- Success: 200 OK
+		{
+			"blocks": [
+				{
+					"user_id": "uuid",
+					"username": "someone",
+					"name": "Some One",
+					"avatar_url": "https://.../medium.jpg",  // "" without a photo
+					"kind": "block",
+					"created_at": "2024-11-02T15:00:00Z"
+				}
+			]
+		}
+	- Server Error: 500
+*/
+func ListBlockedUsers(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT u.user_id, u.username, u.name, profile.avatar_key, b.kind::TEXT, b.created_at
+		FROM user_blocks b
+		JOIN users u ON b.blocked_id = u.user_id
+		JOIN user_profiles profile ON profile.user_id = u.user_id
+		WHERE b.blocker_id = $1
+		  AND ($2 = '' OR b.kind::TEXT = $2)
+		ORDER BY b.created_at DESC;
+	`
+
+	rows, err := db.Query(ctx, query, userID, c.Query("kind"))
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer rows.Close()
+
+	blocks := []BlockedUser{}
+	for rows.Next() {
+		var blocked BlockedUser
+		var avatarKey *string
+		if err := rows.Scan(&blocked.UserID, &blocked.Username, &blocked.Name, &avatarKey, &blocked.Kind, &blocked.CreatedAt); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		blocked.AvatarURL = media.AvatarURL(c, avatarKey)
+		blocks = append(blocks, blocked)
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"blocks": blocks})
+}
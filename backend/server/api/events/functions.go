@@ -46,7 +46,11 @@ Frontend Request:
 Response:
 	- Success: 201 Created (empty body)
 	- Bad Request: 400 (invalid JSON or missing fields)
+	- Conflict: 409 (the inviter blocked the invitee)
 	- Server Error: 500
+
+Notes:
+	- If the invitee blocked the inviter, nothing is stored but the answer is still 201
 */
 
 // FunctionData represents a generic event (meetup or linkup)
@@ -64,6 +68,7 @@ type FunctionData struct {
 	PlaceID             string      `json:"place_id"`
 	InvitedUsers        []string    `json:"invited_users"`
 	FunctionID          uuid.UUID   `json:"function_id"`
+	Tags                []string    `json:"tags"` // tag ids
 }
 
 // FunctionDataList represents a list of events
@@ -92,10 +97,39 @@ func InviteUser(c *gin.Context) {
 		return
 	}
 
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
 	db := c.MustGet("db").(*pgxpool.Pool)
 	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
 	defer cancel()
 
+	// Blocks either way stop the invite. Someone who blocked the inviter
+	// mustn't be found out, so that case still answers 201
+	var inviterBlocked, inviteeBlocked bool
+	blockQuery := `
+		SELECT
+			EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2 AND kind = 'block'),
+			EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = $2 AND blocked_id = $1 AND kind = 'block');
+	`
+
+	err = db.QueryRow(ctx, blockQuery, userID, inviteRequest.Invitee).Scan(&inviteeBlocked, &inviterBlocked)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	if inviteeBlocked {
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "Unblock this user first"})
+		return
+	}
+	if inviterBlocked {
+		c.IndentedJSON(http.StatusCreated, nil)
+		return
+	}
+
 	query := `
 		INSERT INTO function_attendees (user_id, function_id, attendance_status) VALUES ($1, $2, $3) returning user_id;
 	`
@@ -4,10 +4,15 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
+	"server/api/geo"
+	"server/api/media"
+	"server/api/tags"
+
 	"github.com/gin-gonic/gin"
-	"github.com/gin-gonic/gin/binding"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -44,7 +49,8 @@ Frontend Request:
 			"location": {
 				"latitude": 42.2808,
 				"longitude": -83.7430
-			}
+			},
+			"tags": ["coffee-chat"]  // optional, up to 5 tag ids
 		}
 
 Response:
@@ -53,13 +59,17 @@ Response:
 			"linkup_id": "uuid-of-created-linkup",
 			"message": "Linkup created successfully"
 		}
-	- Bad Request: 400 (missing required fields, invalid coordinates or radius > 5000m)
+	- Bad Request: 400 (missing required fields, invalid coordinates, radius > 5000m, unknown or too many tags)
+	- Conflict: 409 (the initiator is in ghost mode)
 	- Server Error: 500
 
 Notes:
 	- Max search radius: 5000 meters (5 km)
 	- Default search radius: 500 meters
-	- Automatically invites up to 50 nearby users
+	- Automatically invites up to 50 nearby users, skipping anyone in ghost mode
+	- A friends-only initiator only invites accepted friends
+	- Nobody the initiator blocked or muted is invited, nor anyone who blocked or muted them
+	- With REQUIRE_VERIFIED_EMAIL=true, the initiator and every invitee must have a verified email
 */
 
 type LinkupData struct {
@@ -80,17 +90,21 @@ type CreateLinkupRequest struct {
 	Message      string      `json:"message"`
 	SearchRadius float64     `json:"search_radius"` // meters, default 500m
 	Location     Coordinates `json:"location" binding:"required"`
+	Tags         []string    `json:"tags"` // tag ids
 }
 
 type NearbyLinkup struct {
-	LinkupID        uuid.UUID   `json:"linkup_id"`
-	InitiatorID     uuid.UUID   `json:"initiator_id"`
-	InitiatorName   string      `json:"initiator_name"`
-	InitiatorRating int         `json:"initiator_rating"`
-	Distance        float64     `json:"distance"` // meters
-	Vibe            string      `json:"vibe"`
-	Message         string      `json:"message"`
-	CreatedAt       time.Time   `json:"created_at"`
+	LinkupID             uuid.UUID `json:"linkup_id"`
+	InitiatorID          uuid.UUID `json:"initiator_id"`
+	InitiatorName        string    `json:"initiator_name"`
+	InitiatorAvatarURL   string    `json:"initiator_avatar_url"`
+	InitiatorRating      *float64  `json:"initiator_rating"` // null before the first rating
+	InitiatorRatingCount int       `json:"initiator_rating_count"`
+	Distance             float64   `json:"distance"` // meters
+	Vibe                 string    `json:"vibe"`
+	Message              string    `json:"message"`
+	Tags                 []string  `json:"tags"` // tag ids
+	CreatedAt            time.Time `json:"created_at"`
 }
 
 func CreateLinkup(c *gin.Context) {
@@ -130,15 +144,53 @@ func CreateLinkup(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Insert linkup into functions table
+	// A linkup tells everyone nearby where the initiator is, so ghosts can't start one
+	var visibility string
+	err = db.QueryRow(ctx, `
+		SELECT CASE WHEN visibility = 'hidden' AND hidden_until <= NOW() THEN 'visible' ELSE visibility::TEXT END
+		FROM user_profiles WHERE user_id = $1;
+	`, userID).Scan(&visibility)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create linkup"})
+		return
+	}
+
+	if visibility == "hidden" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Turn off ghost mode to start a linkup"})
+		return
+	}
+
+	tagIDs, unknownTags, err := tags.Resolve(ctx, db, request.Tags, false)
+	if err != nil {
+		fmt.Printf("Error resolving linkup tags: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create linkup"})
+		return
+	}
+	if len(unknownTags) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown tags: " + strings.Join(unknownTags, ", ")})
+		return
+	}
+	if len(tagIDs) > tags.MaxPerFunction {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d tags", tags.MaxPerFunction)})
+		return
+	}
+
+	// Insert linkup into functions table, with its tags
 	query := `
-		INSERT INTO functions (host, function_type, place_id, function_name, starts_at, vibe)
-		VALUES ($1, $2, $3, $4, NOW(), $5)
-		RETURNING function_id;
+		WITH created AS (
+			INSERT INTO functions (host, function_type, place_id, function_name, starts_at, vibe)
+			VALUES ($1, $2, $3, $4, NOW(), $5)
+			RETURNING function_id
+		), tagged AS (
+			INSERT INTO function_tags (function_id, tag_id)
+			SELECT created.function_id, UNNEST($6::TEXT[]) FROM created
+		)
+		SELECT function_id FROM created;
 	`
 
 	var linkupID string
-	err = db.QueryRow(ctx, query, userID, "linkup", placeID, request.Message, request.Vibe).Scan(&linkupID)
+	err = db.QueryRow(ctx, query, userID, "linkup", placeID, request.Message, request.Vibe, tagIDs).Scan(&linkupID)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create linkup"})
@@ -161,11 +213,27 @@ func CreateLinkup(c *gin.Context) {
 		      ST_SetSRID(ST_MakePoint($2, $3), 4326)::geography
 		  ) <= $4
 		  AND profile.active = true
+		  AND (profile.visibility <> 'hidden' OR profile.hidden_until <= NOW())
+		  AND (NOT $5 OR profile.verified_email)
+		  AND ($6 <> 'friends' OR EXISTS (
+		      SELECT 1 FROM friendships friend
+		      WHERE friend.user_id1 = LEAST($1, profile.user_id)
+		        AND friend.user_id2 = GREATEST($1, profile.user_id)
+		        AND friend.friendship_status = 'accepted'
+		  ))
+		  AND NOT EXISTS (
+		      SELECT 1 FROM user_blocks b
+		      WHERE (b.blocker_id = $1 AND b.blocked_id = profile.user_id)
+		         OR (b.blocker_id = profile.user_id AND b.blocked_id = $1)
+		  )
 		ORDER BY distance
 		LIMIT 50;
 	`
 
-	rows, err := db.Query(ctx, broadcastQuery, userID, request.Location.Longitude, request.Location.Latitude, request.SearchRadius)
+	// Only reach verified accounts when the route requires a verified email
+	requireVerified := c.GetBool("require_verified_email")
+
+	rows, err := db.Query(ctx, broadcastQuery, userID, request.Location.Longitude, request.Location.Latitude, request.SearchRadius, requireVerified, visibility)
 
 	if err != nil {
 		// Even if broadcast fails, the linkup is created
@@ -232,10 +300,13 @@ Response:
 					"linkup_id": "uuid",
 					"initiator_id": "uuid",
 					"initiator_name": "John Doe",
-					"initiator_rating": 4.5,
+					"initiator_avatar_url": "https://.../medium.jpg",  // "" without a photo
+					"initiator_rating": 4.12,  // null before the first rating
+					"initiator_rating_count": 9,
 					"distance": 150.5,  // meters
 					"vibe": "casual",
 					"message": "Want to grab coffee?",
+					"tags": ["coffee-chat"],
 					"created_at": "2024-11-02T15:00:00Z"
 				},
 				...
@@ -246,8 +317,14 @@ Response:
 
 Notes:
 	- Only returns linkups where user has been invited (status = 'invited')
-	- Results ordered by distance (closest first)
-	- Distance is in meters
+	- Results ordered by rounded distance (closest first)
+	- Distance is in meters, measured from the initiator's location fuzzed to their
+	  precision setting and rounded (10 m exact, 100 m, 500 m, 1 km campus)
+	- max_radius is compared against that rounded distance and rounded up to the
+	  same step, so it can't be used to measure the exact distance
+	- Initiators who went hidden disappear, and friends-only initiators only show to their friends
+	- Initiators the user blocked or muted, or who blocked the user, are left out
+	- With REQUIRE_VERIFIED_EMAIL=true, the user and every listed initiator must have a verified email
 */
 func GetNearbyLinkups(c *gin.Context) {
 	userIDString := c.MustGet("user_id").(string)
@@ -294,55 +371,81 @@ func GetNearbyLinkups(c *gin.Context) {
 		SELECT f.function_id,
 		       f.host,
 		       u.name as initiator_name,
-		       profile.rating as initiator_rating,
-		       ST_Distance(
-		           profile.last_active_location,
-		           ST_SetSRID(ST_MakePoint($2, $3), 4326)::geography
-		       ) as distance,
+		       profile.avatar_key as initiator_avatar_key,
+		       profile.rating::FLOAT8 as initiator_rating,
+		       profile.rating_count as initiator_rating_count,
 		       f.vibe,
 		       f.function_name as message,
-		       f.starts_at
+		       ARRAY(SELECT ft.tag_id FROM function_tags ft WHERE ft.function_id = f.function_id ORDER BY ft.tag_id) as tags,
+		       f.starts_at,
+		       ` + geo.SharedLocationColumns + `
 		FROM functions f
 		JOIN function_attendees fa ON f.function_id = fa.function_id
 		JOIN user_profiles profile ON f.host = profile.user_id
 		JOIN users u ON f.host = u.user_id
+		` + geo.SharedLocationJoins + `
 		WHERE f.function_type = 'linkup'
 		  AND fa.user_id = $1
 		  AND fa.attendance_status = 'invited'
 		  AND profile.last_active_location IS NOT NULL
-		  AND ST_Distance(
-		      profile.last_active_location,
-		      ST_SetSRID(ST_MakePoint($2, $3), 4326)::geography
-		  ) <= $4
-		ORDER BY distance;
+		  AND (NOT $2 OR profile.verified_email)
+		  AND (profile.visibility = 'visible'
+		       OR (profile.visibility = 'hidden' AND profile.hidden_until <= NOW())
+		       OR (profile.visibility = 'friends' AND EXISTS (
+		           SELECT 1 FROM friendships friend
+		           WHERE friend.user_id1 = LEAST($1, f.host)
+		             AND friend.user_id2 = GREATEST($1, f.host)
+		             AND friend.friendship_status = 'accepted'
+		       )))
+		  AND NOT EXISTS (
+		      SELECT 1 FROM user_blocks b
+		      WHERE (b.blocker_id = $1 AND b.blocked_id = f.host)
+		         OR (b.blocker_id = f.host AND b.blocked_id = $1 AND b.kind = 'block')
+		  )
+		ORDER BY f.starts_at DESC;
 	`
 
-	rows, err := db.Query(ctx, query, userID, longitude, latitude, maxRadius)
+	rows, err := db.Query(ctx, query, userID, c.GetBool("require_verified_email"))
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch linkups"})
 		return
 	}
 
+	// Both the radius filter and the distance sent back use the initiator's
+	// fuzzed location, rounded to their precision, never the raw one
+	viewer := geo.Point{Latitude: latitude, Longitude: longitude}
+
 	var linkups []NearbyLinkup
 	for rows.Next() {
 		var linkup NearbyLinkup
-		err := rows.Scan(
+		var location geo.SharedLocation
+		var avatarKey *string
+		err := rows.Scan(append([]any{
 			&linkup.LinkupID,
 			&linkup.InitiatorID,
 			&linkup.InitiatorName,
+			&avatarKey,
 			&linkup.InitiatorRating,
-			&linkup.Distance,
+			&linkup.InitiatorRatingCount,
 			&linkup.Vibe,
 			&linkup.Message,
+			&linkup.Tags,
 			&linkup.CreatedAt,
-		)
-		if err == nil {
+		}, location.ScanTargets()...)...)
+		if err == nil && location.Within(viewer, maxRadius) {
+			linkup.Distance = location.DistanceFrom(viewer)
+			linkup.InitiatorAvatarURL = media.AvatarURL(c, avatarKey)
 			linkups = append(linkups, linkup)
 		}
 	}
 	rows.Close()
 
+	// Ordering by the raw distance would leak it, so order by the rounded one
+	sort.SliceStable(linkups, func(i, j int) bool {
+		return linkups[i].Distance < linkups[j].Distance
+	})
+
 	c.JSON(http.StatusOK, gin.H{"linkups": linkups})
 }
 
@@ -384,6 +487,7 @@ Notes:
 	- First-come-first-served: Only one person can join
 	- Transactional: Atomically updates host1, cancels other invites, updates attendance
 	- Cannot join your own linkup
+	- A block either way between the user and the initiator answers 404
 */
 func JoinLinkup(c *gin.Context) {
 	userIDString := c.MustGet("user_id").(string)
@@ -442,6 +546,26 @@ func JoinLinkup(c *gin.Context) {
 		return
 	}
 
+	// Blocked either way, the linkup doesn't exist for this user
+	var blocked bool
+	blockQuery := `
+		SELECT EXISTS (
+			SELECT 1 FROM user_blocks
+			WHERE kind = 'block'
+			  AND ((blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1))
+		);
+	`
+
+	err = tx.QueryRow(ctx, blockQuery, userID, host).Scan(&blocked)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join linkup"})
+		return
+	}
+	if blocked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Linkup not found"})
+		return
+	}
+
 	// Update function to add second participant
 	updateQuery := `
 		UPDATE functions
@@ -523,6 +647,7 @@ Response:
 					"partner_id": null,  // or UUID when confirmed
 					"vibe": "casual",
 					"message": "Want to grab coffee?",
+					"tags": ["coffee-chat"],
 					"created_at": "2024-11-02T15:00:00Z",
 					"role": "initiator"  // or "joined"
 				},
@@ -567,6 +692,7 @@ func GetUserLinkups(c *gin.Context) {
 		       f.host1,
 		       f.vibe,
 		       f.function_name as message,
+		       ARRAY(SELECT ft.tag_id FROM function_tags ft WHERE ft.function_id = f.function_id ORDER BY ft.tag_id) as tags,
 		       f.starts_at,
 		       CASE 
 		           WHEN f.host = $1 THEN 'initiator'
@@ -591,6 +717,7 @@ func GetUserLinkups(c *gin.Context) {
 		PartnerID  *uuid.UUID `json:"partner_id"`
 		Vibe       string     `json:"vibe"`
 		Message    string     `json:"message"`
+		Tags       []string   `json:"tags"` // tag ids
 		CreatedAt  time.Time  `json:"created_at"`
 		Role       string     `json:"role"` // "initiator" or "joined"
 	}
@@ -600,7 +727,7 @@ func GetUserLinkups(c *gin.Context) {
 		var linkup UserLinkup
 		var host, host1 uuid.UUID
 
-		err := rows.Scan(&linkup.LinkupID, &host, &host1, &linkup.Vibe, &linkup.Message, &linkup.CreatedAt, &linkup.Role)
+		err := rows.Scan(&linkup.LinkupID, &host, &host1, &linkup.Vibe, &linkup.Message, &linkup.Tags, &linkup.CreatedAt, &linkup.Role)
 		if err != nil {
 			continue
 		}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"server/api/tags"
+
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/google/uuid"
@@ -44,7 +47,8 @@ Frontend Request:
 			},
 			"start_time": "2024-11-02T19:00:00Z",
 			"end_time": "2024-11-02T22:00:00Z",  // Optional
-			"vibe": "casual"
+			"vibe": "casual",
+			"tags": ["board-games"]  // Optional, up to 5 tag ids
 		}
 
 Response:
@@ -52,7 +56,7 @@ Response:
 		{
 			"function_id": "uuid-of-created-meetup"
 		}
-	- Bad Request: 400 (missing required fields)
+	- Bad Request: 400 (missing required fields, unknown or too many tags)
 	- Server Error: 500
 */
 func CreateMeetup(c *gin.Context) {
@@ -78,19 +82,39 @@ func CreateMeetup(c *gin.Context) {
 
 	fmt.Println(newMeetup.LocationName)
 
-	placeID := GetPlaceID(newMeetup.LocationName, newMeetup.LocationCoordinates)
-
-	query := `
-		INSERT INTO functions (host, function_type, place_id, function_name, starts_at, vibe) VALUES ($1, $6, $2, $3, $4, $5) RETURNING function_id;
-	`
-
 	db := c.MustGet("db").(*pgxpool.Pool)
 	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
 
 	defer cancel()
 
+	tagIDs, unknownTags, err := tags.Resolve(ctx, db, newMeetup.Tags, false)
+	if err != nil {
+		fmt.Println("Create Meetup Tag Error: " + err.Error())
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	if len(unknownTags) > 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Unknown tags: " + strings.Join(unknownTags, ", ")})
+		return
+	}
+	if len(tagIDs) > tags.MaxPerFunction {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d tags", tags.MaxPerFunction)})
+		return
+	}
+
+	placeID := GetPlaceID(newMeetup.LocationName, newMeetup.LocationCoordinates)
+
+	query := `
+		WITH created AS (
+			INSERT INTO functions (host, function_type, place_id, function_name, starts_at, vibe) VALUES ($1, $6, $2, $3, $4, $5) RETURNING function_id
+		), tagged AS (
+			INSERT INTO function_tags (function_id, tag_id) SELECT created.function_id, UNNEST($7::TEXT[]) FROM created
+		)
+		SELECT function_id FROM created;
+	`
+
 	var functionID string
-	err = db.QueryRow(ctx, query, newMeetup.Host, placeID, newMeetup.Name, newMeetup.StartTime, newMeetup.Vibe, "meetup").Scan(&functionID)
+	err = db.QueryRow(ctx, query, newMeetup.Host, placeID, newMeetup.Name, newMeetup.StartTime, newMeetup.Vibe, "meetup", tagIDs).Scan(&functionID)
 
 	if err != nil {
 		fmt.Println("Create Meetup Query Execution Error: " + err.Error())
@@ -139,7 +163,8 @@ Response:
 					"place_id": "ChIJ...",
 					"start_time": "2024-11-02T19:00:00Z",
 					"end_time": "2024-11-02T22:00:00Z",
-					"vibe": "casual"
+					"vibe": "casual",
+					"tags": ["board-games"]
 				},
 				...
 			]
@@ -168,7 +193,7 @@ func GetUserMeetups(c *gin.Context) {
 	defer cancel()
 
 	query := `
-		SELECT DISTINCT f.*
+		SELECT DISTINCT f.*, ARRAY(SELECT ft.tag_id FROM function_tags ft WHERE ft.function_id = f.function_id ORDER BY ft.tag_id)
 		FROM functions f
 		WHERE f.function_type = 'meetup'
 		AND (
@@ -201,7 +226,7 @@ func GetUserMeetups(c *gin.Context) {
 		meetup = FunctionData{
 			FunctionType: "meetup",
 		}
-		rows.Scan(&meetup.FunctionID, &meetup.Host, nil, nil, &meetup.PlaceID, &meetup.Name, &meetup.StartTime, &meetup.EndTime, &meetup.Vibe)
+		rows.Scan(&meetup.FunctionID, &meetup.Host, nil, nil, &meetup.PlaceID, &meetup.Name, &meetup.StartTime, &meetup.EndTime, &meetup.Vibe, &meetup.Tags)
 		meetups.Functions = append(meetups.Functions, meetup)
 	}
 
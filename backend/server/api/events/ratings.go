@@ -0,0 +1,362 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"server/api/media"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+=====================
+RATING ENDPOINTS
+=====================
+
+Once a meetup or linkup is over, the people who were there can rate each
+other 1 to 5, with optional tags. Only real co-attendees can rate each other:
+the host, the linkup's second host and anyone who said they were going. Each
+person rates each other person once per function, within two weeks of the
+end.
+
+A user's rating is a Bayesian average of every rating they got, kept in
+user_profiles by a trigger (see update_user_rating in defineTables.sql), and
+shown next to how many ratings it is based on.
+*/
+
+// Functions without an end time are taken to end this long after they start
+const defaultFunctionLength = 3 * time.Hour
+
+// How long after a function ends its attendees can still rate each other
+const ratingWindow = 14 * 24 * time.Hour
+
+const maxRatingTags = 5
+
+// The ratingtag enum in defineTables.sql
+var ratingTags = []string{"on time", "friendly", "fun", "respectful", "good conversation"}
+
+// functionParticipants lists everyone who was at function $1, for use in a WITH clause
+const functionParticipants = `
+	participants AS (
+		SELECT f.host AS user_id FROM functions f WHERE f.function_id = $1
+		UNION
+		SELECT f.host1 FROM functions f WHERE f.function_id = $1 AND f.host1 IS NOT NULL
+		UNION
+		SELECT a.user_id FROM function_attendees a
+		WHERE a.function_id = $1 AND a.attendance_status IN ('going', 'already there')
+	)
+`
+
+type RatingRequest struct {
+	UserID uuid.UUID `json:"user_id" binding:"required"`
+	Score  int       `json:"score" binding:"required"`
+	Tags   []string  `json:"tags"`
+}
+
+type GivenRating struct {
+	Score     int       `json:"score"`
+	Tags      []string  `json:"tags"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RateableAttendee struct {
+	UserID    uuid.UUID    `json:"user_id"`
+	Name      string       `json:"name"`
+	Username  string       `json:"username"`
+	AvatarURL string       `json:"avatar_url"`
+	MyRating  *GivenRating `json:"my_rating"` // null until rated
+}
+
+// normalizeRatingTags checks tags against the ratingtag enum and drops repeats.
+func normalizeRatingTags(tags []string) ([]string, error) {
+	if len(tags) > maxRatingTags {
+		return nil, fmt.Errorf("at most %d tags", maxRatingTags)
+	}
+
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		known := false
+		for _, ratingTag := range ratingTags {
+			known = known || tag == ratingTag
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown tag %q", tag)
+		}
+
+		repeated := false
+		for _, seen := range normalized {
+			repeated = repeated || tag == seen
+		}
+		if !repeated {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// functionRatingPeriod is when the attendees of a function can rate each
+// other, and whether userID was one of them. It returns pgx.ErrNoRows for
+// unknown functions.
+func functionRatingPeriod(ctx context.Context, db *pgxpool.Pool, functionID uuid.UUID, userID uuid.UUID) (time.Time, bool, error) {
+	query := `
+		WITH ` + functionParticipants + `
+		SELECT COALESCE(f.ends_at, f.starts_at + make_interval(secs => $3)),
+		       EXISTS (SELECT 1 FROM participants WHERE user_id = $2)
+		FROM functions f
+		WHERE f.function_id = $1;
+	`
+
+	var endsAt time.Time
+	var attended bool
+	err := db.QueryRow(ctx, query, functionID, userID, defaultFunctionLength.Seconds()).Scan(&endsAt, &attended)
+	return endsAt, attended, err
+}
+
+/*
+====================
+GetFunctionRatings
+
+Purpose: List the people the authenticated user can rate for a meetup or
+linkup they were at, with the rating they already gave each of them, if any.
+Ratings the user got are never shown here, only their average on profiles.
+
+Endpoint: GET /api/meetups/:id/ratings or GET /api/linkups/:id/ratings
+Authorization: Bearer token required
+
+Response:
+	- Success: 200 OK
+		{
+			"opens_at": "2024-11-02T22:00:00Z",   // when the function ended
+			"closes_at": "2024-11-16T22:00:00Z",
+			"tags": ["on time", "friendly", "fun", "respectful", "good conversation"],
+			"attendees": [
+				{
+					"user_id": "uuid",
+					"name": "Minimal User",
+					"username": "minimaluser",
+					"avatar_url": "https://.../medium.jpg",  // "" without a photo
+					"my_rating": {                          // null until rated
+						"score": 5,
+						"tags": ["on time"],
+						"created_at": "2024-11-03T10:00:00Z"
+					}
+				}
+			]
+		}
+	- Bad Request: 400 (invalid function ID)
+	- Not Found: 404 (no such function, or the user wasn't at it)
+	- Server Error: 500
+
+Notes:
+	- Functions without an end time count as ending 3 hours after they start
+	- Deleted accounts are left out
+*/
+func GetFunctionRatings(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	functionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	endsAt, attended, err := functionRatingPeriod(ctx, db, functionID, userID)
+	if err == pgx.ErrNoRows || (err == nil && !attended) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error loading function for ratings: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load ratings"})
+		return
+	}
+
+	query := `
+		WITH ` + functionParticipants + `
+		SELECT u.user_id, u.name, u.username, profile.avatar_key,
+		       r.score, r.tags::TEXT[], r.created_at
+		FROM participants p
+		JOIN users u ON u.user_id = p.user_id
+		JOIN user_profiles profile ON profile.user_id = u.user_id
+		LEFT JOIN function_ratings r
+		       ON r.function_id = $1 AND r.rater_id = $2 AND r.ratee_id = u.user_id
+		WHERE u.user_id <> $2
+		  AND u.deleted_at IS NULL
+		ORDER BY u.name, u.user_id;
+	`
+
+	rows, err := db.Query(ctx, query, functionID, userID)
+	if err != nil {
+		fmt.Printf("Error loading function attendees for ratings: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load ratings"})
+		return
+	}
+	defer rows.Close()
+
+	attendees := []RateableAttendee{}
+	for rows.Next() {
+		var attendee RateableAttendee
+		var avatarKey *string
+		var score *int
+		var tags []string
+		var ratedAt *time.Time
+		err := rows.Scan(&attendee.UserID, &attendee.Name, &attendee.Username, &avatarKey, &score, &tags, &ratedAt)
+		if err != nil {
+			fmt.Printf("Error scanning function attendee: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load ratings"})
+			return
+		}
+
+		attendee.AvatarURL = media.AvatarURL(c, avatarKey)
+		if score != nil {
+			attendee.MyRating = &GivenRating{Score: *score, Tags: tags, CreatedAt: *ratedAt}
+		}
+		attendees = append(attendees, attendee)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"opens_at":  endsAt,
+		"closes_at": endsAt.Add(ratingWindow),
+		"tags":      ratingTags,
+		"attendees": attendees,
+	})
+}
+
+/*
+====================
+RateAttendee
+
+Purpose: Rate someone the authenticated user was at a meetup or linkup with.
+
+Endpoint: POST /api/meetups/:id/ratings or POST /api/linkups/:id/ratings
+Authorization: Bearer token required
+
+Body (JSON):
+	{
+		"user_id": "uuid-of-the-person-rated",
+		"score": 5,                      // 1 to 5
+		"tags": ["on time", "friendly"]  // optional, up to 5 of the tags GetFunctionRatings lists
+	}
+
+Response:
+	- Success: 201 Created
+		{
+			"message": "Rating saved"
+		}
+	- Bad Request: 400 (invalid body, score or tag, rating yourself, or the
+	  person rated wasn't at the function)
+	- Not Found: 404 (no such function, or the user wasn't at it)
+	- Conflict: 409 (the function hasn't ended, the two weeks to rate are over,
+	  or the user already rated this person for this function)
+	- Server Error: 500
+
+Notes:
+	- Ratings can't be changed or taken back
+*/
+func RateAttendee(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	functionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	var request RatingRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	if request.Score < 1 || request.Score > 5 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Score must be between 1 and 5"})
+		return
+	}
+
+	tags, err := normalizeRatingTags(request.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tags: " + err.Error()})
+		return
+	}
+
+	if request.UserID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't rate yourself"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	endsAt, attended, err := functionRatingPeriod(ctx, db, functionID, userID)
+	if err == pgx.ErrNoRows || (err == nil && !attended) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error loading function for rating: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save rating"})
+		return
+	}
+
+	now := time.Now()
+	if now.Before(endsAt) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Ratings open once the function is over"})
+		return
+	}
+	if now.After(endsAt.Add(ratingWindow)) {
+		c.JSON(http.StatusConflict, gin.H{"error": "The time to rate this function is over"})
+		return
+	}
+
+	// The person rated has to have been there too; checked in the insert so
+	// nobody can be rated for a function they weren't at
+	query := `
+		WITH ` + functionParticipants + `
+		INSERT INTO function_ratings (function_id, rater_id, ratee_id, score, tags)
+		SELECT $1, $2, p.user_id, $4, $5::TEXT[]::ratingtag[]
+		FROM participants p
+		WHERE p.user_id = $3;
+	`
+
+	tag, err := db.Exec(ctx, query, functionID, userID, request.UserID, request.Score, tags)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			c.JSON(http.StatusConflict, gin.H{"error": "You already rated this person for this function"})
+			return
+		}
+		fmt.Printf("Error saving rating: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save rating"})
+		return
+	}
+
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can only rate people who were at this function"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Rating saved"})
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// exportSection is one part of a data export: a query taking the user id and
+// returning a single JSON value.
+type exportSection struct {
+	Name  string
+	Query string
+}
+
+// Everything the server keeps about a user. Add a section here when a new
+// table stores personal data.
+var exportSections = []exportSection{
+	{"user", `SELECT to_jsonb(u) - 'password_hash' FROM users u WHERE u.user_id = $1;`},
+	{"profile", `SELECT to_jsonb(p) - 'last_active_location' FROM user_profiles p WHERE p.user_id = $1;`},
+	{"friendships", `
+		SELECT COALESCE(jsonb_agg(to_jsonb(f)), '[]'::jsonb)
+		FROM friendships f WHERE $1 IN (f.user_id1, f.user_id2);
+	`},
+	{"hosted_functions", `
+		SELECT COALESCE(jsonb_agg(to_jsonb(f) ORDER BY f.starts_at), '[]'::jsonb)
+		FROM functions f WHERE $1 IN (f.host, f.host1);
+	`},
+	{"function_attendees", `
+		SELECT COALESCE(jsonb_agg(to_jsonb(a)), '[]'::jsonb)
+		FROM function_attendees a WHERE a.user_id = $1;
+	`},
+	{"tags", `
+		SELECT COALESCE(jsonb_agg(jsonb_build_object(
+			'tag_id', ut.tag_id,
+			'created_at', ut.created_at
+		) ORDER BY ut.created_at), '[]'::jsonb)
+		FROM user_tags ut WHERE ut.user_id = $1;
+	`},
+	{"ratings_given", `
+		SELECT COALESCE(jsonb_agg(to_jsonb(r) - 'rater_id' ORDER BY r.created_at), '[]'::jsonb)
+		FROM function_ratings r WHERE r.rater_id = $1;
+	`},
+	{"location", `
+		SELECT jsonb_build_object(
+			'latitude', ST_Y(p.last_active_location::geometry),
+			'longitude', ST_X(p.last_active_location::geometry),
+			'last_active', p.last_active
+		)
+		FROM user_profiles p WHERE p.user_id = $1;
+	`},
+	{"location_history", `
+		SELECT COALESCE(jsonb_agg(jsonb_build_object(
+			'latitude', ST_Y(h.location::geometry),
+			'longitude', ST_X(h.location::geometry),
+			'recorded_at', h.recorded_at
+		) ORDER BY h.recorded_at), '[]'::jsonb)
+		FROM location_history h WHERE h.user_id = $1;
+	`},
+	{"blocks", `
+		SELECT COALESCE(jsonb_agg(jsonb_build_object(
+			'user_id', b.blocked_id,
+			'kind', b.kind,
+			'created_at', b.created_at
+		) ORDER BY b.created_at), '[]'::jsonb)
+		FROM user_blocks b WHERE b.blocker_id = $1;
+	`},
+	{"reports", `
+		SELECT COALESCE(jsonb_agg(to_jsonb(r) - 'assigned_to' ORDER BY r.created_at), '[]'::jsonb)
+		FROM reports r WHERE r.reporter_id = $1;
+	`},
+}
+
+/*
+====================
+ExportUserData
+
+Purpose: Download everything the server stores about the authenticated user.
+The password hash is left out.
+
+Endpoint: GET /api/users/export
+Authorization: Bearer token required
+
+Query Params:
+	- format: "json" (default) or "zip", one file per section
+
+Response:
+	- Success: 200 OK (sent as an attachment)
+		{
+			"exported_at": "2024-11-02T15:00:00Z",
+			"user": { "user_id": "uuid", "username": "testuser1", ... },
+			"profile": { ... },
+			"friendships": [ ... ],
+			"hosted_functions": [ ... ],
+			"function_attendees": [ ... ],
+			"location": { "latitude": 42.27, "longitude": -83.74, "last_active": "..." },
+			"location_history": [ ... ],
+			"blocks": [ ... ],
+			"reports": [ ... ]
+		}
+	- Bad Request: 400 (unknown format)
+	- Server Error: 500
+*/
+func ExportUserData(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "zip" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "format must be json or zip"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	exportedAt := time.Now().UTC()
+	sections := make(map[string]json.RawMessage, len(exportSections))
+
+	for _, section := range exportSections {
+		var value []byte
+		if err := db.QueryRow(ctx, section.Query, userID).Scan(&value); err != nil {
+			fmt.Printf("Error exporting %s: %v\n", section.Name, err)
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		sections[section.Name] = value
+	}
+
+	filename := fmt.Sprintf("linkup-export-%s", exportedAt.Format("20060102-150405"))
+
+	if format == "zip" {
+		var archive bytes.Buffer
+		writer := zip.NewWriter(&archive)
+
+		for _, section := range exportSections {
+			var pretty bytes.Buffer
+			if err := json.Indent(&pretty, sections[section.Name], "", "  "); err != nil {
+				c.IndentedJSON(http.StatusInternalServerError, nil)
+				return
+			}
+
+			file, err := writer.CreateHeader(&zip.FileHeader{Name: section.Name + ".json", Method: zip.Deflate, Modified: exportedAt})
+			if err != nil {
+				c.IndentedJSON(http.StatusInternalServerError, nil)
+				return
+			}
+			if _, err := file.Write(pretty.Bytes()); err != nil {
+				c.IndentedJSON(http.StatusInternalServerError, nil)
+				return
+			}
+		}
+
+		if err := writer.Close(); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+		c.Data(http.StatusOK, "application/zip", archive.Bytes())
+		return
+	}
+
+	response := gin.H{"exported_at": exportedAt}
+	for name, value := range sections {
+		response[name] = value
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+	c.IndentedJSON(http.StatusOK, response)
+}
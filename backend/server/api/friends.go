@@ -0,0 +1,453 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"server/api/media"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+A row in friendships is either a pending request (requested_by sent it to the
+other user) or an accepted friendship. Rows store the two ids ordered, so
+look pairs up with
+	f.user_id1 = LEAST($1, $2)::UUID AND f.user_id2 = GREATEST($1, $2)::UUID
+and use requested_by to tell who asked.
+*/
+
+// Friend is the other user of a friendship or friend request.
+type Friend struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Name      string    `json:"name"`
+	Username  string    `json:"username"`
+	AvatarURL string    `json:"avatar_url"`
+	Since     time.Time `json:"since"` // when the friendship was accepted, or the request sent
+}
+
+// listFriendships lists the other users of the authenticated user's
+// friendships matching condition, which can use f and $1 (the user).
+func listFriendships(c *gin.Context, condition string, orderBy string) ([]Friend, error) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+		SELECT u.user_id, u.name, u.username, profile.avatar_key, COALESCE(f.accepted_at, f.requested_at)
+		FROM friendships f
+		JOIN users u ON u.user_id = CASE WHEN f.user_id1 = $1 THEN f.user_id2 ELSE f.user_id1 END
+		JOIN user_profiles profile ON profile.user_id = u.user_id
+		WHERE $1 IN (f.user_id1, f.user_id2) AND `+condition+`
+		ORDER BY `+orderBy+`;
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	friends := []Friend{}
+	for rows.Next() {
+		var friend Friend
+		var avatarKey *string
+		if err := rows.Scan(&friend.UserID, &friend.Name, &friend.Username, &avatarKey, &friend.Since); err != nil {
+			return nil, err
+		}
+		friend.AvatarURL = media.AvatarURL(c, avatarKey)
+		friends = append(friends, friend)
+	}
+	return friends, rows.Err()
+}
+
+/*
+====================
+GetFriends
+
+Purpose: The authenticated user's accepted friends, by name.
+
+Endpoint: GET /api/users/friend
+Authorization: Bearer token required
+
+Response:
+	- Success: 200 OK
+		{
+			"friends": [
+				{
+					"user_id": "uuid",
+					"name": "Minimal User",
+					"username": "minimaluser",
+					"avatar_url": "https://.../medium.jpg",  // "" without a photo
+					"since": "2024-11-02T15:00:00Z"
+				}
+			]
+		}
+	- Server Error: 500
+*/
+func GetFriends(c *gin.Context) {
+	friends, err := listFriendships(c, `f.friendship_status = 'accepted'`, `u.name, u.username`)
+	if err != nil {
+		fmt.Printf("Error listing friends: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"friends": friends})
+}
+
+/*
+====================
+ListIncomingFriendRequests
+
+Purpose: Friend requests other users sent the authenticated user, newest first.
+
+Endpoint: GET /api/users/friend/requests/incoming
+Authorization: Bearer token required
+
+Response:
+	- Success: 200 OK
+		{
+			"requests": [ ... ]  // same entries as GetFriends, "since" is when the request was sent
+		}
+	- Server Error: 500
+*/
+func ListIncomingFriendRequests(c *gin.Context) {
+	requests, err := listFriendships(c, `f.friendship_status = 'requested' AND f.requested_by <> $1`, `f.requested_at DESC`)
+	if err != nil {
+		fmt.Printf("Error listing friend requests: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"requests": requests})
+}
+
+/*
+====================
+ListOutgoingFriendRequests
+
+Purpose: Friend requests the authenticated user sent that haven't been
+answered yet, newest first.
+
+Endpoint: GET /api/users/friend/requests/outgoing
+Authorization: Bearer token required
+
+Response:
+	- Success: 200 OK (same body as ListIncomingFriendRequests)
+	- Server Error: 500
+*/
+func ListOutgoingFriendRequests(c *gin.Context) {
+	requests, err := listFriendships(c, `f.friendship_status = 'requested' AND f.requested_by = $1`, `f.requested_at DESC`)
+	if err != nil {
+		fmt.Printf("Error listing friend requests: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"requests": requests})
+}
+
+/*
+====================
+SendFriendRequest
+
+Purpose: Ask another user to be friends. If they already asked the
+authenticated user, this accepts their request instead.
+
+Endpoint: POST /api/users/friend
+Authorization: Bearer token required
+
+Body (JSON):
+	{
+		"friend_id": "uuid"
+	}
+
+Response:
+	- Success: 201 Created
+		{
+			"status": "requested"  // or "accepted" when it answered their request
+		}
+	- Bad Request: 400 (missing friend_id, or your own id)
+	- Not Found: 404 (no such user)
+	- Conflict: 409 (already friends, request already sent, or you blocked them)
+	- Server Error: 500
+
+Notes:
+	- Requests to someone who blocked the sender answer 201 but go nowhere
+*/
+func SendFriendRequest(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		FriendID uuid.UUID `json:"friend_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	if request.FriendID == userID {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "You can't send yourself a friend request"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	kind, err := blockKind(ctx, db, userID, request.FriendID)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	if kind == "block" {
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "Unblock this user first"})
+		return
+	}
+
+	// Requests to someone who blocked the sender look sent but go nowhere
+	kind, err = blockKind(ctx, db, request.FriendID, userID)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	if kind == "block" {
+		c.IndentedJSON(http.StatusCreated, gin.H{"status": "requested"})
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	var requestedBy uuid.UUID
+	err = tx.QueryRow(ctx, `
+		SELECT friendship_status::TEXT, requested_by FROM friendships
+		WHERE user_id1 = LEAST($1, $2)::UUID AND user_id2 = GREATEST($1, $2)::UUID
+		FOR UPDATE;
+	`, userID, request.FriendID).Scan(&status, &requestedBy)
+
+	switch {
+	case err == pgx.ErrNoRows:
+		_, err = tx.Exec(ctx, `
+			INSERT INTO friendships (user_id1, user_id2, friendship_status, requested_by)
+			VALUES (LEAST($1, $2)::UUID, GREATEST($1, $2)::UUID, 'requested', $1);
+		`, userID, request.FriendID)
+
+		if pgErr, ok := err.(*pgconn.PgError); ok {
+			switch pgErr.Code {
+			case "23503":
+				c.IndentedJSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			case "23505":
+				c.IndentedJSON(http.StatusConflict, gin.H{"error": "Friend request already sent"})
+				return
+			}
+		}
+		status = "requested"
+	case err != nil:
+		// answered below
+	case status == "accepted":
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "Already friends"})
+		return
+	case requestedBy == userID:
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "Friend request already sent"})
+		return
+	default:
+		// They asked first, so asking back is a yes
+		_, err = tx.Exec(ctx, `
+			UPDATE friendships SET friendship_status = 'accepted', accepted_at = NOW()
+			WHERE user_id1 = LEAST($1, $2)::UUID AND user_id2 = GREATEST($1, $2)::UUID;
+		`, userID, request.FriendID)
+		status = "accepted"
+	}
+
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		fmt.Printf("Error sending friend request: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, gin.H{"status": status})
+}
+
+/*
+====================
+AcceptFriendRequest
+
+Purpose: Accept or decline a friend request another user sent the
+authenticated user. Senders can't answer their own requests; they cancel
+them with CancelFriendRequest.
+
+Endpoint: PUT /api/users/friend
+Authorization: Bearer token required
+
+Body (JSON):
+	{
+		"friend_id": "uuid",  // who sent the request
+		"action": "accept"    // "accept" or "decline"
+	}
+
+Response:
+	- Success: 200 OK
+		{
+			"status": "accepted"  // or "declined"
+		}
+	- Bad Request: 400 (missing friend_id or unknown action)
+	- Not Found: 404 (no pending request from that user)
+	- Server Error: 500
+*/
+func AcceptFriendRequest(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		FriendID uuid.UUID `json:"friend_id" binding:"required"`
+		Action   string    `json:"action" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var query string
+	var status string
+	switch request.Action {
+	case "accept":
+		query = `
+			UPDATE friendships SET friendship_status = 'accepted', accepted_at = NOW()
+			WHERE user_id1 = LEAST($1, $2)::UUID AND user_id2 = GREATEST($1, $2)::UUID
+			  AND friendship_status = 'requested' AND requested_by = $2;
+		`
+		status = "accepted"
+	case "decline":
+		query = `
+			DELETE FROM friendships
+			WHERE user_id1 = LEAST($1, $2)::UUID AND user_id2 = GREATEST($1, $2)::UUID
+			  AND friendship_status = 'requested' AND requested_by = $2;
+		`
+		status = "declined"
+	default:
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Action must be accept or decline"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	result, err := db.Exec(ctx, query, userID, request.FriendID)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if result.RowsAffected() == 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "No friend request from this user"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"status": status})
+}
+
+/*
+====================
+CancelFriendRequest
+
+Purpose: Take back a friend request the authenticated user sent that hasn't
+been answered yet.
+
+Endpoint: DELETE /api/users/friend/requests/:id
+Authorization: Bearer token required
+
+Response:
+	- Success: 200 OK
+	- Bad Request: 400 (invalid user ID)
+	- Not Found: 404 (no pending request to that user)
+	- Server Error: 500
+*/
+func CancelFriendRequest(c *gin.Context) {
+	deleteFriendship(c, `friendship_status = 'requested' AND requested_by = $1`, "No friend request to this user", "Friend request cancelled")
+}
+
+/*
+====================
+RemoveFriend
+
+Purpose: Unfriend another user. They aren't told, and either of them can send
+a new request later.
+
+Endpoint: DELETE /api/users/friend/:id
+Authorization: Bearer token required
+
+Response:
+	- Success: 200 OK
+	- Bad Request: 400 (invalid user ID)
+	- Not Found: 404 (not friends)
+	- Server Error: 500
+*/
+func RemoveFriend(c *gin.Context) {
+	deleteFriendship(c, `friendship_status = 'accepted'`, "Not friends with this user", "Friend removed")
+}
+
+// deleteFriendship deletes the authenticated user's friendship with the user
+// in the :id param if it matches condition, which can use $1 (the user).
+func deleteFriendship(c *gin.Context, condition string, notFound string, message string) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	friendID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	result, err := db.Exec(ctx, `
+		DELETE FROM friendships
+		WHERE user_id1 = LEAST($1, $2)::UUID AND user_id2 = GREATEST($1, $2)::UUID AND `+condition+`;
+	`, userID, friendID)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if result.RowsAffected() == 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": notFound})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": message})
+}
@@ -0,0 +1,116 @@
+package geo
+
+import (
+	"math"
+)
+
+// Precision is how exactly a user's location may be shared with others. Raw
+// coordinates stay in the database for matching; only fuzzed points and
+// rounded distances leave the API.
+type Precision string
+
+const (
+	PrecisionExact  Precision = "exact"
+	Precision100m   Precision = "100m"
+	Precision500m   Precision = "500m"
+	PrecisionCampus Precision = "campus"
+
+	DefaultPrecision = Precision100m
+)
+
+const (
+	metersPerDegree = 111320.0
+	earthRadius     = 6371000.0
+
+	// How close a building has to be to stand in for a point at 100 m precision
+	buildingSnapRadius = 100.0
+)
+
+type Point struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+func (precision Precision) Valid() bool {
+	switch precision {
+	case PrecisionExact, Precision100m, Precision500m, PrecisionCampus:
+		return true
+	}
+	return false
+}
+
+// gridSize is the cell size in meters used when there is nothing better to snap to.
+func (precision Precision) gridSize() float64 {
+	switch precision {
+	case PrecisionExact:
+		return 0
+	case Precision500m:
+		return 500
+	case PrecisionCampus:
+		return 2000
+	default:
+		return 100
+	}
+}
+
+// distanceStep is what shared distances are rounded to.
+func (precision Precision) distanceStep() float64 {
+	switch precision {
+	case PrecisionExact:
+		return 10
+	case Precision500m:
+		return 500
+	case PrecisionCampus:
+		return 1000
+	default:
+		return 100
+	}
+}
+
+// SnapToGrid moves a point to the center of its cell in a grid of cellSize
+// meters. Everyone in the same cell gets the same point, so repeated readings
+// don't average out to the real one.
+func SnapToGrid(point Point, cellSize float64) Point {
+	if cellSize <= 0 {
+		return point
+	}
+
+	latitudeStep := cellSize / metersPerDegree
+	latitude := (math.Floor(point.Latitude/latitudeStep) + 0.5) * latitudeStep
+
+	// Longitude cells shrink towards the poles; size them at the snapped
+	// latitude so every point in a row agrees
+	longitudeStep := cellSize / (metersPerDegree * math.Max(math.Cos(latitude*math.Pi/180), 0.01))
+	longitude := (math.Floor(point.Longitude/longitudeStep) + 0.5) * longitudeStep
+
+	return Point{Latitude: latitude, Longitude: longitude}
+}
+
+// Distance is the great circle distance between two points in meters.
+func Distance(a Point, b Point) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLng := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// RoundDistance rounds a distance to the precision's step, and never below one
+// step so "right next to you" doesn't give anyone away.
+func RoundDistance(meters float64, precision Precision) float64 {
+	step := precision.distanceStep()
+	rounded := math.Round(meters/step) * step
+	if rounded < step {
+		rounded = step
+	}
+	return rounded
+}
+
+// RoundRadius rounds a search radius up to the precision's step, so searching
+// with ever finer radii can't find where a rounded distance changes.
+func RoundRadius(meters float64, precision Precision) float64 {
+	step := precision.distanceStep()
+	return math.Max(math.Ceil(meters/step), 1) * step
+}
@@ -0,0 +1,170 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+// The Diag, University of Michigan
+var diag = Point{Latitude: 42.2776, Longitude: -83.7382}
+
+// offset moves a point the given meters north and east.
+func offset(point Point, north float64, east float64) Point {
+	return Point{
+		Latitude:  point.Latitude + north/metersPerDegree,
+		Longitude: point.Longitude + east/(metersPerDegree*math.Cos(point.Latitude*math.Pi/180)),
+	}
+}
+
+func sharedAt(point Point, precision Precision) *SharedLocation {
+	return &SharedLocation{
+		Precision:    precision,
+		rawLatitude:  &point.Latitude,
+		rawLongitude: &point.Longitude,
+	}
+}
+
+func TestPrecisionSteps(t *testing.T) {
+	tests := []struct {
+		precision Precision
+		gridSize  float64
+		step      float64
+	}{
+		{PrecisionExact, 0, 10},
+		{Precision100m, 100, 100},
+		{Precision500m, 500, 500},
+		{PrecisionCampus, 2000, 1000},
+		{"bogus", 100, 100}, // treated as the default
+	}
+
+	for _, test := range tests {
+		if got := test.precision.gridSize(); got != test.gridSize {
+			t.Errorf("%s grid size = %v, want %v", test.precision, got, test.gridSize)
+		}
+		if got := test.precision.distanceStep(); got != test.step {
+			t.Errorf("%s distance step = %v, want %v", test.precision, got, test.step)
+		}
+	}
+}
+
+func TestSnapToGridIsStableWithinACell(t *testing.T) {
+	for _, cellSize := range []float64{100, 500, 2000} {
+		center := SnapToGrid(diag, cellSize)
+
+		// Anywhere in the cell snaps to its center
+		for _, move := range [][2]float64{{0, 0}, {0.45, 0.45}, {-0.45, -0.45}, {0.45, -0.45}, {-0.45, 0.45}} {
+			got := SnapToGrid(offset(center, move[0]*cellSize, move[1]*cellSize), cellSize)
+			if got != center {
+				t.Errorf("%v m cell: point %v cells from the center snapped to %v, want %v", cellSize, move, got, center)
+			}
+		}
+
+		// The next cell over has its own center, one cell away
+		for _, move := range [][2]float64{{1, 0}, {0, 1}, {-1, 0}, {0, -1}} {
+			got := SnapToGrid(offset(center, move[0]*cellSize, move[1]*cellSize), cellSize)
+			if got == center {
+				t.Errorf("%v m cell: point %v cells away snapped to the same center", cellSize, move)
+				continue
+			}
+			if distance := Distance(got, center); math.Abs(distance-cellSize) > cellSize*0.01 {
+				t.Errorf("%v m cell: neighboring center %v m away", cellSize, distance)
+			}
+		}
+	}
+
+	// No cell size, no snapping
+	for _, cellSize := range []float64{0, -1} {
+		if got := SnapToGrid(diag, cellSize); got != diag {
+			t.Errorf("cell size %v moved the point to %v", cellSize, got)
+		}
+	}
+}
+
+func TestRoundDistance(t *testing.T) {
+	tests := []struct {
+		meters    float64
+		precision Precision
+		want      float64
+	}{
+		{0, PrecisionExact, 10}, // never below one step
+		{14.9, PrecisionExact, 10},
+		{15, PrecisionExact, 20},
+		{123, PrecisionExact, 120},
+		{0, Precision100m, 100},
+		{10, Precision100m, 100},
+		{149.9, Precision100m, 100},
+		{150, Precision100m, 200},
+		{1049, Precision100m, 1000},
+		{249.9, Precision500m, 500},
+		{749.9, Precision500m, 500},
+		{750, Precision500m, 1000},
+		{1499.9, PrecisionCampus, 1000},
+		{1500, PrecisionCampus, 2000},
+		{149.9, "bogus", 100},
+	}
+
+	for _, test := range tests {
+		if got := RoundDistance(test.meters, test.precision); got != test.want {
+			t.Errorf("RoundDistance(%v, %s) = %v, want %v", test.meters, test.precision, got, test.want)
+		}
+	}
+}
+
+func TestRoundRadius(t *testing.T) {
+	tests := []struct {
+		meters    float64
+		precision Precision
+		want      float64
+	}{
+		{0, PrecisionExact, 10}, // never below one step
+		{10, PrecisionExact, 10},
+		{10.1, PrecisionExact, 20},
+		{0, Precision100m, 100},
+		{100, Precision100m, 100},
+		{101, Precision100m, 200},
+		{499, Precision500m, 500},
+		{501, Precision500m, 1000},
+		{1000, PrecisionCampus, 1000},
+		{1001, PrecisionCampus, 2000},
+	}
+
+	for _, test := range tests {
+		if got := RoundRadius(test.meters, test.precision); got != test.want {
+			t.Errorf("RoundRadius(%v, %s) = %v, want %v", test.meters, test.precision, got, test.want)
+		}
+	}
+}
+
+func TestSharedLocationWithin(t *testing.T) {
+	center := SnapToGrid(diag, Precision500m.gridSize())
+
+	// Near the corner of its 500 m cell, with the viewer further out past the
+	// corner: the real distance is under 480 m, the snapped one over 750 m
+	corner := offset(center, 240, 240)
+	pastCorner := offset(corner, 320, 320)
+	if raw := Distance(corner, pastCorner); raw >= 480 {
+		t.Fatalf("test points %v m apart, want under 480", raw)
+	}
+
+	tests := []struct {
+		name     string
+		location *SharedLocation
+		from     Point
+		radius   float64
+		want     bool
+	}{
+		{"same spot", sharedAt(diag, PrecisionExact), diag, 0, true},
+		{"rounds down into the radius", sharedAt(offset(diag, 104, 0), PrecisionExact), diag, 100, true},
+		{"rounds up out of the radius", sharedAt(offset(diag, 106, 0), PrecisionExact), diag, 100, false},
+		{"radius rounds up to the step", sharedAt(offset(diag, 106, 0), PrecisionExact), diag, 101, true},
+		{"inside the raw radius, outside the rounded one", sharedAt(corner, Precision500m), pastCorner, 480, false},
+		{"same point, wide enough radius", sharedAt(corner, Precision500m), pastCorner, 1000, true},
+		{"unknown location", &SharedLocation{Precision: PrecisionExact}, diag, 1e7, false},
+	}
+
+	for _, test := range tests {
+		if got := test.location.Within(test.from, test.radius); got != test.want {
+			t.Errorf("%s: Within(%v) = %v, want %v (rounded distance %v)", test.name, test.radius, got, test.want, test.location.DistanceFrom(test.from))
+		}
+	}
+}
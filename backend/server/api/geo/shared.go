@@ -0,0 +1,144 @@
+package geo
+
+/*
+SharedLocation is a user's last location as other users may see it. Queries
+select SharedLocationColumns and add SharedLocationJoins for a user_profiles
+row aliased "profile", then scan into ScanTargets:
+
+	query := `
+		SELECT u.name, ` + geo.SharedLocationColumns + `
+		FROM user_profiles profile
+		JOIN users u ON profile.user_id = u.user_id
+		` + geo.SharedLocationJoins + `
+		WHERE profile.user_id = $1;
+	`
+	err := db.QueryRow(ctx, query, userID).Scan(append([]any{&name}, location.ScanTargets()...)...)
+*/
+type SharedLocation struct {
+	Precision Precision
+
+	raw      *Point
+	building *Point
+	campus   *Point
+
+	rawLatitude, rawLongitude           *float64
+	buildingLatitude, buildingLongitude *float64
+	campusLatitude, campusLongitude     *float64
+}
+
+// SharedLocationColumns are the seven columns SharedLocation scans.
+const SharedLocationColumns = `
+	profile.location_precision::TEXT,
+	ST_Y(profile.last_active_location::geometry), ST_X(profile.last_active_location::geometry),
+	ST_Y(nearest_building.location::geometry), ST_X(nearest_building.location::geometry),
+	ST_Y(ST_Centroid(campus.area)), ST_X(ST_Centroid(campus.area))
+`
+
+// SharedLocationJoins finds what a location may snap to: the closest building
+// within 100 m and the centre of the user's campus.
+const SharedLocationJoins = `
+	LEFT JOIN LATERAL (
+		SELECT building.location
+		FROM buildings building
+		WHERE profile.last_active_location IS NOT NULL
+		  AND ST_DWithin(building.location, profile.last_active_location, 100)
+		ORDER BY building.location <-> profile.last_active_location
+		LIMIT 1
+	) nearest_building ON true
+	LEFT JOIN universities campus ON campus.university_id = profile.school_id
+`
+
+func (location *SharedLocation) ScanTargets() []any {
+	return []any{
+		&location.Precision,
+		&location.rawLatitude, &location.rawLongitude,
+		&location.buildingLatitude, &location.buildingLongitude,
+		&location.campusLatitude, &location.campusLongitude,
+	}
+}
+
+func point(latitude *float64, longitude *float64) *Point {
+	if latitude == nil || longitude == nil {
+		return nil
+	}
+	return &Point{Latitude: *latitude, Longitude: *longitude}
+}
+
+// Raw is the stored location, for internal matching only. Nil when unknown.
+func (location *SharedLocation) Raw() *Point {
+	location.resolve()
+	return location.raw
+}
+
+// Point is the location fuzzed to the owner's precision, or nil if unknown.
+//
+//	- exact: as stored
+//	- 100m: the nearest building within 100 m, or the center of a 100 m grid cell
+//	- 500m: the center of a 500 m grid cell
+//	- campus: the center of the user's campus, or of a 2 km grid cell
+func (location *SharedLocation) Point() *Point {
+	location.resolve()
+	if location.raw == nil {
+		return nil
+	}
+
+	precision := location.Precision
+	if !precision.Valid() {
+		precision = DefaultPrecision
+	}
+
+	switch {
+	case precision == PrecisionExact:
+		return location.raw
+	case precision == Precision100m && location.building != nil:
+		return location.building
+	case precision == PrecisionCampus && location.campus != nil:
+		return location.campus
+	}
+
+	snapped := SnapToGrid(*location.raw, precision.gridSize())
+	return &snapped
+}
+
+// Coarse is Point, but never finer than 500 m, for places that show a
+// location without anything to measure it against, like profiles.
+func (location *SharedLocation) Coarse() *Point {
+	switch location.Precision {
+	case Precision500m, PrecisionCampus:
+		return location.Point()
+	}
+
+	location.resolve()
+	if location.raw == nil {
+		return nil
+	}
+	snapped := SnapToGrid(*location.raw, Precision500m.gridSize())
+	return &snapped
+}
+
+// DistanceFrom is the rounded distance between the fuzzed location and a
+// point, or -1 if the location is unknown.
+func (location *SharedLocation) DistanceFrom(from Point) float64 {
+	shared := location.Point()
+	if shared == nil {
+		return -1
+	}
+	return RoundDistance(Distance(*shared, from), location.Precision)
+}
+
+// Within reports whether the fuzzed location is within radius of a point,
+// comparing the rounded distance against the radius rounded up to the same
+// step. Filtering on the raw distance would let a caller narrow the radius
+// until the location drops out and learn the exact distance.
+func (location *SharedLocation) Within(from Point, radius float64) bool {
+	distance := location.DistanceFrom(from)
+	return distance >= 0 && distance <= RoundRadius(radius, location.Precision)
+}
+
+func (location *SharedLocation) resolve() {
+	if location.raw == nil {
+		location.raw = point(location.rawLatitude, location.rawLongitude)
+		location.building = point(location.buildingLatitude, location.buildingLongitude)
+		location.campus = point(location.campusLatitude, location.campusLongitude)
+	}
+}
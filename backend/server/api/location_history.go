@@ -0,0 +1,430 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"server/api/geo"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+Location history is an append-only table partitioned by day (UTC). Not every
+reported location is kept: a point is stored when the user has moved at least
+locationSampleDistance since the last stored point, or locationSampleInterval
+has passed. The same sampling guards last_active_location. Points from the
+Location header go through LocationPipeline; explicit updates are written
+right away by recordLocation.
+
+Partitions are created a few days ahead and dropped whole once they fall out of
+LOCATION_HISTORY_RETENTION_DAYS (default 30). Points for a day without a
+partition land in location_history_default instead of failing the insert.
+*/
+
+const (
+	locationSampleDistance = 50.0 // meters
+	locationSampleInterval = 5 * time.Minute
+
+	defaultLocationRetention = 30 * 24 * time.Hour
+	locationPartitionsAhead  = 3
+)
+
+type locationSample struct {
+	point      geo.Point
+	recordedAt time.Time
+}
+
+// locationSampler remembers the last stored point of each user.
+type locationSampler struct {
+	mu      sync.Mutex
+	samples map[uuid.UUID]locationSample
+}
+
+var locationSamples = &locationSampler{samples: make(map[uuid.UUID]locationSample)}
+
+// due reports whether a new point is worth storing.
+func (sampler *locationSampler) due(userID uuid.UUID, point geo.Point, now time.Time) bool {
+	sampler.mu.Lock()
+	defer sampler.mu.Unlock()
+
+	last, found := sampler.samples[userID]
+	if !found {
+		return true
+	}
+	return now.Sub(last.recordedAt) >= locationSampleInterval || geo.Distance(last.point, point) >= locationSampleDistance
+}
+
+func (sampler *locationSampler) stored(userID uuid.UUID, point geo.Point, now time.Time) {
+	sampler.mu.Lock()
+	defer sampler.mu.Unlock()
+
+	sampler.samples[userID] = locationSample{point: point, recordedAt: now}
+
+	// Users who went quiet will be due anyway, no need to remember them
+	if len(sampler.samples) > 10000 {
+		for id, sample := range sampler.samples {
+			if now.Sub(sample.recordedAt) >= locationSampleInterval {
+				delete(sampler.samples, id)
+			}
+		}
+	}
+}
+
+func (sampler *locationSampler) forget(userID uuid.UUID) {
+	sampler.mu.Lock()
+	defer sampler.mu.Unlock()
+	delete(sampler.samples, userID)
+}
+
+// recordLocation stores a user's location as last_active_location and in
+// their history right away. It returns false when nothing was stored because
+// the user is in ghost mode. The time comes from the server's clock, like the
+// pipeline's, so the pipeline can tell its queued points are older.
+func recordLocation(ctx context.Context, db *pgxpool.Pool, userID uuid.UUID, point geo.Point) (bool, error) {
+	recordedAt := time.Now()
+
+	query := `
+		WITH visible AS (
+			UPDATE user_profiles
+			SET last_active_location = ST_SetSRID(ST_MakePoint($2, $3), 4326)::geography,
+				last_active = $4
+			WHERE user_id = $1
+			  AND (visibility <> 'hidden' OR hidden_until <= NOW())
+			RETURNING user_id, last_active, last_active_location
+		)
+		INSERT INTO location_history (user_id, recorded_at, location)
+		SELECT user_id, last_active, last_active_location FROM visible;
+	`
+
+	result, err := db.Exec(ctx, query, userID, point.Longitude, point.Latitude, recordedAt)
+	if err != nil {
+		return false, err
+	}
+
+	if result.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	locationSamples.stored(userID, point, recordedAt)
+	return true, nil
+}
+
+type LocationHistoryPoint struct {
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// historyRange reads the optional from/to query params (RFC 3339).
+func historyRange(c *gin.Context) (*time.Time, *time.Time, error) {
+	var from, to *time.Time
+	for name, target := range map[string]**time.Time{"from": &from, "to": &to} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s must be an RFC 3339 time", name)
+		}
+		*target = &parsed
+	}
+	return from, to, nil
+}
+
+/*
+====================
+GetLocationHistory
+
+Purpose: The authenticated user's own stored locations, newest first.
+
+Endpoint: GET /api/users/location/history
+Authorization: Bearer token required
+
+Query Params:
+	- from: RFC 3339 time, inclusive (optional)
+	- to: RFC 3339 time, exclusive (optional)
+	- limit: max points, default 500, max 5000 (optional)
+
+Response:
+	- Success: 200 OK
+		{
+			"retention_days": 30,
+			"points": [
+				{
+					"latitude": 42.2808,
+					"longitude": -83.743,
+					"recorded_at": "2024-11-02T15:00:00Z"
+				}
+			]
+		}
+	- Bad Request: 400 (from or to isn't RFC 3339)
+	- Server Error: 500
+*/
+func GetLocationHistory(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	from, to, err := historyRange(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "500"))
+	if err != nil || limit <= 0 || limit > 5000 {
+		limit = 500
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT ST_Y(location::geometry), ST_X(location::geometry), recorded_at
+		FROM location_history
+		WHERE user_id = $1
+		  AND ($2::TIMESTAMPTZ IS NULL OR recorded_at >= $2)
+		  AND ($3::TIMESTAMPTZ IS NULL OR recorded_at < $3)
+		ORDER BY recorded_at DESC
+		LIMIT $4;
+	`
+
+	rows, err := db.Query(ctx, query, userID, from, to, limit)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer rows.Close()
+
+	points := []LocationHistoryPoint{}
+	for rows.Next() {
+		var point LocationHistoryPoint
+		if err := rows.Scan(&point.Latitude, &point.Longitude, &point.RecordedAt); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		points = append(points, point)
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{
+		"retention_days": int(locationRetention().Hours() / 24),
+		"points":         points,
+	})
+}
+
+/*
+====================
+DeleteLocationHistory
+
+Purpose: Delete the authenticated user's stored locations, all of them or a
+time range. last_active_location is left alone.
+
+Endpoint: DELETE /api/users/location/history
+Authorization: Bearer token required
+
+Query Params:
+	- from: RFC 3339 time, inclusive (optional)
+	- to: RFC 3339 time, exclusive (optional)
+
+Response:
+	- Success: 200 OK
+		{
+			"deleted": 42
+		}
+	- Bad Request: 400 (from or to isn't RFC 3339)
+	- Server Error: 500
+*/
+func DeleteLocationHistory(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	from, to, err := historyRange(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query := `
+		DELETE FROM location_history
+		WHERE user_id = $1
+		  AND ($2::TIMESTAMPTZ IS NULL OR recorded_at >= $2)
+		  AND ($3::TIMESTAMPTZ IS NULL OR recorded_at < $3);
+	`
+
+	result, err := db.Exec(ctx, query, userID, from, to)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	// The next point shouldn't be skipped as a duplicate of one just deleted
+	locationSamples.forget(userID)
+
+	c.IndentedJSON(http.StatusOK, gin.H{"deleted": result.RowsAffected()})
+}
+
+// locationRetention reads LOCATION_HISTORY_RETENTION_DAYS.
+func locationRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("LOCATION_HISTORY_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		return defaultLocationRetention
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func locationPartitionName(day time.Time) string {
+	return "location_history_" + day.Format("20060102")
+}
+
+// createLocationPartition adds the partition for day unless it exists. Points
+// for that day already in the default partition are moved into it first, as
+// Postgres won't attach a partition whose range the default still holds rows for.
+func createLocationPartition(ctx context.Context, db *pgxpool.Pool, day time.Time) error {
+	name := locationPartitionName(day)
+
+	var exists bool
+	if err := db.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL;`, name).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	partition := pgx.Identifier{name}.Sanitize()
+	from, to := day, day.AddDate(0, 0, 1)
+
+	_, err = tx.Exec(ctx, `CREATE TABLE `+partition+` (LIKE location_history INCLUDING DEFAULTS INCLUDING CONSTRAINTS);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		WITH moved AS (
+			DELETE FROM location_history_default
+			WHERE recorded_at >= $1 AND recorded_at < $2
+			RETURNING user_id, recorded_at, location
+		)
+		INSERT INTO `+partition+` (user_id, recorded_at, location)
+		SELECT user_id, recorded_at, location FROM moved;
+	`, from, to)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf(
+		`ALTER TABLE location_history ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s');`,
+		partition, from.Format(time.RFC3339), to.Format(time.RFC3339),
+	))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// MaintainLocationHistory creates the daily partitions for the next few days
+// and drops the ones entirely past the retention period.
+func MaintainLocationHistory(ctx context.Context, db *pgxpool.Pool, retention time.Duration) error {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	for offset := 0; offset <= locationPartitionsAhead; offset++ {
+		if err := createLocationPartition(ctx, db, today.AddDate(0, 0, offset)); err != nil {
+			return err
+		}
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT child.relname
+		FROM pg_inherits
+		JOIN pg_class parent ON pg_inherits.inhparent = parent.oid
+		JOIN pg_class child ON pg_inherits.inhrelid = child.oid
+		WHERE parent.relname = 'location_history';
+	`)
+	if err != nil {
+		return err
+	}
+
+	partitions, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	// A partition can go once its whole day is older than the cutoff; points
+	// that landed in the default partition are deleted on their own
+	cutoff := time.Now().UTC().Add(-retention)
+	if _, err := db.Exec(ctx, `DELETE FROM location_history_default WHERE recorded_at < $1;`, cutoff); err != nil {
+		return err
+	}
+	for _, partition := range partitions {
+		day, err := time.Parse("location_history_20060102", partition)
+		if err != nil {
+			continue
+		}
+		if day.AddDate(0, 0, 1).After(cutoff) {
+			continue
+		}
+		if _, err := db.Exec(ctx, `DROP TABLE IF EXISTS `+pgx.Identifier{partition}.Sanitize()+`;`); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StartLocationHistoryMaintenance runs MaintainLocationHistory once before
+// returning, so today's partition exists before any request, and then every
+// interval until ctx is done.
+func StartLocationHistoryMaintenance(ctx context.Context, db *pgxpool.Pool, interval time.Duration) error {
+	retention := locationRetention()
+
+	if err := MaintainLocationHistory(ctx, db, retention); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			maintainCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+			if err := MaintainLocationHistory(maintainCtx, db, retention); err != nil {
+				fmt.Printf("Error maintaining location history partitions: %v\n", err)
+			}
+			cancel()
+		}
+	}()
+
+	return nil
+}
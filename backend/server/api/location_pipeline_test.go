@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"server/api/geo"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// A round trip to Postgres on the same network, for the benchmarks
+const benchmarkRoundTrip = 200 * time.Microsecond
+
+// countingStore stands in for the database. Every Query is one round trip;
+// it answers as if every user in the statement was written.
+type countingStore struct {
+	latency    time.Duration
+	roundTrips atomic.Int64
+	batchSizes []int
+}
+
+func (store *countingStore) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	store.roundTrips.Add(1)
+	if store.latency > 0 {
+		time.Sleep(store.latency)
+	}
+
+	switch userIDs := args[0].(type) {
+	case []uuid.UUID:
+		store.batchSizes = append(store.batchSizes, len(userIDs))
+		return &userIDRows{userIDs: append([]uuid.UUID(nil), userIDs...)}, nil
+	case uuid.UUID:
+		return &userIDRows{userIDs: []uuid.UUID{userIDs}}, nil
+	}
+	return nil, fmt.Errorf("unexpected first argument %T", args[0])
+}
+
+type userIDRows struct {
+	userIDs []uuid.UUID
+	next    int
+}
+
+func (rows *userIDRows) Close()     {}
+func (rows *userIDRows) Err() error { return nil }
+func (rows *userIDRows) CommandTag() pgconn.CommandTag {
+	return pgconn.NewCommandTag("INSERT 0 " + strconv.Itoa(len(rows.userIDs)))
+}
+func (rows *userIDRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (rows *userIDRows) Next() bool {
+	rows.next++
+	return rows.next <= len(rows.userIDs)
+}
+func (rows *userIDRows) Scan(dest ...any) error {
+	*dest[0].(*uuid.UUID) = rows.userIDs[rows.next-1]
+	return nil
+}
+func (rows *userIDRows) Values() ([]any, error) { return []any{rows.userIDs[rows.next-1]}, nil }
+func (rows *userIDRows) RawValues() [][]byte    { return nil }
+func (rows *userIDRows) Conn() *pgx.Conn        { return nil }
+
+func newUserIDs(count int) []uuid.UUID {
+	userIDs := make([]uuid.UUID, count)
+	for i := range userIDs {
+		userIDs[i] = uuid.New()
+	}
+	return userIDs
+}
+
+var annArbor = geo.Point{Latitude: 42.2808, Longitude: -83.7430}
+
+func TestLocationPipelineCoalescesAndBatches(t *testing.T) {
+	store := &countingStore{}
+	pipeline := NewLocationPipeline(store, time.Hour, 2, 100)
+	userIDs := newUserIDs(5)
+
+	// Three reports each; only the newest of each user is written
+	for round := 0; round < 3; round++ {
+		for _, userID := range userIDs {
+			pipeline.Submit(userID, annArbor)
+		}
+	}
+
+	if err := pipeline.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := pipeline.Stats()
+	if stats.Submitted.Load() != 15 || stats.Coalesced.Load() != 10 || stats.Written.Load() != 5 {
+		t.Errorf("stats = %s, want 15 reports, 10 coalesced, 5 written", stats)
+	}
+	if store.roundTrips.Load() != 3 || stats.RoundTrips.Load() != 3 {
+		t.Errorf("%d round trips, want 3 batches of at most 2", store.roundTrips.Load())
+	}
+	for _, size := range store.batchSizes {
+		if size > 2 {
+			t.Errorf("batch of %d users, want at most 2", size)
+		}
+	}
+
+	// Nothing queued, nothing sent
+	if err := pipeline.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if store.roundTrips.Load() != 3 {
+		t.Errorf("an empty flush made a round trip")
+	}
+}
+
+func TestLocationPipelineShedsLoad(t *testing.T) {
+	store := &countingStore{}
+	pipeline := NewLocationPipeline(store, time.Hour, 100, 3)
+	userIDs := newUserIDs(4)
+
+	for _, userID := range userIDs {
+		pipeline.Submit(userID, annArbor)
+	}
+	// A user already waiting still gets their newer point in
+	if !pipeline.Submit(userIDs[0], annArbor) {
+		t.Error("a waiting user's report was dropped")
+	}
+
+	if dropped := pipeline.Stats().Dropped.Load(); dropped != 1 {
+		t.Errorf("dropped %d reports, want 1", dropped)
+	}
+}
+
+func TestLocationPipelineDiscard(t *testing.T) {
+	store := &countingStore{}
+	pipeline := NewLocationPipeline(store, time.Hour, 100, 100)
+	userIDs := newUserIDs(2)
+
+	pipeline.Submit(userIDs[0], annArbor)
+	pipeline.Submit(userIDs[1], annArbor)
+	pipeline.Discard(userIDs[0])
+
+	if err := pipeline.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if written := pipeline.Stats().Written.Load(); written != 1 {
+		t.Errorf("wrote %d locations, want only the one not discarded", written)
+	}
+}
+
+/*
+BenchmarkLocationPipeline compares writing every Location header straight away
+(one UPDATE per report, what the server did before the pipeline) with queueing
+them in the pipeline. Each user reports over and over, as they would with the
+header on every request, and the pipeline is flushed every 2000 reports, about
+one flush interval of traffic. round-trips/report is the database load.
+
+	go test ./api -run '^$' -bench LocationPipeline
+*/
+func BenchmarkLocationPipeline(b *testing.B) {
+	const flushEvery = 2000
+	ctx := context.Background()
+
+	for _, users := range []int{100, 1000, 10000} {
+		userIDs := newUserIDs(users)
+
+		b.Run(fmt.Sprintf("users=%d/one_update_per_report", users), func(b *testing.B) {
+			store := &countingStore{latency: benchmarkRoundTrip}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rows, err := store.Query(ctx, "UPDATE user_profiles ...", userIDs[i%users], annArbor.Longitude, annArbor.Latitude, time.Now())
+				if err != nil {
+					b.Fatal(err)
+				}
+				rows.Close()
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(store.roundTrips.Load())/float64(b.N), "round-trips/report")
+		})
+
+		b.Run(fmt.Sprintf("users=%d/pipeline", users), func(b *testing.B) {
+			store := &countingStore{latency: benchmarkRoundTrip}
+			pipeline := NewLocationPipeline(store, time.Hour, 500, 50000)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				pipeline.Submit(userIDs[i%users], annArbor)
+				if (i+1)%flushEvery == 0 {
+					if err := pipeline.Flush(ctx); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			if err := pipeline.Flush(ctx); err != nil {
+				b.Fatal(err)
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(store.roundTrips.Load())/float64(b.N), "round-trips/report")
+		})
+	}
+}
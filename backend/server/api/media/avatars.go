@@ -0,0 +1,264 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+Avatars are stored as square JPEG thumbnails under
+avatars/<user_id>/<avatar_id>/<size>.jpg, and user_profiles.avatar_key holds
+the avatars/<user_id>/<avatar_id> part. Every upload gets a new avatar_id, so
+URLs can be cached forever and the old files are deleted once the new ones are
+in place.
+*/
+
+type avatarSize struct {
+	Name   string
+	Pixels int
+}
+
+// Largest first; the smaller sizes are scaled down from the largest
+var avatarSizes = []avatarSize{
+	{"large", 512},
+	{"medium", 256},
+	{"small", 64},
+}
+
+// The size lists of users get
+const defaultAvatarSize = "medium"
+
+func avatarFile(key string, size string) string {
+	return key + "/" + size + ".jpg"
+}
+
+// AvatarURL is the URL of the medium avatar stored under key (a
+// user_profiles.avatar_key), or "" for users without one.
+func AvatarURL(c *gin.Context, key *string) string {
+	if key == nil {
+		return ""
+	}
+	return c.MustGet("media").(Storage).URL(avatarFile(*key, defaultAvatarSize))
+}
+
+// AvatarURLs are the URLs of every size of the avatar stored under key, keyed
+// by size name, or nil for users without one.
+func AvatarURLs(c *gin.Context, key *string) map[string]string {
+	if key == nil {
+		return nil
+	}
+
+	storage := c.MustGet("media").(Storage)
+	urls := make(map[string]string, len(avatarSizes))
+	for _, size := range avatarSizes {
+		urls[size.Name] = storage.URL(avatarFile(*key, size.Name))
+	}
+	return urls
+}
+
+// RemoveAvatar deletes every size of the avatar stored under key. Failures
+// only leave unused files behind, so they are logged rather than returned.
+func RemoveAvatar(ctx context.Context, storage Storage, key string) {
+	for _, size := range avatarSizes {
+		if err := storage.Delete(ctx, avatarFile(key, size.Name)); err != nil {
+			fmt.Printf("Error deleting avatar %s: %v\n", avatarFile(key, size.Name), err)
+		}
+	}
+}
+
+/*
+====================
+UploadAvatar
+
+Purpose: Set the authenticated user's profile photo. The file's contents decide
+its type, whatever it is called or claims to be. It is turned the right way
+up, cropped to a square and stored as JPEG thumbnails in several sizes;
+re-encoding drops EXIF data, GPS coordinates included. The previous photo is
+deleted.
+
+Endpoint: POST /api/users/avatar
+Authorization: Bearer token required
+
+Body (multipart/form-data):
+	- avatar: a JPEG, PNG or GIF image of at most 15 MB and 24 megapixels
+
+Response:
+	- Success: 201 Created
+		{
+			"avatar_url": "https://.../avatars/<user_id>/<avatar_id>/medium.jpg",
+			"avatars": {
+				"large": ".../large.jpg",    // 512x512
+				"medium": ".../medium.jpg",  // 256x256
+				"small": ".../small.jpg"     // 64x64
+			}
+		}
+	- Bad Request: 400 (no avatar field)
+	- Request Entity Too Large: 413
+	- Unsupported Media Type: 415 (not a JPEG, PNG or GIF)
+	- Server Error: 500
+*/
+func UploadAvatar(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	// Room for the multipart framing around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxImageBytes+64<<10)
+
+	file, _, err := c.Request.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.IndentedJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Avatar must be at most 15 MB"})
+			return
+		}
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Send the image as the avatar field of a multipart form"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, MaxImageBytes+1))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+	if len(data) > MaxImageBytes {
+		c.IndentedJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Avatar must be at most 15 MB"})
+		return
+	}
+
+	img, orientation, err := decodeImage(data)
+	switch {
+	case errors.Is(err, ErrImageTooLarge):
+		c.IndentedJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Avatar must be at most 24 megapixels"})
+		return
+	case err != nil:
+		c.IndentedJSON(http.StatusUnsupportedMediaType, gin.H{"error": "Avatar must be a JPEG, PNG or GIF image"})
+		return
+	}
+
+	thumbnails := make(map[string][]byte, len(avatarSizes))
+	var largest image.Image
+	for _, size := range avatarSizes {
+		var thumbnail image.Image
+		if largest == nil {
+			thumbnail = squareThumbnail(img, orientation, size.Pixels)
+			largest = thumbnail
+		} else {
+			thumbnail = squareThumbnail(largest, 1, size.Pixels)
+		}
+
+		if thumbnails[size.Name], err = encodeJPEG(thumbnail); err != nil {
+			fmt.Printf("Error encoding avatar: %v\n", err)
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+	}
+
+	storage := c.MustGet("media").(Storage)
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	avatarKey := fmt.Sprintf("avatars/%s/%s", userID, uuid.New())
+	for _, size := range avatarSizes {
+		if err := storage.Put(ctx, avatarFile(avatarKey, size.Name), thumbnails[size.Name], "image/jpeg"); err != nil {
+			fmt.Printf("Error storing avatar: %v\n", err)
+			RemoveAvatar(ctx, storage, avatarKey)
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+	}
+
+	previousKey, err := replaceAvatarKey(ctx, db, userID, &avatarKey)
+	if err != nil {
+		fmt.Printf("Error saving avatar: %v\n", err)
+		RemoveAvatar(ctx, storage, avatarKey)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if previousKey != nil {
+		RemoveAvatar(ctx, storage, *previousKey)
+	}
+
+	c.IndentedJSON(http.StatusCreated, gin.H{
+		"avatar_url": AvatarURL(c, &avatarKey),
+		"avatars":    AvatarURLs(c, &avatarKey),
+	})
+}
+
+/*
+====================
+DeleteAvatar
+
+Purpose: Remove the authenticated user's profile photo.
+
+Endpoint: DELETE /api/users/avatar
+Authorization: Bearer token required
+
+Response:
+	- Success: 200 OK
+	- Not Found: 404 (no photo to remove)
+	- Server Error: 500
+*/
+func DeleteAvatar(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	storage := c.MustGet("media").(Storage)
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	previousKey, err := replaceAvatarKey(ctx, db, userID, nil)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if previousKey == nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "No avatar to remove"})
+		return
+	}
+
+	RemoveAvatar(ctx, storage, *previousKey)
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "Avatar removed"})
+}
+
+// replaceAvatarKey points a user's profile at a new avatar (or none) and
+// returns the key it pointed at before.
+func replaceAvatarKey(ctx context.Context, db *pgxpool.Pool, userID uuid.UUID, key *string) (*string, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var previousKey *string
+	err = tx.QueryRow(ctx, `SELECT avatar_key FROM user_profiles WHERE user_id = $1 FOR UPDATE;`, userID).Scan(&previousKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE user_profiles SET avatar_key = $2 WHERE user_id = $1;`, userID, key); err != nil {
+		return nil, err
+	}
+
+	return previousKey, tx.Commit(ctx)
+}
@@ -0,0 +1,212 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+
+	_ "image/gif"
+	_ "image/png"
+)
+
+const (
+	// Bigger uploads are rejected before they are decoded
+	MaxImageBytes = 15 << 20
+
+	// Guards against small files that decode into huge images. The whole
+	// image is decoded into memory, about 3 bytes a pixel for a JPEG and 4 to
+	// 8 for a PNG, so this keeps one upload under roughly 200 MB
+	maxImagePixels = 24_000_000
+)
+
+var (
+	ErrUnsupportedImage = errors.New("image must be a JPEG, PNG or GIF")
+	ErrImageTooLarge    = errors.New("image is too large")
+)
+
+// sniffImage is the image type of data going by its contents, whatever the
+// upload claimed. Only types the standard library decodes are allowed.
+func sniffImage(data []byte) (string, error) {
+	switch contentType := http.DetectContentType(data); contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return contentType, nil
+	}
+	return "", ErrUnsupportedImage
+}
+
+// decodeImage decodes an upload the right way up. Re-encoding what comes back
+// is what strips metadata: nothing read from the file, EXIF and GPS tags
+// included, survives into the encoded thumbnails.
+func decodeImage(data []byte) (image.Image, int, error) {
+	contentType, err := sniffImage(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, ErrUnsupportedImage
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxImagePixels {
+		return nil, 0, ErrImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, ErrUnsupportedImage
+	}
+
+	orientation := 1
+	if contentType == "image/jpeg" {
+		orientation = jpegOrientation(data)
+	}
+	return img, orientation, nil
+}
+
+// jpegOrientation reads the EXIF orientation tag (1 to 8) from a JPEG's APP1
+// segment, or 1 if there isn't one.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for offset := 2; offset+4 <= len(data); {
+		if data[offset] != 0xFF {
+			return 1
+		}
+		marker := data[offset+1]
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		if marker == 0xDA || length < 2 || offset+2+length > len(data) {
+			return 1 // start of scan: the metadata segments are over
+		}
+
+		segment := data[offset+4 : offset+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		offset += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation finds the orientation tag in the first IFD of a TIFF header.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orientedSize is the size of img once its EXIF orientation is applied.
+func orientedSize(img image.Image, orientation int) (int, int) {
+	bounds := img.Bounds()
+	if orientation >= 5 {
+		return bounds.Dy(), bounds.Dx()
+	}
+	return bounds.Dx(), bounds.Dy()
+}
+
+// orientedAt is the pixel at (x, y) of img once its EXIF orientation is applied.
+func orientedAt(img image.Image, orientation int, x int, y int) color.Color {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	switch orientation {
+	case 2:
+		x = w - 1 - x
+	case 3:
+		x, y = w-1-x, h-1-y
+	case 4:
+		y = h - 1 - y
+	case 5:
+		x, y = y, x
+	case 6:
+		x, y = y, h-1-x
+	case 7:
+		x, y = w-1-y, h-1-x
+	case 8:
+		x, y = w-1-y, x
+	}
+	return img.At(bounds.Min.X+x, bounds.Min.Y+y)
+}
+
+// squareThumbnail crops the middle square out of img, the right way up, and
+// scales it to size x size. Every output pixel is the average of the source
+// pixels it covers, and transparency is flattened onto white.
+func squareThumbnail(img image.Image, orientation int, size int) *image.RGBA {
+	width, height := orientedSize(img, orientation)
+	side := min(width, height)
+	left, top := (width-side)/2, (height-side)/2
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, size, size))
+	for ty := 0; ty < size; ty++ {
+		y0 := top + ty*side/size
+		y1 := max(top+(ty+1)*side/size, y0+1)
+
+		for tx := 0; tx < size; tx++ {
+			x0 := left + tx*side/size
+			x1 := max(left+(tx+1)*side/size, x0+1)
+
+			var r, g, b, a, count uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					pr, pg, pb, pa := orientedAt(img, orientation, x, y).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					count++
+				}
+			}
+
+			// Colors are premultiplied, so over white is adding the missing alpha
+			white := count*0xFFFF - a
+			thumbnail.SetRGBA(tx, ty, color.RGBA{
+				R: uint8((r + white) / count >> 8),
+				G: uint8((g + white) / count >> 8),
+				B: uint8((b + white) / count >> 8),
+				A: 0xFF,
+			})
+		}
+	}
+	return thumbnail
+}
+
+// encodeJPEG encodes a thumbnail with no metadata at all.
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return encoded.Bytes(), nil
+}
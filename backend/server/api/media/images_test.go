@@ -0,0 +1,249 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// exifJPEG is the start of a JPEG whose APP1 segment holds an EXIF
+// orientation tag, in the given TIFF byte order ("II" or "MM"). An APP0
+// segment comes first, as in most camera files.
+func exifJPEG(byteOrder string, orientation uint16) []byte {
+	var order binary.ByteOrder = binary.LittleEndian
+	if byteOrder == "MM" {
+		order = binary.BigEndian
+	}
+
+	tiff := make([]byte, 8+2+12+4)
+	copy(tiff, byteOrder)
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8) // first IFD right after the header
+	order.PutUint16(tiff[8:], 1) // one entry
+	entry := tiff[10:]
+	order.PutUint16(entry[0:], 0x0112) // Orientation
+	order.PutUint16(entry[2:], 3)      // SHORT
+	order.PutUint32(entry[4:], 1)      // one value
+	order.PutUint16(entry[8:], orientation)
+
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+
+	var data bytes.Buffer
+	data.Write([]byte{0xFF, 0xD8})
+	data.Write([]byte{0xFF, 0xE0, 0x00, 0x07, 'J', 'F', 'I', 'F', 0x00})
+	data.Write([]byte{0xFF, 0xE1})
+	binary.Write(&data, binary.BigEndian, uint16(len(app1)+2))
+	data.Write(app1)
+	data.Write([]byte{0xFF, 0xDA, 0x00, 0x02})
+	return data.Bytes()
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	for _, byteOrder := range []string{"II", "MM"} {
+		for orientation := uint16(1); orientation <= 8; orientation++ {
+			if got := jpegOrientation(exifJPEG(byteOrder, orientation)); got != int(orientation) {
+				t.Errorf("%s orientation %d read as %d", byteOrder, orientation, got)
+			}
+		}
+
+		// Out of range values mean no rotation
+		for _, orientation := range []uint16{0, 9, 0xFFFF} {
+			if got := jpegOrientation(exifJPEG(byteOrder, orientation)); got != 1 {
+				t.Errorf("%s orientation %d read as %d, want 1", byteOrder, orientation, got)
+			}
+		}
+	}
+}
+
+func TestJPEGOrientationWithoutExif(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":       nil,
+		"not a JPEG":  []byte("\x89PNG\r\n\x1a\n"),
+		"only SOI":    {0xFF, 0xD8},
+		"no APP1":     {0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x07, 'J', 'F', 'I', 'F', 0x00, 0xFF, 0xDA, 0x00, 0x02},
+		"bad marker":  {0xFF, 0xD8, 0x00, 0xE1, 0x00, 0x04, 0x00, 0x00},
+		"zero length": {0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x00},
+		"unknown TIFF byte order": func() []byte {
+			data := exifJPEG("II", 6)
+			copy(data[bytes.Index(data, []byte("II")):], "XX")
+			return data
+		}(),
+	}
+
+	for name, data := range tests {
+		if got := jpegOrientation(data); got != 1 {
+			t.Errorf("%s: orientation %d, want 1", name, got)
+		}
+	}
+}
+
+func TestJPEGOrientationTruncated(t *testing.T) {
+	for _, byteOrder := range []string{"II", "MM"} {
+		data := exifJPEG(byteOrder, 6)
+
+		// Every prefix must be read without panicking, and none holds a
+		// whole APP1 segment except the full file
+		for length := 0; length < len(data)-4; length++ {
+			if got := jpegOrientation(data[:length]); got != 1 {
+				t.Errorf("%s cut to %d bytes: orientation %d, want 1", byteOrder, length, got)
+			}
+		}
+	}
+
+	// A segment claiming an IFD past its end
+	tiff := []byte{'I', 'I', 42, 0, 0xFF, 0, 0, 0}
+	if got := exifOrientation(tiff); got != 1 {
+		t.Errorf("IFD offset past the end: orientation %d, want 1", got)
+	}
+
+	// An IFD claiming more entries than it has
+	tiff = []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 5, 0x01, 0x12}
+	if got := exifOrientation(tiff); got != 1 {
+		t.Errorf("short IFD: orientation %d, want 1", got)
+	}
+}
+
+// A 3x2 image whose pixels are all different, so any wrong transform shows:
+//
+//	a b c
+//	d e f
+var (
+	pixelA = color.RGBA{10, 0, 0, 255}
+	pixelB = color.RGBA{20, 0, 0, 255}
+	pixelC = color.RGBA{30, 0, 0, 255}
+	pixelD = color.RGBA{40, 0, 0, 255}
+	pixelE = color.RGBA{50, 0, 0, 255}
+	pixelF = color.RGBA{60, 0, 0, 255}
+)
+
+func asymmetricImage() *image.RGBA {
+	// Offset bounds, to catch code that assumes they start at 0,0
+	img := image.NewRGBA(image.Rect(5, 7, 8, 9))
+	for i, pixel := range []color.RGBA{pixelA, pixelB, pixelC, pixelD, pixelE, pixelF} {
+		img.SetRGBA(5+i%3, 7+i/3, pixel)
+	}
+	return img
+}
+
+// How each EXIF orientation is displayed (EXIF 2.3, tag 0x0112), row by row
+var orientedImages = map[int][][]color.RGBA{
+	1: {{pixelA, pixelB, pixelC}, {pixelD, pixelE, pixelF}},
+	2: {{pixelC, pixelB, pixelA}, {pixelF, pixelE, pixelD}},   // mirrored
+	3: {{pixelF, pixelE, pixelD}, {pixelC, pixelB, pixelA}},   // upside down
+	4: {{pixelD, pixelE, pixelF}, {pixelA, pixelB, pixelC}},   // flipped
+	5: {{pixelA, pixelD}, {pixelB, pixelE}, {pixelC, pixelF}}, // transposed
+	6: {{pixelD, pixelA}, {pixelE, pixelB}, {pixelF, pixelC}}, // turned clockwise
+	7: {{pixelF, pixelC}, {pixelE, pixelB}, {pixelD, pixelA}}, // transversed
+	8: {{pixelC, pixelF}, {pixelB, pixelE}, {pixelA, pixelD}}, // turned counterclockwise
+}
+
+func TestOrientedAt(t *testing.T) {
+	img := asymmetricImage()
+
+	for orientation := 1; orientation <= 8; orientation++ {
+		want := orientedImages[orientation]
+
+		width, height := orientedSize(img, orientation)
+		if width != len(want[0]) || height != len(want) {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", orientation, width, height, len(want[0]), len(want))
+			continue
+		}
+
+		for y, row := range want {
+			for x, pixel := range row {
+				if got := color.RGBAModel.Convert(orientedAt(img, orientation, x, y)); got != pixel {
+					t.Errorf("orientation %d: pixel %d,%d = %v, want %v", orientation, x, y, got, pixel)
+				}
+			}
+		}
+	}
+}
+
+func TestSquareThumbnailCropsTheMiddleTheRightWayUp(t *testing.T) {
+	img := asymmetricImage()
+
+	// Upright, the middle 2x2 of the 3x2 image starts at column 0 ((3-2)/2)
+	thumbnail := squareThumbnail(img, 1, 2)
+	want := [][]color.RGBA{{pixelA, pixelB}, {pixelD, pixelE}}
+	for y, row := range want {
+		for x, pixel := range row {
+			if got := thumbnail.RGBAAt(x, y); got != pixel {
+				t.Errorf("upright: pixel %d,%d = %v, want %v", x, y, got, pixel)
+			}
+		}
+	}
+
+	// Turned clockwise it is 2x3, and the crop keeps the top two rows
+	thumbnail = squareThumbnail(img, 6, 2)
+	want = [][]color.RGBA{{pixelD, pixelA}, {pixelE, pixelB}}
+	for y, row := range want {
+		for x, pixel := range row {
+			if got := thumbnail.RGBAAt(x, y); got != pixel {
+				t.Errorf("orientation 6: pixel %d,%d = %v, want %v", x, y, got, pixel)
+			}
+		}
+	}
+}
+
+func TestSquareThumbnailAverages(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 2, 2))
+	img.SetGray(0, 0, color.Gray{255})
+	img.SetGray(1, 1, color.Gray{255})
+
+	got := squareThumbnail(img, 1, 1).RGBAAt(0, 0)
+	if got.R < 127 || got.R > 128 || got.R != got.G || got.G != got.B || got.A != 255 {
+		t.Errorf("a black and white checkerboard averaged to %v, want mid gray", got)
+	}
+}
+
+func TestSquareThumbnailFlattensTransparencyOntoWhite(t *testing.T) {
+	tests := []struct {
+		name  string
+		pixel color.NRGBA
+		want  color.RGBA
+	}{
+		{"transparent", color.NRGBA{0, 0, 0, 0}, color.RGBA{255, 255, 255, 255}},
+		{"transparent red", color.NRGBA{255, 0, 0, 0}, color.RGBA{255, 255, 255, 255}},
+		{"opaque red", color.NRGBA{255, 0, 0, 255}, color.RGBA{255, 0, 0, 255}},
+		{"half transparent black", color.NRGBA{0, 0, 0, 128}, color.RGBA{127, 127, 127, 255}},
+		{"half transparent blue", color.NRGBA{0, 0, 255, 128}, color.RGBA{127, 127, 255, 255}},
+	}
+
+	for _, test := range tests {
+		img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 4; x++ {
+				img.SetNRGBA(x, y, test.pixel)
+			}
+		}
+
+		got := squareThumbnail(img, 1, 2).RGBAAt(1, 1)
+		if diff(got.R, test.want.R) > 1 || diff(got.G, test.want.G) > 1 || diff(got.B, test.want.B) > 1 || got.A != 255 {
+			t.Errorf("%s: %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func diff(a uint8, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestDecodeImageRejectsHugeImages(t *testing.T) {
+	// A PNG header claiming 10000x10000 pixels; DecodeConfig reads only this far
+	ihdr := []byte("IHDR\x00\x00\x27\x10\x00\x00\x27\x10\x08\x02\x00\x00\x00")
+	header := append([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0d"), ihdr...)
+	header = binary.BigEndian.AppendUint32(header, crc32.ChecksumIEEE(ihdr))
+
+	if _, _, err := decodeImage(header); err != ErrImageTooLarge {
+		t.Errorf("decodeImage of a 100 megapixel header: %v, want ErrImageTooLarge", err)
+	}
+	if _, _, err := decodeImage([]byte("GIF? not really")); err != ErrUnsupportedImage {
+		t.Errorf("decodeImage of text: %v, want ErrUnsupportedImage", err)
+	}
+}
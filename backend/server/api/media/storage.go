@@ -0,0 +1,140 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage keeps uploaded files under slash separated keys and knows the public
+// URL each one is served from. Handlers get the configured one from the
+// request context under "media".
+type Storage interface {
+	Put(ctx context.Context, key string, body []byte, contentType string) error
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
+
+// LocalStorage writes files under Dir. The server serves Dir at LocalRoute;
+// BaseURL is what clients are told, which is LocalRoute unless something else
+// (a CDN, another host) sits in front.
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+// LocalRoute is where the server serves a LocalStorage's files.
+const LocalRoute = "/media"
+
+func (s *LocalStorage) path(key string) (string, error) {
+	path := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(s.Dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid media key %q", key)
+	}
+	return path, nil
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// Write next to the target and rename, so nobody is served half a file
+	temp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(body); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	// Drop directories the delete left empty; Remove fails on the rest
+	for dir := filepath.Dir(path); dir != filepath.Clean(s.Dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *LocalStorage) URL(key string) string {
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + key
+}
+
+/*
+StorageFromEnv picks a storage backend from MEDIA_DRIVER:
+
+	- local (default): files under MEDIA_DIR (./media), served by this server
+	  at /media
+	- s3: any S3-compatible bucket (AWS, MinIO, R2, ...), configured with
+	  S3_ENDPOINT, S3_BUCKET, S3_REGION (us-east-1), S3_ACCESS_KEY_ID and
+	  S3_SECRET_ACCESS_KEY. Objects must be publicly readable, e.g. with
+	  "mc anonymous set download" on MinIO.
+
+MEDIA_URL overrides the public URL files are served from.
+*/
+func StorageFromEnv() (Storage, error) {
+	baseURL := os.Getenv("MEDIA_URL")
+
+	switch driver := os.Getenv("MEDIA_DRIVER"); driver {
+	case "", "local":
+		dir := os.Getenv("MEDIA_DIR")
+		if dir == "" {
+			dir = "media"
+		}
+		if baseURL == "" {
+			baseURL = LocalRoute
+		}
+		return &LocalStorage{Dir: dir, BaseURL: baseURL}, nil
+	case "s3":
+		storage := &S3Storage{
+			Endpoint:        strings.TrimSuffix(os.Getenv("S3_ENDPOINT"), "/"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			Region:          os.Getenv("S3_REGION"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			BaseURL:         baseURL,
+		}
+		if storage.Endpoint == "" || storage.Bucket == "" || storage.AccessKeyID == "" || storage.SecretAccessKey == "" {
+			return nil, fmt.Errorf("MEDIA_DRIVER=s3 needs S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY")
+		}
+		if storage.Region == "" {
+			storage.Region = "us-east-1"
+		}
+		if storage.BaseURL == "" {
+			storage.BaseURL = storage.Endpoint + "/" + storage.Bucket
+		}
+		return storage, nil
+	default:
+		return nil, fmt.Errorf("unknown MEDIA_DRIVER %q", driver)
+	}
+}
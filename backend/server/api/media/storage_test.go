@@ -0,0 +1,39 @@
+package media
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStoragePathStaysInDir(t *testing.T) {
+	storage := &LocalStorage{Dir: t.TempDir()}
+
+	for _, key := range []string{
+		"../outside.jpg",
+		"../../etc/passwd",
+		"avatars/../../outside.jpg",
+		"avatars/../..",
+		"..",
+		"",
+		".",
+	} {
+		if path, err := storage.path(key); err == nil {
+			t.Errorf("path(%q) = %s, want an error", key, path)
+		}
+	}
+
+	for _, key := range []string{
+		"avatars/abc/large.jpg",
+		"avatars/abc/../def/small.jpg",
+	} {
+		path, err := storage.path(key)
+		if err != nil {
+			t.Errorf("path(%q): %v", key, err)
+			continue
+		}
+		if !strings.HasPrefix(path, storage.Dir+string(filepath.Separator)) {
+			t.Errorf("path(%q) = %s, outside %s", key, path, storage.Dir)
+		}
+	}
+}
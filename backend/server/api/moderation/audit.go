@@ -0,0 +1,125 @@
+package moderation
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AuditEntry is one moderator action. Entries are written in the same
+// transaction as the action and never change afterwards.
+type AuditEntry struct {
+	EntryID     int64      `json:"entry_id"`
+	ModeratorID uuid.UUID  `json:"moderator_id"`
+	Action      string     `json:"action"`
+	ReportID    *uuid.UUID `json:"report_id"`
+	TargetType  string     `json:"target_type"`
+	TargetID    *uuid.UUID `json:"target_id"`
+	Details     string     `json:"details"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// WriteAudit records a moderator action in tx.
+func WriteAudit(ctx context.Context, tx pgx.Tx, entry AuditEntry) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO moderation_audit_log (moderator_id, action, report_id, target_type, target_id, details)
+		VALUES ($1, $2, $3, $4, $5, $6);
+	`, entry.ModeratorID, entry.Action, entry.ReportID, entry.TargetType, entry.TargetID, entry.Details)
+	return err
+}
+
+/*
+====================
+ListModerationAudit
+
+Purpose: Admin view of the moderation audit log, newest first.
+
+Endpoint: GET /api/admin/moderation-audit
+Authorization: Bearer token required (moderator or superadmin)
+
+Query Params:
+	- moderator_id: only actions by this moderator (optional)
+	- target_id: only actions on this user or function (optional)
+	- report_id: only actions on this report (optional)
+	- limit: max entries, default 100, max 500 (optional)
+
+Response:
+	- Success: 200 OK
+		{
+			"entries": [
+				{
+					"entry_id": 7,
+					"moderator_id": "uuid",
+					"action": "suspend",
+					"report_id": "uuid",
+					"target_type": "user",
+					"target_id": "uuid",
+					"details": "suspended for 72h: repeated harassment",
+					"created_at": "2024-11-02T15:00:00Z"
+				}
+			]
+		}
+	- Bad Request: 400 (invalid id)
+	- Server Error: 500
+*/
+func ListModerationAudit(c *gin.Context) {
+	filters := make([]*uuid.UUID, 3)
+	for i, name := range []string{"moderator_id", "target_id", "report_id"} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := uuid.Parse(value)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+			return
+		}
+		filters[i] = &parsed
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT entry_id, moderator_id, action, report_id, target_type, target_id, details, created_at
+		FROM moderation_audit_log
+		WHERE ($1::UUID IS NULL OR moderator_id = $1)
+		  AND ($2::UUID IS NULL OR target_id = $2)
+		  AND ($3::UUID IS NULL OR report_id = $3)
+		ORDER BY entry_id DESC
+		LIMIT $4;
+	`
+
+	rows, err := db.Query(ctx, query, filters[0], filters[1], filters[2], limit)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		err := rows.Scan(&entry.EntryID, &entry.ModeratorID, &entry.Action, &entry.ReportID,
+			&entry.TargetType, &entry.TargetID, &entry.Details, &entry.CreatedAt)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		entries = append(entries, entry)
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"entries": entries})
+}
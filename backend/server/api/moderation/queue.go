@@ -0,0 +1,545 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/api/notify"
+	auth "server/api/userauth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+====================
+ListReports
+
+Purpose: The moderation queue. Oldest reports first, so nothing waits forever.
+
+Endpoint: GET /api/admin/reports
+Authorization: Bearer token required (moderator or superadmin)
+
+Query Params:
+	- status: "open", "assigned", "resolved" or "dismissed" (optional, default open and assigned)
+	- target_type: "user", "meetup" or "linkup" (optional)
+	- assigned_to: a moderator's user id, or "me" (optional)
+	- limit: max reports, default 50, max 200 (optional)
+
+Response:
+	- Success: 200 OK
+		{
+			"reports": [
+				{
+					"report_id": "uuid",
+					"reporter_id": "uuid",
+					"target_type": "user",
+					"target_id": "uuid",
+					"target_name": "someone",
+					"reason": "harassment",
+					"details": "Kept messaging me after I said no",
+					"status": "open",
+					"assigned_to": null,
+					"resolution": "",
+					"created_at": "2024-11-02T15:00:00Z",
+					"resolved_at": null
+				}
+			]
+		}
+	- Bad Request: 400 (invalid assigned_to)
+	- Server Error: 500
+*/
+func ListReports(c *gin.Context) {
+	var assignedTo *uuid.UUID
+	if value := c.Query("assigned_to"); value != "" {
+		if value == "me" {
+			value = c.MustGet("user_id").(string)
+		}
+		parsed, err := uuid.Parse(value)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid assigned_to"})
+			return
+		}
+		assignedTo = &parsed
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT ` + reportColumns + `
+		FROM reports r
+		` + reportJoins + `
+		WHERE (($1 = '' AND r.status IN ('open', 'assigned')) OR r.status::TEXT = $1)
+		  AND ($2 = '' OR r.target_type::TEXT = $2)
+		  AND ($3::UUID IS NULL OR r.assigned_to = $3)
+		ORDER BY r.created_at
+		LIMIT $4;
+	`
+
+	rows, err := db.Query(ctx, query, c.Query("status"), c.Query("target_type"), assignedTo, limit)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		reports = append(reports, report)
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"reports": reports})
+}
+
+// lockOpenReport loads a report for update, answering the request itself and
+// returning false when it doesn't exist or is already closed.
+func lockOpenReport(c *gin.Context, ctx context.Context, tx pgx.Tx) (Report, bool) {
+	reportID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid report ID"})
+		return Report{}, false
+	}
+
+	report, err := scanReport(tx.QueryRow(ctx, `
+		SELECT `+reportColumns+`
+		FROM reports r
+		`+reportJoins+`
+		WHERE r.report_id = $1
+		FOR UPDATE OF r;
+	`, reportID))
+
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "Report not found"})
+		return report, false
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return report, false
+	}
+
+	if report.Status == "resolved" || report.Status == "dismissed" {
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "Report is already closed"})
+		return report, false
+	}
+	return report, true
+}
+
+/*
+====================
+AssignReport
+
+Purpose: Take a report off the open queue, for yourself or another moderator.
+
+Endpoint: POST /api/admin/reports/:id/assign
+Authorization: Bearer token required (moderator or superadmin)
+
+Body (JSON):
+	{
+		"moderator_id": "uuid"  // optional, default yourself
+	}
+
+Response:
+	- Success: 200 OK (the report)
+	- Bad Request: 400 (invalid report id)
+	- Not Found: 404 (no such report)
+	- Conflict: 409 (report already closed)
+	- Server Error: 500
+*/
+func AssignReport(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		ModeratorID *uuid.UUID `json:"moderator_id"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	assignee := moderatorID
+	if request.ModeratorID != nil {
+		assignee = *request.ModeratorID
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	report, ok := lockOpenReport(c, ctx, tx)
+	if !ok {
+		return
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE reports SET status = 'assigned', assigned_to = $2 WHERE report_id = $1;`, report.ReportID, assignee)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	err = WriteAudit(ctx, tx, AuditEntry{
+		ModeratorID: moderatorID,
+		Action:      "assign",
+		ReportID:    &report.ReportID,
+		TargetType:  report.TargetType,
+		TargetID:    &report.TargetID,
+		Details:     "assigned to " + assignee.String(),
+	})
+	if err != nil {
+		fmt.Printf("Error writing moderation audit: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	report.Status = "assigned"
+	report.AssignedTo = &assignee
+	c.IndentedJSON(http.StatusOK, report)
+}
+
+/*
+====================
+ResolveReport
+
+Purpose: Close a report without acting on it: resolved (handled some other way)
+or dismissed (nothing wrong).
+
+Endpoint: POST /api/admin/reports/:id/resolve
+Authorization: Bearer token required (moderator or superadmin)
+
+Body (JSON):
+	{
+		"outcome": "dismissed",  // or "resolved"
+		"note": "Friendly banter, both users confirmed"
+	}
+
+Response:
+	- Success: 200 OK (the report)
+	- Bad Request: 400 (invalid report id or outcome)
+	- Not Found: 404 (no such report)
+	- Conflict: 409 (report already closed)
+	- Server Error: 500
+*/
+func ResolveReport(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		Outcome string `json:"outcome" binding:"required"`
+		Note    string `json:"note"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	if request.Outcome != "resolved" && request.Outcome != "dismissed" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Outcome must be resolved or dismissed"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	report, ok := lockOpenReport(c, ctx, tx)
+	if !ok {
+		return
+	}
+
+	note := strings.TrimSpace(request.Note)
+	closed, err := closeReports(ctx, tx, report, moderatorID, request.Outcome, note, false)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	err = WriteAudit(ctx, tx, AuditEntry{
+		ModeratorID: moderatorID,
+		Action:      request.Outcome,
+		ReportID:    &report.ReportID,
+		TargetType:  report.TargetType,
+		TargetID:    &report.TargetID,
+		Details:     note,
+	})
+	if err != nil {
+		fmt.Printf("Error writing moderation audit: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, closed)
+}
+
+// closeReports closes report, and with sameTarget every other open report
+// about the same user or function, returning report as closed.
+func closeReports(ctx context.Context, tx pgx.Tx, report Report, moderatorID uuid.UUID, status string, resolution string, sameTarget bool) (Report, error) {
+	err := tx.QueryRow(ctx, `
+		UPDATE reports
+		SET status = $4, resolution = $5, resolved_at = NOW(), assigned_to = COALESCE(assigned_to, $3)
+		WHERE report_id = $1
+		   OR ($6 AND target_id = $2 AND status IN ('open', 'assigned'))
+		RETURNING resolved_at;
+	`, report.ReportID, report.TargetID, moderatorID, status, resolution, sameTarget).Scan(&report.ResolvedAt)
+
+	report.Status = status
+	report.Resolution = resolution
+	if report.AssignedTo == nil {
+		report.AssignedTo = &moderatorID
+	}
+	return report, err
+}
+
+/*
+====================
+ActionReport
+
+Purpose: Act on a report and close it, along with any other open reports about
+the same user or function.
+
+	- warn: email the reported user (or the function's host) a warning
+	- suspend: the reported user (or host) is logged out everywhere, hidden, and
+	  can't log in until the suspension ends or is lifted
+	- remove_function: delete the reported meetup or linkup
+
+Endpoint: POST /api/admin/reports/:id/action
+Authorization: Bearer token required (moderator or superadmin)
+
+Body (JSON):
+	{
+		"action": "suspend",  // or "warn", "remove_function"
+		"note": "Repeated harassment",  // included in the warning email
+		"duration_hours": 72  // optional, suspend only; omit to suspend until lifted
+	}
+
+Response:
+	- Success: 200 OK (the report)
+	- Bad Request: 400 (unknown action, remove_function on a user report, bad duration, or acting on yourself)
+	- Forbidden: 403 (suspending a staff account, unless you're a superadmin)
+	- Not Found: 404 (no such report, or the reported user or function is gone)
+	- Conflict: 409 (report already closed)
+	- Server Error: 500
+*/
+func ActionReport(c *gin.Context) {
+	moderatorID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		Action        string `json:"action" binding:"required"`
+		Note          string `json:"note"`
+		DurationHours int    `json:"duration_hours"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	request.Note = strings.TrimSpace(request.Note)
+
+	switch request.Action {
+	case "warn", "remove_function":
+		if request.DurationHours != 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Only suspensions take a duration"})
+			return
+		}
+	case "suspend":
+		if request.DurationHours < 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Duration can't be negative"})
+			return
+		}
+	default:
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Action must be warn, suspend or remove_function"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	report, ok := lockOpenReport(c, ctx, tx)
+	if !ok {
+		return
+	}
+
+	if request.Action == "remove_function" && report.TargetType == "user" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Only meetup and linkup reports can have their function removed"})
+		return
+	}
+
+	// Warnings and suspensions land on the reported user, or whoever hosts
+	// the reported function
+	var subject uuid.UUID
+	var subjectEmail, subjectRole string
+	err = tx.QueryRow(ctx, `
+		SELECT u.user_id, u.email, u.role::TEXT
+		FROM users u
+		WHERE u.user_id = CASE WHEN $2 = 'user' THEN $1 ELSE (SELECT host FROM functions WHERE function_id = $1) END;
+	`, report.TargetID, report.TargetType).Scan(&subject, &subjectEmail, &subjectRole)
+
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "The reported " + report.TargetType + " no longer exists"})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if subject == moderatorID && request.Action != "remove_function" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "You can't act on a report about yourself"})
+		return
+	}
+	if subjectRole != auth.RoleUser && request.Action == "suspend" && c.GetString("role") != auth.RoleSuperadmin {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Only superadmins can suspend staff accounts"})
+		return
+	}
+
+	var details string
+	switch request.Action {
+	case "warn":
+		details = "warned"
+
+	case "suspend":
+		var until *time.Time
+		details = "suspended until lifted"
+		if request.DurationHours > 0 {
+			end := time.Now().Add(time.Duration(request.DurationHours) * time.Hour)
+			until = &end
+			details = fmt.Sprintf("suspended for %dh", request.DurationHours)
+		}
+
+		if _, err = auth.SuspendAccount(ctx, tx, subject, until); err != nil {
+			fmt.Printf("Error suspending account: %v\n", err)
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+
+	case "remove_function":
+		details = "removed " + report.TargetType + " " + report.TargetName
+		if _, err = tx.Exec(ctx, `DELETE FROM functions WHERE function_id = $1;`, report.TargetID); err != nil {
+			fmt.Printf("Error removing function: %v\n", err)
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+	}
+
+	if request.Note != "" {
+		details += ": " + request.Note
+	}
+
+	closed, err := closeReports(ctx, tx, report, moderatorID, "resolved", details, true)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	err = WriteAudit(ctx, tx, AuditEntry{
+		ModeratorID: moderatorID,
+		Action:      request.Action,
+		ReportID:    &report.ReportID,
+		TargetType:  report.TargetType,
+		TargetID:    &report.TargetID,
+		Details:     details,
+	})
+	if err != nil {
+		fmt.Printf("Error writing moderation audit: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	// Both of these only follow a committed action, and the action stands
+	// even if they fail
+	switch request.Action {
+	case "suspend":
+		if _, err := auth.RevokeUserSessions(ctx, db, subject); err != nil {
+			fmt.Printf("Error logging out suspended user %s: %v\n", subject, err)
+		}
+	case "warn":
+		mailer := c.MustGet("mailer").(notify.Mailer)
+		if err := mailer.SendMail(ctx, subjectEmail, "A warning from LinkUp moderators", warningBody(report, request.Note)); err != nil {
+			fmt.Printf("Error sending warning to %s: %v\n", subject, err)
+		}
+	}
+
+	c.IndentedJSON(http.StatusOK, closed)
+}
+
+func warningBody(report Report, note string) string {
+	about := "your account"
+	if report.TargetType != "user" {
+		about = fmt.Sprintf("your %s \"%s\"", report.TargetType, report.TargetName)
+	}
+
+	body := fmt.Sprintf("Someone reported %s for %s, and a moderator agreed it goes against the community guidelines.", about, report.Reason)
+	if note != "" {
+		body += "\n\nModerator's note: " + note
+	}
+	return body + "\n\nRepeated reports can lead to your account being suspended."
+}
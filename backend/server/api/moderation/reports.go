@@ -0,0 +1,171 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+Users report a user, meetup or linkup; reports wait in a queue until a
+moderator picks one up (assigned), closes it with or without acting on it
+(resolved or dismissed). Reports don't reference what they're about by foreign
+key, so they outlive a deleted function or account.
+
+Every moderator change is also written to moderation_audit_log, which the
+database refuses to update or delete.
+*/
+
+const maxReportDetails = 2000
+
+type Report struct {
+	ReportID   uuid.UUID  `json:"report_id"`
+	ReporterID *uuid.UUID `json:"reporter_id"` // null once the reporter's account is gone
+	TargetType string     `json:"target_type"` // "user", "meetup" or "linkup"
+	TargetID   uuid.UUID  `json:"target_id"`
+	TargetName string     `json:"target_name"` // username or function name, empty if it no longer exists
+	Reason     string     `json:"reason"`      // "harassment", "spam", "safety" or "other"
+	Details    string     `json:"details"`
+	Status     string     `json:"status"` // "open", "assigned", "resolved" or "dismissed"
+	AssignedTo *uuid.UUID `json:"assigned_to"`
+	Resolution string     `json:"resolution"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+}
+
+var reportReasons = map[string]bool{"harassment": true, "spam": true, "safety": true, "other": true}
+
+/*
+====================
+CreateReport
+
+Purpose: Report a user, meetup or linkup to the moderators. The reported user
+isn't told who reported them.
+
+Endpoint: POST /api/reports
+Authorization: Bearer token required
+
+Body (JSON):
+	{
+		"target_type": "user",  // or "meetup", "linkup"
+		"target_id": "uuid",
+		"reason": "harassment", // or "spam", "safety", "other"
+		"details": "Kept messaging me after I said no"  // optional, up to 2000 characters
+	}
+
+Response:
+	- Success: 201 Created
+		{
+			"report_id": "uuid"
+		}
+	- Bad Request: 400 (unknown target type or reason, details too long, or reporting yourself)
+	- Not Found: 404 (no such user or function)
+	- Conflict: 409 (the user already has an open report about this)
+	- Server Error: 500
+*/
+func CreateReport(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		TargetType string    `json:"target_type" binding:"required"`
+		TargetID   uuid.UUID `json:"target_id" binding:"required"`
+		Reason     string    `json:"reason" binding:"required"`
+		Details    string    `json:"details"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	request.Details = strings.TrimSpace(request.Details)
+
+	if !reportReasons[request.Reason] {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Reason must be harassment, spam, safety or other"})
+		return
+	}
+	if len(request.Details) > maxReportDetails {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Details can be at most 2000 characters"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var exists bool
+	switch request.TargetType {
+	case "user":
+		if request.TargetID == userID {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "You can't report yourself"})
+			return
+		}
+		err = db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE user_id = $1);`, request.TargetID).Scan(&exists)
+	case "meetup", "linkup":
+		err = db.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM functions WHERE function_id = $1 AND function_type = $2);
+		`, request.TargetID, request.TargetType).Scan(&exists)
+	default:
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Target type must be user, meetup or linkup"})
+		return
+	}
+
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	if !exists {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "Nothing to report with that id"})
+		return
+	}
+
+	var reportID uuid.UUID
+	err = db.QueryRow(ctx, `
+		INSERT INTO reports (reporter_id, target_type, target_id, reason, details)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING report_id;
+	`, userID, request.TargetType, request.TargetID, request.Reason, request.Details).Scan(&reportID)
+
+	if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "You already reported this, a moderator will look at it"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error creating report: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, gin.H{"report_id": reportID})
+}
+
+// reportColumns and reportJoins select a Report; scan with scanReport.
+const reportColumns = `
+	r.report_id, r.reporter_id, r.target_type::TEXT, r.target_id,
+	COALESCE(target_user.username, target_function.function_name, ''),
+	r.reason::TEXT, r.details, r.status::TEXT, r.assigned_to, r.resolution, r.created_at, r.resolved_at
+`
+
+const reportJoins = `
+	LEFT JOIN users target_user ON r.target_type = 'user' AND target_user.user_id = r.target_id
+	LEFT JOIN functions target_function ON r.target_type <> 'user' AND target_function.function_id = r.target_id
+`
+
+func scanReport(row pgx.Row) (Report, error) {
+	var report Report
+	err := row.Scan(&report.ReportID, &report.ReporterID, &report.TargetType, &report.TargetID, &report.TargetName,
+		&report.Reason, &report.Details, &report.Status, &report.AssignedTo, &report.Resolution, &report.CreatedAt, &report.ResolvedAt)
+	return report, err
+}
@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mailer delivers a plain text email. Handlers get the configured one from
+// the request context under "mailer".
+type Mailer interface {
+	SendMail(ctx context.Context, to string, subject string, body string) error
+}
+
+// ConsoleMailer prints every email to stdout. Meant for local development.
+type ConsoleMailer struct{}
+
+func (ConsoleMailer) SendMail(ctx context.Context, to string, subject string, body string) error {
+	fmt.Printf("📧 To: %s\nSubject: %s\n\n%s\n\n", to, subject, body)
+	return nil
+}
+
+// FileMailer writes every email to its own file in Dir, so a test run can
+// read back the links it was sent.
+type FileMailer struct {
+	Dir string
+
+	mu sync.Mutex
+}
+
+func (m *FileMailer) SendMail(ctx context.Context, to string, subject string, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitizeFileName(to))
+	contents := fmt.Sprintf("To: %s\r\nSubject: %s\r\nDate: %s\r\n\r\n%s\r\n", to, subject, time.Now().Format(time.RFC1123Z), body)
+
+	return os.WriteFile(filepath.Join(m.Dir, name), []byte(contents), 0o644)
+}
+
+func sanitizeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_', r == '@', r == '+':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// MailerFromEnv picks a mailer from MAIL_DRIVER ("console" or "file").
+// The file driver writes to MAIL_DIR, defaulting to ./mail.
+func MailerFromEnv() (Mailer, error) {
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "", "console":
+		return ConsoleMailer{}, nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return &FileMailer{Dir: dir}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+}
@@ -0,0 +1,32 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// SMSSender delivers a text message. Handlers get the configured one from
+// the request context under "sms".
+type SMSSender interface {
+	SendSMS(ctx context.Context, to string, body string) error
+}
+
+// LogSMSSender only logs messages. Meant for local development, where the
+// one-time codes can be read from the server output.
+type LogSMSSender struct{}
+
+func (LogSMSSender) SendSMS(ctx context.Context, to string, body string) error {
+	fmt.Printf("📱 SMS to %s: %s\n", to, body)
+	return nil
+}
+
+// SMSSenderFromEnv picks an SMS sender from SMS_DRIVER. Only "log" exists so far.
+func SMSSenderFromEnv() (SMSSender, error) {
+	switch driver := os.Getenv("SMS_DRIVER"); driver {
+	case "", "log":
+		return LogSMSSender{}, nil
+	default:
+		return nil, fmt.Errorf("unknown SMS_DRIVER %q", driver)
+	}
+}
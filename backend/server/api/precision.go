@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"server/api/geo"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+====================
+GetLocationPrecision
+
+Purpose: How exactly the authenticated user's location is shared with others.
+
+Endpoint: GET /api/users/location/precision
+Authorization: Bearer token required
+
+Response:
+	- Success: 200 OK
+		{
+			"precision": "100m"  // "exact", "100m", "500m" or "campus"
+		}
+	- Server Error: 500
+*/
+func GetLocationPrecision(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var precision string
+	err = db.QueryRow(ctx, `SELECT location_precision::TEXT FROM user_profiles WHERE user_id = $1;`, userID).Scan(&precision)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"precision": precision})
+}
+
+/*
+====================
+SetLocationPrecision
+
+Purpose: Choose how exactly the authenticated user's location is shared. The
+server always keeps the exact point for matching, but other users only get it
+fuzzed to this precision, and distances to it rounded to match:
+
+	- exact: as reported, distances to 10 m
+	- 100m: snapped to the nearest building within 100 m or a 100 m grid, distances to 100 m
+	- 500m: snapped to a 500 m grid, distances to 500 m
+	- campus: the center of the user's campus (or a 2 km grid), distances to 1 km
+
+Endpoint: PUT /api/users/location/precision
+Authorization: Bearer token required
+
+Body (JSON):
+	{
+		"precision": "500m"
+	}
+
+Response:
+	- Success: 200 OK
+	- Bad Request: 400 (unknown precision)
+	- Server Error: 500
+*/
+func SetLocationPrecision(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		Precision geo.Precision `json:"precision" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil || !request.Precision.Valid() {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Precision must be exact, 100m, 500m or campus"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = db.Exec(ctx, `UPDATE user_profiles SET location_precision = $2 WHERE user_id = $1;`, userID, string(request.Precision))
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"precision": request.Precision})
+}
@@ -0,0 +1,783 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"server/api/geo"
+	"server/api/media"
+	"server/api/tags"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+A profile looks different depending on who is asking:
+
+	- self: everything, including the exact location and the privacy settings
+	- friend: an accepted friend
+	- stranger: anyone else
+
+Name, username, photo, bio, interest tags, stats and verification badges are public. The
+rest is up to the owner, who picks an audience (everyone, friends or nobody)
+for each part:
+
+	- age: friends get the birthdate, strangers only the age
+	- location: the last location, coarsened to 500 m; never everyone, and
+	  gone while the owner is hidden
+	- last_active: when the owner was last seen
+	- friends: the friend list and count; mutual friends are counted for
+	  anyone unless this is nobody
+	- school: the owner's university
+*/
+
+const (
+	AudienceEveryone = "everyone"
+	AudienceFriends  = "friends"
+	AudienceNobody   = "nobody"
+)
+
+type PrivacySettings struct {
+	Age        string `json:"age"`
+	Location   string `json:"location"`
+	LastActive string `json:"last_active"`
+	Friends    string `json:"friends"`
+	School     string `json:"school"`
+}
+
+type ProfileSchool struct {
+	UniversityID uuid.UUID `json:"university_id"`
+	Name         string    `json:"name"`
+}
+
+type ProfileBadges struct {
+	VerifiedEmail bool `json:"verified_email"`
+	VerifiedPhone bool `json:"verified_phone"`
+}
+
+// ProfileView is a profile as one viewer may see it. Parts the viewer isn't
+// allowed to see are left out of the JSON.
+type ProfileView struct {
+	UserID            uuid.UUID         `json:"user_id"`
+	View              string            `json:"view"` // "self", "friend" or "stranger"
+	Name              string            `json:"name"`
+	Username          string            `json:"username"`
+	AvatarURL         string            `json:"avatar_url"`
+	Avatars           map[string]string `json:"avatars,omitempty"`
+	Bio               string            `json:"bio"`
+	Tags              []tags.Tag        `json:"tags"`
+	Hobbies           []string          `json:"hobbies"` // the tag names, for older clients
+	Badges            ProfileBadges     `json:"badges"`
+	FunctionsAttended int               `json:"functions_attended"`
+	Rating            *float64          `json:"rating"` // null before the first rating
+	RatingCount       int               `json:"rating_count"`
+	Birthdate         *time.Time        `json:"birthdate,omitempty"`
+	Age               *int              `json:"age,omitempty"`
+	LastActive        *time.Time        `json:"last_active,omitempty"`
+	Location          *geo.Point        `json:"location,omitempty"`
+	School            *ProfileSchool    `json:"school,omitempty"`
+	FriendCount       *int              `json:"friend_count,omitempty"`
+	FriendIDs         []uuid.UUID       `json:"friend_ids,omitempty"`
+	MutualFriends     *int              `json:"mutual_friends,omitempty"`
+	Privacy           *PrivacySettings  `json:"privacy,omitempty"`
+}
+
+// audienceIncludes reports whether a part with the given audience is shown in view.
+func audienceIncludes(audience string, view string) bool {
+	switch view {
+	case "self":
+		return true
+	case "friend":
+		return audience != AudienceNobody
+	}
+	return audience == AudienceEveryone
+}
+
+func validAudience(audience string) bool {
+	switch audience {
+	case AudienceEveryone, AudienceFriends, AudienceNobody:
+		return true
+	}
+	return false
+}
+
+// friendIDs lists a user's accepted friends.
+func friendIDs(ctx context.Context, db *pgxpool.Pool, userID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := db.Query(ctx, `
+		SELECT CASE WHEN f.user_id1 = $1 THEN f.user_id2 ELSE f.user_id1 END
+		FROM friendships f
+		WHERE $1 IN (f.user_id1, f.user_id2) AND f.friendship_status = 'accepted';
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+}
+
+/*
+====================
+GetUserProfile
+
+Purpose: A user's profile as the authenticated user may see it (see the
+comment at the top of this file). Users who blocked the viewer look like they
+don't exist.
+
+Endpoint: GET /api/users
+Authorization: Bearer token required
+
+Query Params:
+	- user_id: whose profile, defaults to the authenticated user (optional)
+
+Response:
+	- Success: 200 OK
+		{
+			"user_id": "uuid",
+			"view": "friend",
+			"name": "Test User",
+			"username": "testuser1",
+			"avatar_url": "https://.../medium.jpg",  // "" without a photo
+			"avatars": { "large": "...", "medium": "...", "small": "..." },  // left out without a photo
+			"bio": "Hi!",
+			"tags": [{ "id": "climbing", "name": "Climbing", "category": "outdoors" }],
+			"hobbies": ["Climbing"],
+			"badges": { "verified_email": true, "verified_phone": false },
+			"functions_attended": 4,
+			"rating": 4.12,  // null before the first rating
+			"rating_count": 9,
+			"birthdate": "2003-05-14T00:00:00Z",  // self and friends
+			"age": 21,
+			"last_active": "2024-11-02T15:00:00Z",
+			"location": { "latitude": 42.2769, "longitude": -83.7382 },  // exact for self, 500 m for friends
+			"school": { "university_id": "uuid", "name": "University of Michigan" },
+			"friend_count": 12,
+			"friend_ids": ["uuid"],
+			"mutual_friends": 3,  // everyone but self
+			"privacy": { ... }  // self only, same body as GetPrivacySettings
+		}
+	- Bad Request: 400 (invalid user_id)
+	- Not Found: 404
+	- Server Error: 500
+*/
+func GetUserProfile(c *gin.Context) {
+	viewerID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	userID := viewerID
+	if userIDString, exists := c.GetQuery("user_id"); exists {
+		if userID, err = uuid.Parse(userIDString); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+			return
+		}
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	// Someone who blocked the viewer looks like they don't exist
+	if viewerID != userID {
+		kind, err := blockKind(ctx, db, userID, viewerID)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		if kind == "block" {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+	}
+
+	query := `
+		SELECT
+			u.name, u.username, profile.avatar_key,
+			COALESCE(profile.bio, ''),
+			COALESCE(profile.verified_email, false), COALESCE(profile.verified_phone_number, false),
+			COALESCE(profile.functions_attended, 0), profile.rating::FLOAT8, profile.rating_count,
+			profile.birthdate, date_part('year', age(profile.birthdate))::INT,
+			profile.last_active,
+			profile.visibility = 'hidden' AND (profile.hidden_until IS NULL OR profile.hidden_until > NOW()),
+			campus.university_id, campus.name,
+			profile.age_audience::TEXT, profile.location_audience::TEXT, profile.last_active_audience::TEXT,
+			profile.friends_audience::TEXT, profile.school_audience::TEXT,
+			EXISTS (
+				SELECT 1 FROM friendships f
+				WHERE f.user_id1 = LEAST($1::UUID, $2::UUID) AND f.user_id2 = GREATEST($1::UUID, $2::UUID)
+				  AND f.friendship_status = 'accepted'
+			),
+			(
+				SELECT COUNT(*) FROM (
+					SELECT CASE WHEN f.user_id1 = $1 THEN f.user_id2 ELSE f.user_id1 END
+					FROM friendships f
+					WHERE $1 IN (f.user_id1, f.user_id2) AND f.friendship_status = 'accepted'
+					INTERSECT
+					SELECT CASE WHEN f.user_id1 = $2 THEN f.user_id2 ELSE f.user_id1 END
+					FROM friendships f
+					WHERE $2 IN (f.user_id1, f.user_id2) AND f.friendship_status = 'accepted'
+				) mutual
+			),
+			` + geo.SharedLocationColumns + `
+		FROM user_profiles profile
+		JOIN users u ON profile.user_id = u.user_id
+		` + geo.SharedLocationJoins + `
+		WHERE profile.user_id = $1 AND u.deleted_at IS NULL;
+	`
+
+	profile := ProfileView{UserID: userID}
+	var (
+		avatarKey   *string
+		birthdate   *time.Time
+		age         *int
+		lastActive  time.Time
+		hidden      bool
+		schoolID    *uuid.UUID
+		schoolName  *string
+		privacy     PrivacySettings
+		isFriend    bool
+		mutualCount int
+		location    geo.SharedLocation
+	)
+
+	targets := []any{
+		&profile.Name, &profile.Username, &avatarKey,
+		&profile.Bio,
+		&profile.Badges.VerifiedEmail, &profile.Badges.VerifiedPhone,
+		&profile.FunctionsAttended, &profile.Rating, &profile.RatingCount,
+		&birthdate, &age,
+		&lastActive,
+		&hidden,
+		&schoolID, &schoolName,
+		&privacy.Age, &privacy.Location, &privacy.LastActive,
+		&privacy.Friends, &privacy.School,
+		&isFriend,
+		&mutualCount,
+	}
+
+	err = db.QueryRow(ctx, query, userID, viewerID).Scan(append(targets, location.ScanTargets()...)...)
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error loading profile: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	profile.AvatarURL = media.AvatarURL(c, avatarKey)
+	profile.Avatars = media.AvatarURLs(c, avatarKey)
+
+	if profile.Tags, err = tags.UserTags(ctx, db, userID); err != nil {
+		fmt.Printf("Error loading profile tags: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	profile.Hobbies = tags.Names(profile.Tags)
+
+	switch {
+	case userID == viewerID:
+		profile.View = "self"
+		profile.Privacy = &privacy
+	case isFriend:
+		profile.View = "friend"
+	default:
+		profile.View = "stranger"
+	}
+
+	if audienceIncludes(privacy.Age, profile.View) {
+		profile.Age = age
+		if profile.View != "stranger" {
+			profile.Birthdate = birthdate
+		}
+	}
+
+	if audienceIncludes(privacy.LastActive, profile.View) {
+		profile.LastActive = &lastActive
+	}
+
+	if profile.View == "self" {
+		profile.Location = location.Raw()
+	} else if !hidden && audienceIncludes(privacy.Location, profile.View) {
+		profile.Location = location.Coarse()
+	}
+
+	if schoolID != nil && audienceIncludes(privacy.School, profile.View) {
+		profile.School = &ProfileSchool{UniversityID: *schoolID, Name: *schoolName}
+	}
+
+	if profile.View != "self" && privacy.Friends != AudienceNobody {
+		profile.MutualFriends = &mutualCount
+	}
+
+	if audienceIncludes(privacy.Friends, profile.View) {
+		friends, err := friendIDs(ctx, db, userID)
+		if err != nil {
+			fmt.Printf("Error loading friends: %v\n", err)
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		friendCount := len(friends)
+		profile.FriendCount = &friendCount
+		profile.FriendIDs = friends
+	}
+
+	c.IndentedJSON(http.StatusOK, profile)
+}
+
+/*
+====================
+GetPrivacySettings
+
+Purpose: Who can see each optional part of the authenticated user's profile.
+
+Endpoint: GET /api/users/privacy
+Authorization: Bearer token required
+
+Response:
+	- Success: 200 OK
+		{
+			"age": "everyone",       // "everyone", "friends" or "nobody"
+			"location": "friends",   // "friends" or "nobody"
+			"last_active": "friends",
+			"friends": "friends",
+			"school": "everyone"
+		}
+	- Server Error: 500
+*/
+func GetPrivacySettings(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var privacy PrivacySettings
+	err = db.QueryRow(ctx, `
+		SELECT age_audience::TEXT, location_audience::TEXT, last_active_audience::TEXT,
+		       friends_audience::TEXT, school_audience::TEXT
+		FROM user_profiles WHERE user_id = $1;
+	`, userID).Scan(&privacy.Age, &privacy.Location, &privacy.LastActive, &privacy.Friends, &privacy.School)
+
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, privacy)
+}
+
+/*
+====================
+SetPrivacySettings
+
+Purpose: Change who can see parts of the authenticated user's profile. Parts
+left out of the body keep their current audience.
+
+Endpoint: PUT /api/users/privacy
+Authorization: Bearer token required
+
+Body (JSON):
+	{
+		"age": "friends",       // optional, "everyone", "friends" or "nobody"
+		"location": "nobody",   // optional, "friends" or "nobody"
+		"last_active": "nobody", // optional
+		"friends": "everyone",  // optional
+		"school": "friends"     // optional
+	}
+
+Response:
+	- Success: 200 OK (same body as GetPrivacySettings)
+	- Bad Request: 400 (unknown audience, or location shared with everyone)
+	- Server Error: 500
+*/
+func SetPrivacySettings(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		Age        *string `json:"age"`
+		Location   *string `json:"location"`
+		LastActive *string `json:"last_active"`
+		Friends    *string `json:"friends"`
+		School     *string `json:"school"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	for _, audience := range []*string{request.Age, request.Location, request.LastActive, request.Friends, request.School} {
+		if audience != nil && !validAudience(*audience) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Audience must be everyone, friends or nobody"})
+			return
+		}
+	}
+
+	if request.Location != nil && *request.Location == AudienceEveryone {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Location can only be shared with friends or nobody"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var privacy PrivacySettings
+	err = db.QueryRow(ctx, `
+		UPDATE user_profiles SET
+			age_audience = COALESCE($2::profileaudience, age_audience),
+			location_audience = COALESCE($3::profileaudience, location_audience),
+			last_active_audience = COALESCE($4::profileaudience, last_active_audience),
+			friends_audience = COALESCE($5::profileaudience, friends_audience),
+			school_audience = COALESCE($6::profileaudience, school_audience)
+		WHERE user_id = $1
+		RETURNING age_audience::TEXT, location_audience::TEXT, last_active_audience::TEXT,
+		          friends_audience::TEXT, school_audience::TEXT;
+	`, userID, request.Age, request.Location, request.LastActive, request.Friends, request.School).Scan(
+		&privacy.Age, &privacy.Location, &privacy.LastActive, &privacy.Friends, &privacy.School)
+
+	if err != nil {
+		fmt.Printf("Error updating privacy settings: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, privacy)
+}
+
+const (
+	maxNameLength = 255
+	maxBioLength  = 500
+	maxTagLength  = 63
+	minAge        = 13
+	maxAge        = 120
+
+	usernameChangeInterval = 30 * 24 * time.Hour
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.]{3,50}$`)
+
+// profilePatch is a parsed JSON Merge Patch of the editable profile fields.
+// The set flags say whether the patch mentions a clearable field at all; a
+// mentioned field with a nil value was null, which clears it.
+type profilePatch struct {
+	Name     *string
+	Username *string
+
+	SetBio       bool
+	Bio          *string
+	SetBirthdate bool
+	Birthdate    *time.Time
+	SetTags      bool
+	Tags         []string
+	TagsByName   bool // from "hobbies": tag names or aliases rather than ids
+}
+
+// parseProfilePatch validates a merge patch. Its errors are meant for the client.
+func parseProfilePatch(body []byte) (profilePatch, error) {
+	var patch profilePatch
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return patch, errors.New("body must be a JSON object")
+	}
+
+	for field, value := range fields {
+		isNull := string(value) == "null"
+
+		switch field {
+		case "name":
+			var name string
+			if isNull || json.Unmarshal(value, &name) != nil {
+				return patch, errors.New("name must be a string")
+			}
+			name = strings.TrimSpace(name)
+			if name == "" || utf8.RuneCountInString(name) > maxNameLength {
+				return patch, fmt.Errorf("name must be 1 to %d characters", maxNameLength)
+			}
+			patch.Name = &name
+
+		case "username":
+			var username string
+			if isNull || json.Unmarshal(value, &username) != nil {
+				return patch, errors.New("username must be a string")
+			}
+			if !usernamePattern.MatchString(username) {
+				return patch, errors.New("username must be 3 to 50 letters, digits, dots or underscores")
+			}
+			patch.Username = &username
+
+		case "bio":
+			patch.SetBio = true
+			if isNull {
+				continue
+			}
+			var bio string
+			if json.Unmarshal(value, &bio) != nil {
+				return patch, errors.New("bio must be a string or null")
+			}
+			if utf8.RuneCountInString(bio) > maxBioLength {
+				return patch, fmt.Errorf("bio must be at most %d characters", maxBioLength)
+			}
+			patch.Bio = &bio
+
+		case "birthdate":
+			patch.SetBirthdate = true
+			if isNull {
+				continue
+			}
+			var text string
+			if json.Unmarshal(value, &text) != nil {
+				return patch, errors.New("birthdate must be a date like 2003-05-14 or null")
+			}
+			birthdate, err := parseBirthdate(text)
+			if err != nil {
+				return patch, err
+			}
+			patch.Birthdate = &birthdate
+
+		case "tags", "hobbies":
+			if patch.SetTags {
+				return patch, errors.New("send tags or hobbies, not both")
+			}
+			patch.SetTags = true
+			patch.TagsByName = field == "hobbies"
+			patch.Tags = []string{}
+			if isNull {
+				continue
+			}
+			var terms []string
+			if json.Unmarshal(value, &terms) != nil {
+				return patch, fmt.Errorf("%s must be a list of strings or null", field)
+			}
+
+			for _, term := range terms {
+				if strings.TrimSpace(term) == "" || utf8.RuneCountInString(term) > maxTagLength {
+					return patch, fmt.Errorf("each of %s must be 1 to %d characters", field, maxTagLength)
+				}
+			}
+			patch.Tags = tags.Clean(terms)
+			if len(patch.Tags) > tags.MaxPerUser {
+				return patch, fmt.Errorf("at most %d %s", tags.MaxPerUser, field)
+			}
+
+		default:
+			return patch, fmt.Errorf("%s can't be changed here", field)
+		}
+	}
+
+	return patch, nil
+}
+
+// parseBirthdate takes a plain date, or a timestamp whose date part is used,
+// and checks it belongs to someone between minAge and maxAge.
+func parseBirthdate(text string) (time.Time, error) {
+	birthdate, err := time.Parse(time.DateOnly, text)
+	if err != nil {
+		timestamp, err := time.Parse(time.RFC3339, text)
+		if err != nil {
+			return time.Time{}, errors.New("birthdate must be a date like 2003-05-14 or null")
+		}
+		birthdate = time.Date(timestamp.Year(), timestamp.Month(), timestamp.Day(), 0, 0, 0, 0, time.UTC)
+	}
+
+	now := time.Now().UTC()
+	if birthdate.After(now.AddDate(-minAge, 0, 0)) {
+		return time.Time{}, fmt.Errorf("you must be at least %d", minAge)
+	}
+	if birthdate.Before(now.AddDate(-maxAge, 0, 0)) {
+		return time.Time{}, errors.New("birthdate isn't plausible")
+	}
+	return birthdate, nil
+}
+
+/*
+====================
+UpdateProfile
+
+Purpose: Change parts of the authenticated user's profile with a JSON Merge
+Patch (RFC 7386): fields left out stay as they are, and null clears bio,
+birthdate or tags. Changing the username is limited to once every 30 days;
+usernames are unique regardless of case. Tags are ids from the tag taxonomy
+(see GET /api/tags); older clients can send hobbies instead, tag names or
+aliases that are turned into the matching tags.
+
+Endpoint: PATCH /api/users (PUT /api/users behaves the same, for older clients)
+Authorization: Bearer token required
+
+Body (JSON, application/merge-patch+json or application/json):
+	{
+		"name": "Test User",          // optional, 1-255 characters
+		"username": "testuser_1",     // optional, 3-50 letters, digits, dots or underscores
+		"bio": "Climbing and coffee", // optional, at most 500 characters, null to clear
+		"birthdate": "2003-05-14",    // optional, at least 13 years ago, null to clear
+		"tags": ["climbing"],         // optional, at most 20 tag ids, null to clear
+		"hobbies": ["Bouldering"]     // instead of tags: tag names or aliases
+	}
+
+Response:
+	- Success: 200 OK
+		{
+			"name": "Test User",
+			"username": "testuser_1",
+			"bio": "Climbing and coffee",
+			"birthdate": "2003-05-14T00:00:00Z",
+			"tags": [{ "id": "climbing", "name": "Climbing", "category": "outdoors" }],
+			"hobbies": ["Climbing"]
+		}
+	- Bad Request: 400 (invalid field or unknown tag, with the reason in "error")
+	- Conflict: 409 (username taken)
+	- Too Many Requests: 429 (username changed in the last 30 days)
+	- Server Error: 500
+
+Notes:
+	- Access tokens carry the old username until they are refreshed
+*/
+func UpdateProfile(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 64<<10))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	patch, err := parseProfilePatch(body)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if patch.Username != nil {
+		var username string
+		var changedAt *time.Time
+		err := tx.QueryRow(ctx, `
+			SELECT username, username_changed_at FROM users WHERE user_id = $1 FOR UPDATE;
+		`, userID).Scan(&username, &changedAt)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+
+		if *patch.Username == username {
+			patch.Username = nil
+		} else if changedAt != nil && time.Since(*changedAt) < usernameChangeInterval {
+			wait := time.Until(changedAt.Add(usernameChangeInterval))
+			c.Header("Retry-After", fmt.Sprint(int(wait.Seconds())))
+			c.IndentedJSON(http.StatusTooManyRequests, gin.H{"error": "Usernames can only be changed once every 30 days"})
+			return
+		}
+	}
+
+	var profile struct {
+		Name      string     `json:"name"`
+		Username  string     `json:"username"`
+		Bio       *string    `json:"bio"`
+		Birthdate *time.Time `json:"birthdate"`
+		Tags      []tags.Tag `json:"tags"`
+		Hobbies   []string   `json:"hobbies"`
+	}
+
+	err = tx.QueryRow(ctx, `
+		UPDATE users SET
+			name = COALESCE($2, name),
+			username = COALESCE($3, username),
+			username_changed_at = CASE WHEN $3::TEXT IS NULL THEN username_changed_at ELSE NOW() END
+		WHERE user_id = $1
+		RETURNING name, username;
+	`, userID, patch.Name, patch.Username).Scan(&profile.Name, &profile.Username)
+
+	if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "Username taken"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error updating user: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	err = tx.QueryRow(ctx, `
+		UPDATE user_profiles SET
+			bio = CASE WHEN $2 THEN $3 ELSE bio END,
+			birthdate = CASE WHEN $4 THEN $5::DATE ELSE birthdate END
+		WHERE user_id = $1
+		RETURNING bio, birthdate;
+	`, userID, patch.SetBio, patch.Bio, patch.SetBirthdate, patch.Birthdate).Scan(&profile.Bio, &profile.Birthdate)
+
+	if err != nil {
+		fmt.Printf("Error updating profile: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if patch.SetTags {
+		tagIDs, unknown, err := tags.Resolve(ctx, tx, patch.Tags, patch.TagsByName)
+		if err != nil {
+			fmt.Printf("Error resolving tags: %v\n", err)
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		if len(unknown) > 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Unknown tags: " + strings.Join(unknown, ", ")})
+			return
+		}
+
+		if err := tags.SetUserTags(ctx, tx, userID, tagIDs); err != nil {
+			fmt.Printf("Error updating tags: %v\n", err)
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+	}
+
+	if profile.Tags, err = tags.UserTags(ctx, tx, userID); err != nil {
+		fmt.Printf("Error loading profile tags: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	profile.Hobbies = tags.Names(profile.Tags)
+
+	if err := tx.Commit(ctx); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, profile)
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/api/media"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+User search matches the query against usernames, names, interest tags and
+schools with trigram similarity (pg_trgm), so typos and partial words still
+find people. Each result's relevance is its best match:
+
+	- username: 1 exact, 0.9 prefix, otherwise its similarity
+	- name: 0.8 prefix, otherwise 0.8 x word similarity
+	- tag: 0.6 x the best word similarity to one of the user's tags, by name or alias
+	- school: 0.5 x word similarity, only when the school is shown to the viewer
+
+plus 0.3 for friends and 0.15 for people at the viewer's school. Results come
+best first in pages; the cursor marks where the last page stopped.
+*/
+
+type PublicUser struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	Username  string    `json:"username"`
+	AvatarURL string    `json:"avatar_url"`
+	Bio       string    `json:"bio"`
+	IsFriend  bool      `json:"is_friend"`
+
+	score float64
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// searchCursor is where a page of results ended: the last score and user id.
+func encodeSearchCursor(score float64, userID uuid.UUID) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatFloat(score, 'f', 4, 64) + "|" + userID.String()))
+}
+
+func decodeSearchCursor(cursor string) (float64, uuid.UUID, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, uuid.Nil, err
+	}
+
+	score, id, found := strings.Cut(string(decoded), "|")
+	if !found {
+		return 0, uuid.Nil, fmt.Errorf("malformed cursor")
+	}
+
+	parsedScore, err := strconv.ParseFloat(score, 64)
+	if err != nil {
+		return 0, uuid.Nil, err
+	}
+	parsedID, err := uuid.Parse(id)
+	return parsedScore, parsedID, err
+}
+
+/*
+====================
+SearchUsers
+
+Purpose: Find users by username, name, tag or school, best match first (see
+the comment at the top of this file). Blocked and muted users, users who
+blocked the searcher, and deleted or suspended accounts never show up.
+
+Endpoint: GET /api/users/search
+Authorization: Bearer token required
+
+Query Params:
+	- q: what to look for (required; "username" is still accepted)
+	- limit: results per page, default 20, max 50 (optional)
+	- cursor: next_cursor from the previous page (optional)
+
+Response:
+	- Success: 200 OK
+		{
+			"users": [
+				{
+					"id": "uuid",
+					"name": "Minimal User",
+					"username": "minimaluser",
+					"avatar_url": "https://.../medium.jpg",  // "" without a photo
+					"bio": "Hi!",
+					"is_friend": false
+				}
+			],
+			"next_cursor": "MC44MDAwfDFm..."  // null on the last page
+		}
+	- Bad Request: 400 (missing query or invalid cursor)
+	- Server Error: 500
+*/
+func SearchUsers(c *gin.Context) {
+	viewerID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	queryText := c.Query("q")
+	if queryText == "" {
+		queryText = c.Query("username")
+	}
+	queryText = strings.ToLower(strings.TrimSpace(queryText))
+	if queryText == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "missing search query"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > 50 {
+		limit = 20
+	}
+
+	var afterScore *float64
+	var afterID *uuid.UUID
+	if cursor := c.Query("cursor"); cursor != "" {
+		score, id, err := decodeSearchCursor(cursor)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		afterScore, afterID = &score, &id
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := `
+		WITH viewer AS (
+			SELECT school_id FROM user_profiles WHERE user_id = $1
+		),
+		tag_matches AS (
+			SELECT ut.user_id, MAX(word_similarity($2, term.text)) AS similarity
+			FROM user_tags ut
+			JOIN LATERAL (
+				SELECT LOWER(t.name) AS text FROM tags t WHERE t.tag_id = ut.tag_id
+				UNION ALL
+				SELECT a.alias FROM tag_aliases a WHERE a.tag_id = ut.tag_id
+			) term ON $2 <% term.text
+			GROUP BY ut.user_id
+		),
+		candidates AS (
+			SELECT u.user_id FROM users u
+			WHERE LOWER(u.username) LIKE $3 || '%' OR LOWER(u.username) % $2
+			UNION
+			SELECT u.user_id FROM users u
+			WHERE LOWER(u.name) LIKE $3 || '%' OR $2 <% LOWER(u.name)
+			UNION
+			SELECT user_id FROM tag_matches
+			UNION
+			SELECT profile.user_id FROM user_profiles profile
+			JOIN universities school ON school.university_id = profile.school_id
+			WHERE $2 <% LOWER(school.name)
+		),
+		matches AS (
+			SELECT u.user_id, u.name, u.username, profile.avatar_key, COALESCE(profile.bio, '') AS bio,
+			       profile.school_id, profile.school_audience, school.name AS school_name,
+			       COALESCE(tag_matches.similarity, 0) AS tag_similarity,
+			       EXISTS (
+			           SELECT 1 FROM friendships f
+			           WHERE f.user_id1 = LEAST($1, u.user_id) AND f.user_id2 = GREATEST($1, u.user_id)
+			             AND f.friendship_status = 'accepted'
+			       ) AS is_friend
+			FROM candidates candidate
+			JOIN users u ON u.user_id = candidate.user_id
+			JOIN user_profiles profile ON profile.user_id = u.user_id
+			LEFT JOIN universities school ON school.university_id = profile.school_id
+			LEFT JOIN tag_matches ON tag_matches.user_id = u.user_id
+			WHERE u.user_id <> $1
+			  AND u.deleted_at IS NULL
+			  AND u.suspended_at IS NULL
+			  AND NOT EXISTS (
+			      SELECT 1 FROM user_blocks b
+			      WHERE (b.blocker_id = $1 AND b.blocked_id = u.user_id)
+			         OR (b.blocker_id = u.user_id AND b.blocked_id = $1 AND b.kind = 'block')
+			  )
+		),
+		visible AS (
+			SELECT m.*,
+			       m.school_audience = 'everyone' OR (m.school_audience = 'friends' AND m.is_friend) AS school_visible
+			FROM matches m
+		),
+		relevance AS (
+			SELECT v.*,
+			       GREATEST(
+			           CASE WHEN LOWER(v.username) = $2 THEN 1
+			                WHEN LOWER(v.username) LIKE $3 || '%' THEN 0.9
+			                ELSE similarity(LOWER(v.username), $2) END,
+			           CASE WHEN LOWER(v.name) LIKE $3 || '%' THEN 0.8
+			                ELSE 0.8 * word_similarity($2, LOWER(v.name)) END,
+			           0.6 * v.tag_similarity,
+			           CASE WHEN v.school_visible THEN 0.5 * COALESCE(word_similarity($2, LOWER(v.school_name)), 0) ELSE 0 END
+			       )::FLOAT8 AS text_score
+			FROM visible v
+		),
+		ranked AS (
+			SELECT r.*,
+			       ROUND((
+			           r.text_score
+			           + CASE WHEN r.is_friend THEN 0.3 ELSE 0 END
+			           + CASE WHEN r.school_visible AND r.school_id = viewer.school_id THEN 0.15 ELSE 0 END
+			       )::NUMERIC, 4)::FLOAT8 AS score
+			FROM relevance r
+			CROSS JOIN viewer
+			WHERE r.text_score >= 0.3
+		)
+		SELECT user_id, name, username, avatar_key, bio, is_friend, score
+		FROM ranked
+		WHERE $4::FLOAT8 IS NULL OR score < $4 OR (score = $4 AND user_id > $5)
+		ORDER BY score DESC, user_id
+		LIMIT $6;
+	`
+
+	// One extra row says whether there is another page
+	rows, err := db.Query(ctx, query, viewerID, queryText, likeEscaper.Replace(queryText), afterScore, afterID, limit+1)
+	if err != nil {
+		fmt.Printf("Error searching users: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+	defer rows.Close()
+
+	users := []PublicUser{}
+	for rows.Next() {
+		var u PublicUser
+		var avatarKey *string
+		if err := rows.Scan(&u.ID, &u.Name, &u.Username, &avatarKey, &u.Bio, &u.IsFriend, &u.score); err != nil {
+			fmt.Printf("Error scanning search result: %v\n", err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+			return
+		}
+		u.AvatarURL = media.AvatarURL(c, avatarKey)
+		users = append(users, u)
+	}
+
+	var nextCursor *string
+	if len(users) > limit {
+		users = users[:limit]
+		last := users[limit-1]
+		cursor := encodeSearchCursor(last.score, last.ID)
+		nextCursor = &cursor
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"users": users, "next_cursor": nextCursor})
+}
@@ -0,0 +1,241 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"server/api/media"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+Friend suggestions rank everyone the user isn't connected to yet by how much
+they have in common. Each signal adds to the score, capped so one signal
+can't drown out the rest:
+
+	- mutual friends: 3 each, up to 10
+	- shared interest tags: 2 each, up to 5
+	- same school: 4
+	- functions both went to: 3 each, up to 5
+	- recently nearby: 2, for users seen within 1 km of the user's last
+	  location in the last week
+
+Signals follow the other user's privacy settings: mutual friends only count
+unless their friend list is shown to nobody, the school only when it is
+shown to everyone, and proximity only while they are visible on the map.
+Proximity moves the ranking but is never given as a reason.
+*/
+
+const (
+	suggestionMutualFriendWeight = 3
+	suggestionMutualFriendCap    = 10
+	suggestionTagWeight          = 2
+	suggestionTagCap             = 5
+	suggestionSchoolWeight       = 4
+	suggestionFunctionWeight     = 3
+	suggestionFunctionCap        = 5
+	suggestionNearbyWeight       = 2
+
+	suggestionNearbyRadius = 1000 // meters
+	suggestionNearbyWindow = 7 * 24 * time.Hour
+)
+
+type FriendSuggestion struct {
+	UserID          uuid.UUID `json:"user_id"`
+	Name            string    `json:"name"`
+	Username        string    `json:"username"`
+	AvatarURL       string    `json:"avatar_url"`
+	Score           int       `json:"score"`
+	MutualFriends   int       `json:"mutual_friends"`
+	SharedTags      []string  `json:"shared_tags"` // tag names
+	SameSchool      bool      `json:"same_school"`
+	SharedFunctions int       `json:"shared_functions"`
+}
+
+/*
+====================
+GetFriendSuggestions
+
+Purpose: People the authenticated user might know, best match first (see the
+comment at the top of this file for the scoring). Friends, pending requests
+either way, blocked and muted users, and users who blocked the user are left
+out.
+
+Endpoint: GET /api/users/friend/suggestions
+Authorization: Bearer token required
+
+Query Params:
+	- limit: max suggestions, default 20, max 50 (optional)
+
+Response:
+	- Success: 200 OK
+		{
+			"suggestions": [
+				{
+					"user_id": "uuid",
+					"name": "Minimal User",
+					"username": "minimaluser",
+					"avatar_url": "https://.../medium.jpg",  // "" without a photo
+					"score": 13,
+					"mutual_friends": 2,
+					"shared_tags": ["Climbing"],
+					"same_school": true,
+					"shared_functions": 1
+				}
+			]
+		}
+	- Server Error: 500
+
+Notes:
+	- With REQUIRE_VERIFIED_EMAIL=true, the user and every suggestion must have a verified email
+*/
+func GetFriendSuggestions(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > 50 {
+		limit = 20
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := `
+		WITH me AS (
+			SELECT profile.school_id, profile.last_active_location,
+			       ARRAY(SELECT ut.tag_id FROM user_tags ut WHERE ut.user_id = $1) AS tags
+			FROM user_profiles profile
+			WHERE profile.user_id = $1
+		),
+		my_friends AS (
+			SELECT CASE WHEN f.user_id1 = $1 THEN f.user_id2 ELSE f.user_id1 END AS friend_id
+			FROM friendships f
+			WHERE $1 IN (f.user_id1, f.user_id2) AND f.friendship_status = 'accepted'
+		),
+		mutual AS (
+			SELECT CASE WHEN f.user_id1 = mine.friend_id THEN f.user_id2 ELSE f.user_id1 END AS user_id,
+			       COUNT(*) AS friends
+			FROM my_friends mine
+			JOIN friendships f ON mine.friend_id IN (f.user_id1, f.user_id2) AND f.friendship_status = 'accepted'
+			GROUP BY 1
+		),
+		coattended AS (
+			SELECT theirs.user_id, COUNT(DISTINCT theirs.function_id) AS functions
+			FROM function_attendees mine
+			JOIN function_attendees theirs ON theirs.function_id = mine.function_id
+			WHERE mine.user_id = $1
+			  AND mine.attendance_status IN ('going', 'already there')
+			  AND theirs.attendance_status IN ('going', 'already there')
+			GROUP BY theirs.user_id
+		),
+		candidates AS (
+			SELECT user_id FROM mutual
+			UNION
+			SELECT user_id FROM coattended
+			UNION
+			SELECT profile.user_id FROM user_profiles profile, me
+			WHERE profile.school_id = me.school_id AND profile.school_audience = 'everyone'
+			UNION
+			SELECT ut.user_id FROM user_tags ut, me
+			WHERE ut.tag_id = ANY(me.tags)
+			UNION
+			SELECT profile.user_id FROM user_profiles profile, me
+			WHERE profile.visibility = 'visible'
+			  AND profile.last_active > NOW() - make_interval(secs => $3)
+			  AND ST_DWithin(profile.last_active_location, me.last_active_location, $4)
+		),
+		signals AS (
+			SELECT candidate.user_id,
+			       CASE WHEN profile.friends_audience <> 'nobody' THEN COALESCE(mutual.friends, 0) ELSE 0 END AS mutual_friends,
+			       ARRAY(
+			           SELECT t.name FROM user_tags ut
+			           JOIN tags t ON t.tag_id = ut.tag_id
+			           WHERE ut.user_id = candidate.user_id AND ut.tag_id = ANY(me.tags)
+			           ORDER BY t.name
+			       ) AS shared_tags,
+			       COALESCE(profile.school_id = me.school_id AND profile.school_audience = 'everyone', false) AS same_school,
+			       COALESCE(coattended.functions, 0) AS shared_functions,
+			       COALESCE(
+			           profile.visibility = 'visible'
+			           AND profile.last_active > NOW() - make_interval(secs => $3)
+			           AND ST_DWithin(profile.last_active_location, me.last_active_location, $4),
+			           false
+			       ) AS nearby
+			FROM candidates candidate
+			JOIN user_profiles profile ON profile.user_id = candidate.user_id
+			CROSS JOIN me
+			LEFT JOIN mutual ON mutual.user_id = candidate.user_id
+			LEFT JOIN coattended ON coattended.user_id = candidate.user_id
+		)
+		SELECT u.user_id, u.name, u.username, profile.avatar_key,
+		       s.mutual_friends, s.shared_tags, s.same_school, s.shared_functions,
+		       LEAST(s.mutual_friends, $6) * $5
+		       + LEAST(cardinality(s.shared_tags), $8) * $7
+		       + CASE WHEN s.same_school THEN $9 ELSE 0 END
+		       + LEAST(s.shared_functions, $11) * $10
+		       + CASE WHEN s.nearby THEN $12 ELSE 0 END AS score
+		FROM signals s
+		JOIN users u ON u.user_id = s.user_id
+		JOIN user_profiles profile ON profile.user_id = s.user_id
+		WHERE s.user_id <> $1
+		  AND u.deleted_at IS NULL
+		  AND u.suspended_at IS NULL
+		  AND (NOT $13 OR profile.verified_email)
+		  AND NOT EXISTS (
+		      SELECT 1 FROM friendships f
+		      WHERE f.user_id1 = LEAST($1, s.user_id) AND f.user_id2 = GREATEST($1, s.user_id)
+		  )
+		  AND NOT EXISTS (
+		      SELECT 1 FROM user_blocks b
+		      WHERE (b.blocker_id = $1 AND b.blocked_id = s.user_id)
+		         OR (b.blocker_id = s.user_id AND b.blocked_id = $1 AND b.kind = 'block')
+		  )
+		ORDER BY score DESC, s.mutual_friends DESC, u.user_id
+		LIMIT $2;
+	`
+
+	rows, err := db.Query(ctx, query, userID, limit,
+		suggestionNearbyWindow.Seconds(), suggestionNearbyRadius,
+		suggestionMutualFriendWeight, suggestionMutualFriendCap,
+		suggestionTagWeight, suggestionTagCap,
+		suggestionSchoolWeight,
+		suggestionFunctionWeight, suggestionFunctionCap,
+		suggestionNearbyWeight,
+		c.GetBool("require_verified_email"),
+	)
+	if err != nil {
+		fmt.Printf("Error loading friend suggestions: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer rows.Close()
+
+	suggestions := []FriendSuggestion{}
+	for rows.Next() {
+		var suggestion FriendSuggestion
+		var avatarKey *string
+		err := rows.Scan(&suggestion.UserID, &suggestion.Name, &suggestion.Username, &avatarKey,
+			&suggestion.MutualFriends, &suggestion.SharedTags, &suggestion.SameSchool, &suggestion.SharedFunctions,
+			&suggestion.Score)
+		if err != nil {
+			fmt.Printf("Error scanning friend suggestion: %v\n", err)
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		suggestion.AvatarURL = media.AvatarURL(c, avatarKey)
+		suggestions = append(suggestions, suggestion)
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"suggestions": suggestions})
+}
@@ -0,0 +1,279 @@
+package tags
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+type Suggestion struct {
+	Tag
+	MatchedAlias *string `json:"matched_alias"` // set when the query matched an alias rather than the name
+	Users        int     `json:"users"`
+}
+
+type TrendingTag struct {
+	Tag
+	Uses     int `json:"uses"`     // new users and functions tagged in the window
+	Previous int `json:"previous"` // the same, in the window before
+}
+
+/*
+====================
+ListTags
+
+Purpose: The whole tag taxonomy, or one category of it, for pickers.
+
+Endpoint: GET /api/tags
+Authorization: Bearer token required
+
+Query Params:
+	- category: only tags in this category, e.g. "sports" (optional)
+
+Response:
+	- Success: 200 OK
+		{
+			"tags": [
+				{ "id": "basketball", "name": "Basketball", "category": "sports" }
+			]
+		}
+	- Server Error: 500
+
+Notes:
+	- Sorted by category, then name
+*/
+func ListTags(c *gin.Context) {
+	var category *string
+	if value := c.Query("category"); value != "" {
+		category = &value
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+		SELECT t.tag_id, t.name, t.category::TEXT
+		FROM tags t
+		WHERE $1::TEXT IS NULL OR t.category::TEXT = $1
+		ORDER BY t.category, t.name;
+	`, category)
+	if err != nil {
+		fmt.Printf("Error listing tags: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	tags, err := pgx.CollectRows(rows, pgx.RowToStructByPos[Tag])
+	if err != nil {
+		fmt.Printf("Error scanning tags: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+/*
+====================
+AutocompleteTags
+
+Purpose: Tags matching what the user has typed so far, best match first. The
+query is matched against tag names and aliases, allowing typos: an exact match
+beats a prefix, a prefix beats the start of a later word, and those beat
+similar spellings. Ties go to the tag more users picked.
+
+Endpoint: GET /api/tags/autocomplete
+Authorization: Bearer token required
+
+Query Params:
+	- q: what the user typed (required)
+	- limit: max suggestions, default 10, max 25 (optional)
+
+Response:
+	- Success: 200 OK
+		{
+			"tags": [
+				{
+					"id": "basketball",
+					"name": "Basketball",
+					"category": "sports",
+					"matched_alias": "bball",  // null when the name matched
+					"users": 214
+				}
+			]
+		}
+	- Bad Request: 400 (missing q)
+	- Server Error: 500
+*/
+func AutocompleteTags(c *gin.Context) {
+	queryText := strings.ToLower(strings.TrimSpace(c.Query("q")))
+	if queryText == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "missing q"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 || limit > 25 {
+		limit = 10
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := `
+		WITH terms AS (
+			SELECT t.tag_id, LOWER(t.name) AS term, NULL::TEXT AS alias FROM tags t
+			UNION ALL
+			SELECT a.tag_id, a.alias, a.alias FROM tag_aliases a
+		),
+		scored AS (
+			SELECT DISTINCT ON (terms.tag_id) terms.tag_id, terms.alias,
+			       CASE WHEN terms.term = $1 THEN 1
+			            WHEN terms.term LIKE $2 || '%' THEN 0.9
+			            WHEN terms.term LIKE '% ' || $2 || '%' THEN 0.7
+			            ELSE similarity(terms.term, $1) END AS score
+			FROM terms
+			WHERE terms.term LIKE $2 || '%' OR terms.term LIKE '% ' || $2 || '%' OR terms.term % $1
+			ORDER BY terms.tag_id, score DESC, terms.alias NULLS FIRST
+		)
+		SELECT t.tag_id, t.name, t.category::TEXT, scored.alias,
+		       (SELECT COUNT(*) FROM user_tags ut WHERE ut.tag_id = t.tag_id) AS users
+		FROM scored
+		JOIN tags t ON t.tag_id = scored.tag_id
+		ORDER BY scored.score DESC, users DESC, t.name
+		LIMIT $3;
+	`
+
+	rows, err := db.Query(ctx, query, queryText, likeEscaper.Replace(queryText), limit)
+	if err != nil {
+		fmt.Printf("Error autocompleting tags: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer rows.Close()
+
+	suggestions := []Suggestion{}
+	for rows.Next() {
+		var suggestion Suggestion
+		err := rows.Scan(&suggestion.ID, &suggestion.Name, &suggestion.Category, &suggestion.MatchedAlias, &suggestion.Users)
+		if err != nil {
+			fmt.Printf("Error scanning tag suggestion: %v\n", err)
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"tags": suggestions})
+}
+
+/*
+====================
+TrendingTags
+
+Purpose: The tags people picked most lately: users adding them to their
+profile and meetups or linkups tagged with them. Ties go to the tag growing
+fastest compared to the window before.
+
+Endpoint: GET /api/tags/trending
+Authorization: Bearer token required
+
+Query Params:
+	- days: the window, default 7, max 30 (optional)
+	- category: only tags in this category (optional)
+	- limit: max tags, default 10, max 50 (optional)
+
+Response:
+	- Success: 200 OK
+		{
+			"days": 7,
+			"tags": [
+				{
+					"id": "pickleball",
+					"name": "Pickleball",
+					"category": "sports",
+					"uses": 48,
+					"previous": 12
+				}
+			]
+		}
+	- Server Error: 500
+*/
+func TrendingTags(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days <= 0 || days > 30 {
+		days = 7
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 || limit > 50 {
+		limit = 10
+	}
+
+	var category *string
+	if value := c.Query("category"); value != "" {
+		category = &value
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	window := (time.Duration(days) * 24 * time.Hour).Seconds()
+
+	query := `
+		WITH uses AS (
+			SELECT tag_id, created_at FROM user_tags
+			WHERE created_at > NOW() - make_interval(secs => 2 * $1::FLOAT8)
+			UNION ALL
+			SELECT tag_id, created_at FROM function_tags
+			WHERE created_at > NOW() - make_interval(secs => 2 * $1::FLOAT8)
+		),
+		counts AS (
+			SELECT tag_id,
+			       COUNT(*) FILTER (WHERE created_at > NOW() - make_interval(secs => $1::FLOAT8)) AS recent,
+			       COUNT(*) FILTER (WHERE created_at <= NOW() - make_interval(secs => $1::FLOAT8)) AS previous
+			FROM uses
+			GROUP BY tag_id
+		)
+		SELECT t.tag_id, t.name, t.category::TEXT, counts.recent, counts.previous
+		FROM counts
+		JOIN tags t ON t.tag_id = counts.tag_id
+		WHERE counts.recent > 0
+		  AND ($2::TEXT IS NULL OR t.category::TEXT = $2)
+		ORDER BY counts.recent DESC, counts.recent - counts.previous DESC, t.name
+		LIMIT $3;
+	`
+
+	rows, err := db.Query(ctx, query, window, category, limit)
+	if err != nil {
+		fmt.Printf("Error loading trending tags: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer rows.Close()
+
+	trending := []TrendingTag{}
+	for rows.Next() {
+		var tag TrendingTag
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.Category, &tag.Uses, &tag.Previous); err != nil {
+			fmt.Printf("Error scanning trending tag: %v\n", err)
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		trending = append(trending, tag)
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"days": days, "tags": trending})
+}
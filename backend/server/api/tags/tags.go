@@ -0,0 +1,134 @@
+package tags
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+/*
+Interest tags are a managed taxonomy: every tag has a stable id (a slug like
+"board-games"), a display name and a category, plus any number of aliases
+("bball" for basketball). Users, meetups and linkups are tagged by id, and
+only ids that exist in the tags table are accepted.
+*/
+
+const (
+	MaxPerUser     = 20
+	MaxPerFunction = 5
+)
+
+type Tag struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+}
+
+// Querier is satisfied by both *pgxpool.Pool and pgx.Tx.
+type Querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// Clean trims and lower-cases terms and drops empty ones and repeats.
+func Clean(terms []string) []string {
+	cleaned := []string{}
+	seen := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		term = strings.ToLower(strings.TrimSpace(term))
+		if term != "" && !seen[term] {
+			seen[term] = true
+			cleaned = append(cleaned, term)
+		}
+	}
+	return cleaned
+}
+
+// Resolve maps terms onto canonical tag ids, in order and without repeats.
+// Only tag ids match unless byName is set, in which case tag names and
+// aliases do too, ignoring case. Terms matching no tag come back in unknown.
+func Resolve(ctx context.Context, db Querier, terms []string, byName bool) ([]string, []string, error) {
+	terms = Clean(terms)
+
+	query := `
+		SELECT input.term, matched.tag_id
+		FROM UNNEST($1::TEXT[]) WITH ORDINALITY AS input(term, ordinal)
+		LEFT JOIN LATERAL (
+			SELECT t.tag_id FROM tags t WHERE t.tag_id = input.term
+			UNION ALL
+			SELECT t.tag_id FROM tags t WHERE $2 AND LOWER(t.name) = input.term
+			UNION ALL
+			SELECT a.tag_id FROM tag_aliases a WHERE $2 AND a.alias = input.term
+			LIMIT 1
+		) matched ON true
+		ORDER BY input.ordinal;
+	`
+
+	rows, err := db.Query(ctx, query, terms, byName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	unknown := []string{}
+	seen := make(map[string]bool, len(terms))
+	for rows.Next() {
+		var term string
+		var tagID *string
+		if err := rows.Scan(&term, &tagID); err != nil {
+			return nil, nil, err
+		}
+
+		switch {
+		case tagID == nil:
+			unknown = append(unknown, term)
+		case !seen[*tagID]:
+			seen[*tagID] = true
+			ids = append(ids, *tagID)
+		}
+	}
+	return ids, unknown, rows.Err()
+}
+
+// UserTags are the tags a user picked, by name.
+func UserTags(ctx context.Context, db Querier, userID uuid.UUID) ([]Tag, error) {
+	rows, err := db.Query(ctx, `
+		SELECT t.tag_id, t.name, t.category::TEXT
+		FROM user_tags ut
+		JOIN tags t ON t.tag_id = ut.tag_id
+		WHERE ut.user_id = $1
+		ORDER BY t.name;
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[Tag])
+}
+
+// SetUserTags replaces a user's tags with ids, which must exist. Tags the user
+// already had keep the date they were first picked.
+func SetUserTags(ctx context.Context, tx pgx.Tx, userID uuid.UUID, ids []string) error {
+	_, err := tx.Exec(ctx, `DELETE FROM user_tags WHERE user_id = $1 AND tag_id <> ALL($2::TEXT[]);`, userID, ids)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO user_tags (user_id, tag_id)
+		SELECT $1, UNNEST($2::TEXT[])
+		ON CONFLICT (user_id, tag_id) DO NOTHING;
+	`, userID, ids)
+	return err
+}
+
+// Names are the display names of tags, in the same order.
+func Names(tags []Tag) []string {
+	names := make([]string, len(tags))
+	for i, tag := range tags {
+		names[i] = tag.Name
+	}
+	return names
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"server/api/media"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Deleted accounts can be restored by logging in again until the grace period
+// runs out; after that the purge job removes them for good.
+const accountDeletionGrace = 30 * 24 * time.Hour
+
+/*
+====================
+DeleteAccount
+
+Purpose: Schedule the authenticated user's account for deletion. The account is
+hidden and logged out everywhere right away, and purged for good after 30 days.
+Logging in again before then cancels the deletion.
+
+Endpoint: DELETE /api/users
+Authorization: Bearer token required
+
+Body (JSON):
+	{
+		"password": "SecurePass123"
+	}
+
+Response:
+	- Success: 202 Accepted
+		{
+			"message": "Account scheduled for deletion",
+			"purge_after": "2024-12-02T15:00:00Z"
+		}
+	- Bad Request: 400 (missing password)
+	- Unauthorized: 401 (wrong password)
+	- Server Error: 500
+*/
+func DeleteAccount(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		Password string `json:"password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var passwordHash string
+	err = db.QueryRow(ctx, `SELECT password_hash FROM users WHERE user_id = $1;`, userID).Scan(&passwordHash)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(request.Password)) != nil {
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "Password is incorrect"})
+		return
+	}
+
+	var deletedAt time.Time
+	err = db.QueryRow(ctx, `
+		UPDATE users SET deleted_at = COALESCE(deleted_at, NOW())
+		WHERE user_id = $1
+		RETURNING deleted_at;
+	`, userID).Scan(&deletedAt)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	_, err = db.Exec(ctx, `UPDATE user_profiles SET active = false WHERE user_id = $1;`, userID)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if _, err = RevokeUserSessions(ctx, db, userID); err != nil {
+		fmt.Printf("Error revoking sessions of deleted account: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusAccepted, gin.H{
+		"message":     "Account scheduled for deletion",
+		"purge_after": deletedAt.Add(accountDeletionGrace),
+	})
+}
+
+// cancelAccountDeletion brings back an account that is still in its grace
+// period. Called whenever a login succeeds.
+func cancelAccountDeletion(ctx context.Context, db *pgxpool.Pool, userID uuid.UUID) error {
+	var restored uuid.UUID
+	err := db.QueryRow(ctx, `
+		UPDATE users SET deleted_at = NULL
+		WHERE user_id = $1 AND deleted_at IS NOT NULL
+		RETURNING user_id;
+	`, userID).Scan(&restored)
+
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Ghost mode survives the round trip
+	_, err = db.Exec(ctx, `UPDATE user_profiles SET active = visibility <> 'hidden' WHERE user_id = $1;`, userID)
+	return err
+}
+
+// purgeAccount hard deletes one account whose grace period is over. Most rows
+// go with the users row by cascade, but functions.host doesn't cascade, so the
+// functions the user hosted (and with them their attendee lists) are removed
+// first, and the user is dropped as co-host of any linkup. The avatar files
+// are deleted from storage once the rows are gone.
+func purgeAccount(ctx context.Context, db *pgxpool.Pool, storage media.Storage, userID uuid.UUID) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Recheck under lock in case the user logged back in meanwhile
+	var deletedAt *time.Time
+	var avatarKey *string
+	err = tx.QueryRow(ctx, `
+		SELECT u.deleted_at, profile.avatar_key
+		FROM users u
+		LEFT JOIN user_profiles profile ON profile.user_id = u.user_id
+		WHERE u.user_id = $1
+		FOR UPDATE OF u;
+	`, userID).Scan(&deletedAt, &avatarKey)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if deletedAt == nil || time.Since(*deletedAt) < accountDeletionGrace {
+		return nil
+	}
+
+	statements := []string{
+		`DELETE FROM functions WHERE host = $1;`,
+		`UPDATE functions SET host1 = NULL WHERE host1 = $1;`,
+		`UPDATE user_profiles SET friends = array_remove(friends, $1) WHERE $1 = ANY(friends);`,
+		`DELETE FROM users WHERE user_id = $1;`,
+	}
+	for _, statement := range statements {
+		if _, err = tx.Exec(ctx, statement, userID); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	// Only now, so a failed purge still has its photo when it is retried
+	if avatarKey != nil {
+		media.RemoveAvatar(ctx, storage, *avatarKey)
+	}
+	return nil
+}
+
+// PurgeDeletedAccounts hard deletes every account past its grace period,
+// avatar files included, and returns how many went. An account that fails to
+// purge is logged and skipped so it can't hold up the others; the failures
+// come back joined in the error.
+func PurgeDeletedAccounts(ctx context.Context, db *pgxpool.Pool, storage media.Storage) (int, error) {
+	rows, err := db.Query(ctx, `
+		SELECT user_id FROM users
+		WHERE deleted_at < NOW() - make_interval(secs => $1);
+	`, accountDeletionGrace.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	userIDs, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	var failures []error
+	for _, userID := range userIDs {
+		// Out of time: the rest wait for the next run
+		if ctx.Err() != nil {
+			failures = append(failures, ctx.Err())
+			break
+		}
+
+		if err := purgeAccount(ctx, db, storage, userID); err != nil {
+			fmt.Printf("Error purging account %s: %v\n", userID, err)
+			failures = append(failures, fmt.Errorf("purging %s: %w", userID, err))
+			continue
+		}
+		purged++
+	}
+	return purged, errors.Join(failures...)
+}
+
+// StartAccountPurger runs PurgeDeletedAccounts every interval until ctx is done.
+func StartAccountPurger(ctx context.Context, db *pgxpool.Pool, storage media.Storage, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			purgeCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+			purged, err := PurgeDeletedAccounts(purgeCtx, db, storage)
+			cancel()
+
+			if purged > 0 {
+				fmt.Printf("Purged %d deleted accounts\n", purged)
+			}
+			if err != nil {
+				fmt.Printf("Error purging deleted accounts: %v\n", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,313 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+Signing keys are read from the JSON file named by JWT_KEYS_FILE:
+
+	{
+		"signing_kid": "2025-06-ed",
+		"keys": [
+			{"kid": "2025-06-ed", "alg": "EdDSA", "private_key_file": "keys/2025-06-ed.pem"},
+			{"kid": "2025-01-rs", "alg": "RS256", "public_key_file": "keys/2025-01-rs.pub.pem"},
+			{"kid": "legacy-hs", "alg": "HS256", "secret_env": "JWT_LEGACY_SECRET"}
+		]
+	}
+
+Every key in the file verifies tokens; only signing_kid signs new ones. To
+rotate, add the new key, point signing_kid at it and keep the old key listed
+(a public key is enough) until the longest-lived token signed with it expires.
+
+Without JWT_KEYS_FILE, JWT_SECRET is used as a single HS256 key. With neither,
+an ephemeral Ed25519 key is generated, which is only good for local development
+since every token dies with the process.
+*/
+
+type keyConfig struct {
+	KeyID          string `json:"kid"`
+	Algorithm      string `json:"alg"`
+	PrivateKeyFile string `json:"private_key_file"`
+	PublicKeyFile  string `json:"public_key_file"`
+	Secret         string `json:"secret"`
+	SecretEnv      string `json:"secret_env"`
+}
+
+type keysConfig struct {
+	SigningKeyID string      `json:"signing_kid"`
+	Keys         []keyConfig `json:"keys"`
+}
+
+type signingKey struct {
+	keyID     string
+	method    jwt.SigningMethod
+	signKey   any // nil for verify-only keys
+	verifyKey any
+}
+
+type keySet struct {
+	signing *signingKey
+	byID    map[string]*signingKey
+}
+
+var (
+	loadKeysOnce sync.Once
+	loadedKeys   *keySet
+)
+
+// LoadSigningKeys reads the signing keys from the environment. It is safe to
+// call more than once; only the first call does any work.
+func LoadSigningKeys() error {
+	var err error
+	loadKeysOnce.Do(func() {
+		loadedKeys, err = readKeySet()
+	})
+	if err == nil && loadedKeys == nil {
+		err = errors.New("signing keys failed to load")
+	}
+	return err
+}
+
+func currentKeys() *keySet {
+	if err := LoadSigningKeys(); err != nil {
+		panic(err)
+	}
+	return loadedKeys
+}
+
+func readKeySet() (*keySet, error) {
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+
+		var config keysConfig
+		if err := json.Unmarshal(contents, &config); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		return buildKeySet(config)
+	}
+
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		return buildKeySet(keysConfig{
+			SigningKeyID: "default",
+			Keys:         []keyConfig{{KeyID: "default", Algorithm: "HS256", Secret: secret}},
+		})
+	}
+
+	fmt.Println("⚠️  No JWT_KEYS_FILE or JWT_SECRET set, using an ephemeral signing key")
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{
+		keyID:     "ephemeral",
+		method:    jwt.SigningMethodEdDSA,
+		signKey:   private,
+		verifyKey: private.Public(),
+	}
+	return &keySet{signing: key, byID: map[string]*signingKey{key.keyID: key}}, nil
+}
+
+func buildKeySet(config keysConfig) (*keySet, error) {
+	set := &keySet{byID: make(map[string]*signingKey)}
+
+	for _, kc := range config.Keys {
+		if kc.KeyID == "" {
+			return nil, errors.New("signing key without a kid")
+		}
+		if _, exists := set.byID[kc.KeyID]; exists {
+			return nil, fmt.Errorf("duplicate kid %q", kc.KeyID)
+		}
+
+		key, err := parseKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", kc.KeyID, err)
+		}
+		set.byID[kc.KeyID] = key
+	}
+
+	set.signing = set.byID[config.SigningKeyID]
+	if set.signing == nil {
+		return nil, fmt.Errorf("signing_kid %q is not in keys", config.SigningKeyID)
+	}
+	if set.signing.signKey == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", config.SigningKeyID)
+	}
+
+	return set, nil
+}
+
+func parseKey(kc keyConfig) (*signingKey, error) {
+	key := &signingKey{keyID: kc.KeyID}
+
+	switch kc.Algorithm {
+	case "HS256":
+		secret := kc.Secret
+		if kc.SecretEnv != "" {
+			secret = os.Getenv(kc.SecretEnv)
+		}
+		if len(secret) < 32 {
+			return nil, errors.New("HS256 secret must be at least 32 bytes")
+		}
+		key.method = jwt.SigningMethodHS256
+		key.signKey = []byte(secret)
+		key.verifyKey = []byte(secret)
+
+	case "RS256":
+		key.method = jwt.SigningMethodRS256
+		if kc.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = private
+			key.verifyKey = &private.PublicKey
+		} else {
+			pem, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case "EdDSA":
+		key.method = jwt.SigningMethodEdDSA
+		if kc.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = private
+			key.verifyKey = private.(crypto.Signer).Public()
+		} else {
+			pem, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey, err = jwt.ParseEdPublicKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported alg %q", kc.Algorithm)
+	}
+
+	return key, nil
+}
+
+// signToken signs claims with the active signing key and stamps its kid in the header.
+func signToken(claims jwt.Claims) (string, error) {
+	key := currentKeys().signing
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.keyID
+	return token.SignedString(key.signKey)
+}
+
+// verificationKey is the jwt.Keyfunc for tokens issued by this service.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	keyID, _ := token.Header["kid"].(string)
+
+	key, found := currentKeys().byID[keyID]
+	if !found {
+		return nil, fmt.Errorf("unknown kid %q", keyID)
+	}
+
+	// Never let the token pick the algorithm, or an RS256 public key could be
+	// used as an HMAC secret.
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, jwt.ErrSignatureInvalid
+	}
+
+	return key.verifyKey, nil
+}
+
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Modulus   string `json:"n,omitempty"`
+	Exponent  string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+/*
+====================
+GetJWKS
+
+Purpose: Publish the public halves of the asymmetric signing keys so other
+services can verify LinkUp access tokens. HS256 keys are never published.
+
+Endpoint: GET /.well-known/jwks.json
+Authorization: None
+
+Response:
+	- Success: 200 OK
+		{
+			"keys": [
+				{"kty": "OKP", "kid": "2025-06-ed", "use": "sig", "alg": "EdDSA", "crv": "Ed25519", "x": "..."}
+			]
+		}
+*/
+func GetJWKS(c *gin.Context) {
+	published := []jsonWebKey{}
+
+	for _, key := range currentKeys().byID {
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			published = append(published, jsonWebKey{
+				KeyType:   "RSA",
+				KeyID:     key.keyID,
+				Use:       "sig",
+				Algorithm: key.method.Alg(),
+				Modulus:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			published = append(published, jsonWebKey{
+				KeyType:   "OKP",
+				KeyID:     key.keyID,
+				Use:       "sig",
+				Algorithm: key.method.Alg(),
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": published})
+}
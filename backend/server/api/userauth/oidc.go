@@ -0,0 +1,641 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+Single sign-on providers are read from the JSON file named by OIDC_PROVIDERS_FILE:
+
+	[
+		{
+			"name": "umich",
+			"issuer": "https://shibboleth.umich.edu",
+			"client_id": "linkup",
+			"client_secret_env": "UMICH_OIDC_SECRET",
+			"redirect_url": "linkup://auth/callback",
+			"email_domains": ["umich.edu"]
+		},
+		{
+			"name": "google",
+			"issuer": "https://accounts.google.com",
+			"client_id": "1234.apps.googleusercontent.com",
+			"client_secret_env": "GOOGLE_OIDC_SECRET",
+			"redirect_url": "linkup://auth/callback"
+		}
+	]
+
+Endpoints are discovered from the issuer's /.well-known/openid-configuration,
+so any standards-compliant provider (or a local mock) works. email_domains,
+when set, limits which verified emails the provider may sign in.
+*/
+
+const oidcStateTTL = 10 * time.Minute
+
+type OIDCProvider struct {
+	Name            string   `json:"name"`
+	Issuer          string   `json:"issuer"`
+	ClientID        string   `json:"client_id"`
+	ClientSecretEnv string   `json:"client_secret_env"`
+	RedirectURL     string   `json:"redirect_url"`
+	Scopes          []string `json:"scopes"`
+	EmailDomains    []string `json:"email_domains"`
+
+	clientSecret string
+	discovery    *oidcDiscovery
+	keys         map[string]any
+	keysFetched  time.Time
+	mu           sync.Mutex
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+var (
+	oidcProviders    map[string]*OIDCProvider
+	oidcLoadOnce     sync.Once
+	oidcLoadError    error
+	oidcHTTPClient   = &http.Client{Timeout: 10 * time.Second}
+	errOIDCNoSuchIdP = errors.New("unknown sign-in provider")
+)
+
+// LoadOIDCProviders reads the provider list. Without OIDC_PROVIDERS_FILE
+// single sign-on is simply off.
+func LoadOIDCProviders() error {
+	oidcLoadOnce.Do(func() {
+		oidcProviders = make(map[string]*OIDCProvider)
+
+		path := os.Getenv("OIDC_PROVIDERS_FILE")
+		if path == "" {
+			return
+		}
+
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			oidcLoadError = err
+			return
+		}
+
+		var providers []*OIDCProvider
+		if err := json.Unmarshal(contents, &providers); err != nil {
+			oidcLoadError = fmt.Errorf("parsing %s: %w", path, err)
+			return
+		}
+
+		for _, provider := range providers {
+			if provider.Name == "" || provider.Issuer == "" || provider.ClientID == "" || provider.RedirectURL == "" {
+				oidcLoadError = fmt.Errorf("provider %q is missing name, issuer, client_id or redirect_url", provider.Name)
+				return
+			}
+			if len(provider.Scopes) == 0 {
+				provider.Scopes = []string{"openid", "email", "profile"}
+			}
+			if provider.ClientSecretEnv != "" {
+				provider.clientSecret = os.Getenv(provider.ClientSecretEnv)
+			}
+			oidcProviders[provider.Name] = provider
+		}
+	})
+	return oidcLoadError
+}
+
+func findOIDCProvider(name string) (*OIDCProvider, error) {
+	if err := LoadOIDCProviders(); err != nil {
+		return nil, err
+	}
+	provider, found := oidcProviders[name]
+	if !found {
+		return nil, errOIDCNoSuchIdP
+	}
+	return provider, nil
+}
+
+func (provider *OIDCProvider) getJSON(ctx context.Context, endpoint string, target any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+func (provider *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if provider.discovery != nil {
+		return provider.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	wellKnown := strings.TrimSuffix(provider.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := provider.getJSON(ctx, wellKnown, &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != provider.Issuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match %q", discovery.Issuer, provider.Issuer)
+	}
+
+	provider.discovery = &discovery
+	return provider.discovery, nil
+}
+
+// signingKey returns the provider's key for kid, refetching the JWKS when the
+// kid is new (providers rotate keys) but no more than once a minute.
+func (provider *OIDCProvider) signingKey(ctx context.Context, keyID string) (any, error) {
+	discovery, err := provider.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if key, found := provider.keys[keyID]; found {
+		return key, nil
+	}
+	if time.Since(provider.keysFetched) < time.Minute {
+		return nil, fmt.Errorf("unknown kid %q", keyID)
+	}
+
+	var jwks struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := provider.getJSON(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	provider.keys = make(map[string]any)
+	provider.keysFetched = time.Now()
+	for _, raw := range jwks.Keys {
+		kid, key, err := parseJWK(raw)
+		if err != nil {
+			continue // skip key types we don't understand
+		}
+		provider.keys[kid] = key
+	}
+
+	key, found := provider.keys[keyID]
+	if !found {
+		return nil, fmt.Errorf("unknown kid %q", keyID)
+	}
+	return key, nil
+}
+
+func parseJWK(raw json.RawMessage) (string, any, error) {
+	var jwk struct {
+		KeyType string `json:"kty"`
+		KeyID   string `json:"kid"`
+		Use     string `json:"use"`
+		N       string `json:"n"`
+		E       string `json:"e"`
+		Curve   string `json:"crv"`
+		X       string `json:"x"`
+		Y       string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return "", nil, err
+	}
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return "", nil, errors.New("not a signing key")
+	}
+
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return "", nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return "", nil, err
+		}
+		return jwk.KeyID, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return "", nil, fmt.Errorf("unsupported curve %q", jwk.Curve)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return "", nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return "", nil, err
+		}
+		return jwk.KeyID, &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+
+	case "OKP":
+		x, err := decode(jwk.X)
+		if err != nil || jwk.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return "", nil, errors.New("invalid Ed25519 key")
+		}
+		return jwk.KeyID, ed25519.PublicKey(x), nil
+	}
+
+	return "", nil, fmt.Errorf("unsupported kty %q", jwk.KeyType)
+}
+
+func randomURLString(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// pkceChallenge is the S256 code challenge for a verifier (RFC 7636).
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+type oidcIDTokenClaims struct {
+	Email         string `json:"email"`
+	EmailVerified any    `json:"email_verified"` // some providers send "true"
+	Name          string `json:"name"`
+	Nonce         string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+func (claims *oidcIDTokenClaims) emailVerified() bool {
+	switch verified := claims.EmailVerified.(type) {
+	case bool:
+		return verified
+	case string:
+		return verified == "true"
+	}
+	return false
+}
+
+// exchangeCode trades an authorization code for a verified ID token.
+func (provider *OIDCProvider) exchangeCode(ctx context.Context, code string, verifier string, nonce string) (*oidcIDTokenClaims, error) {
+	discovery, err := provider.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.RedirectURL)
+	form.Set("client_id", provider.ClientID)
+	form.Set("code_verifier", verifier)
+	if provider.clientSecret != "" {
+		form.Set("client_secret", provider.clientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint: %s", resp.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token endpoint returned no id_token")
+	}
+
+	var claims oidcIDTokenClaims
+	_, err = jwt.ParseWithClaims(tokens.IDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		return provider.signingKey(ctx, keyID)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "EdDSA"}),
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(provider.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30*time.Second))
+
+	if err != nil {
+		return nil, err
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+
+	return &claims, nil
+}
+
+func (provider *OIDCProvider) allowsEmail(email string) bool {
+	if len(provider.EmailDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range provider.EmailDomains {
+		allowed = strings.ToLower(allowed)
+		if domain == allowed || strings.HasSuffix(domain, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+====================
+StartOIDCLogin
+
+Purpose: Begin "Sign in with ..." using the authorization code flow with PKCE.
+The app opens authorization_url in a browser; the provider sends the user back
+to the provider's redirect_url with code and state, which the app then posts to
+FinishOIDCLogin.
+
+Endpoint: GET /api/users/oidc/:provider/start
+Authorization: None
+
+Frontend Request:
+	Query Params:
+		- device_name: shown in the session list (optional)
+
+Response:
+	- Success: 200 OK
+		{
+			"authorization_url": "https://shibboleth.umich.edu/authorize?...",
+			"state": "opaque-state"
+		}
+	- Not Found: 404 (unknown provider)
+	- Bad Gateway: 502 (provider discovery failed)
+	- Server Error: 500
+*/
+func StartOIDCLogin(c *gin.Context) {
+	provider, err := findOIDCProvider(c.Param("provider"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "Unknown sign-in provider"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	discovery, err := provider.discover(ctx)
+	if err != nil {
+		fmt.Printf("OIDC discovery for %s failed: %v\n", provider.Name, err)
+		c.IndentedJSON(http.StatusBadGateway, gin.H{"error": "Sign-in provider unavailable"})
+		return
+	}
+
+	state, err := randomURLString(24)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	nonce, err := randomURLString(24)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	verifier, err := randomURLString(48)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO oidc_login_states (state, provider, nonce, code_verifier, device_name, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6);
+	`, state, provider.Name, nonce, verifier, c.Query("device_name"), time.Now().Add(oidcStateTTL))
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", provider.ClientID)
+	params.Set("redirect_uri", provider.RedirectURL)
+	params.Set("scope", strings.Join(provider.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", pkceChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{
+		"authorization_url": discovery.AuthorizationEndpoint + separator + params.Encode(),
+		"state":             state,
+	})
+}
+
+/*
+====================
+FinishOIDCLogin
+
+Purpose: Complete single sign-on. The identity is matched to a LinkUp account
+by a previous link, or else by the provider's verified email, which links it
+for next time. Logging in this way also marks the account's email verified.
+
+Endpoint: POST /api/users/oidc/:provider/callback
+Authorization: None
+
+Body (JSON):
+	{
+		"code": "code-from-the-redirect",
+		"state": "state-from-the-redirect"
+	}
+
+Response:
+	- Success: 202 Accepted (same body as login, or a 2FA challenge)
+	- Bad Request: 400 (missing fields, unknown or expired state)
+	- Unauthorized: 401 (code exchange or ID token checks failed)
+	- Forbidden: 403 (email not verified by the provider, outside its allowed domains, or the account is suspended)
+	- Not Found: 404 (no LinkUp account has this email, sign up first)
+	- Server Error: 500
+*/
+func FinishOIDCLogin(c *gin.Context) {
+	provider, err := findOIDCProvider(c.Param("provider"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "Unknown sign-in provider"})
+		return
+	}
+
+	var request struct {
+		Code  string `json:"code" binding:"required"`
+		State string `json:"state" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// States are single use
+	var nonce, verifier, deviceName string
+	err = db.QueryRow(ctx, `
+		DELETE FROM oidc_login_states
+		WHERE state = $1 AND provider = $2 AND expires_at > NOW()
+		RETURNING nonce, code_verifier, device_name;
+	`, request.State, provider.Name).Scan(&nonce, &verifier, &deviceName)
+
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Sign-in expired, please try again"})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	claims, err := provider.exchangeCode(ctx, request.Code, verifier, nonce)
+	if err != nil {
+		fmt.Printf("OIDC login with %s failed: %v\n", provider.Name, err)
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "Sign-in failed"})
+		return
+	}
+
+	user := UserInfo{DeviceName: deviceName}
+	var success AuthResponse
+
+	// A previously linked identity wins, even if the email has since changed
+	err = db.QueryRow(ctx, `
+		SELECT u.user_id, u.username
+		FROM user_identities identity
+		JOIN users u ON identity.user_id = u.user_id
+		WHERE identity.provider = $1 AND identity.subject = $2;
+	`, provider.Name, claims.Subject).Scan(&success.UserID, &success.Username)
+
+	if err == pgx.ErrNoRows {
+		if claims.Email == "" || !claims.emailVerified() || !provider.allowsEmail(claims.Email) {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "This account's email can't be used to sign in"})
+			return
+		}
+
+		err = db.QueryRow(ctx, `
+			SELECT user_id, username FROM users WHERE LOWER(email) = LOWER($1);
+		`, claims.Email).Scan(&success.UserID, &success.Username)
+
+		if err == pgx.ErrNoRows {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": "No LinkUp account uses this email, sign up first"})
+			return
+		}
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+
+		_, err = db.Exec(ctx, `
+			INSERT INTO user_identities (provider, subject, user_id, email)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (provider, subject) DO NOTHING;
+		`, provider.Name, claims.Subject, success.UserID, claims.Email)
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if claims.emailVerified() {
+		_, err = db.Exec(ctx, `
+			UPDATE user_profiles profile SET verified_email = true
+			FROM users u
+			WHERE profile.user_id = u.user_id AND u.user_id = $1 AND LOWER(u.email) = LOWER($2);
+		`, success.UserID, claims.Email)
+		if err != nil {
+			fmt.Printf("Error marking email verified: %v\n", err)
+		}
+	}
+
+	user.UserID = success.UserID
+	user.Username = success.Username
+
+	completeLogin(c, ctx, db, user, success)
+}
+
+/*
+====================
+ListOIDCProviders
+
+Purpose: Names of the configured sign-in providers, for drawing login buttons.
+
+Endpoint: GET /api/users/oidc
+Authorization: None
+
+Response:
+	- Success: 200 OK
+		{
+			"providers": ["google", "umich"]
+		}
+*/
+func ListOIDCProviders(c *gin.Context) {
+	names := []string{}
+	if err := LoadOIDCProviders(); err == nil {
+		for name := range oidcProviders {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	c.IndentedJSON(http.StatusOK, gin.H{"providers": names})
+}
@@ -0,0 +1,524 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+A local OIDC provider for the single sign-on tests. It serves discovery, a
+JWKS and a token endpoint that checks the PKCE verifier the way a real
+provider does. The authorization step, which happens in a browser, is
+authorize: it takes the URL StartOIDCLogin hands out and returns a code.
+
+The handler tests need Postgres with defineTables.sql loaded, named by
+TEST_DATABASE_URL, and are skipped without it.
+*/
+
+const mockClientID = "linkup"
+
+type mockGrant struct {
+	challenge string
+	nonce     string
+}
+
+type mockIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu            sync.Mutex
+	grants        map[string]mockGrant
+	subject       string
+	email         string
+	emailVerified bool
+	nonceOverride string // sent instead of the real nonce when set
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &mockIssuer{
+		key:           key,
+		grants:        make(map[string]mockGrant),
+		subject:       uuid.NewString(),
+		email:         "student-" + uuid.NewString()[:8] + "@umich.edu",
+		emailVerified: true,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", issuer.serveDiscovery)
+	mux.HandleFunc("GET /jwks", issuer.serveJWKS)
+	mux.HandleFunc("POST /token", issuer.serveToken)
+
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (issuer *mockIssuer) provider() *OIDCProvider {
+	return &OIDCProvider{
+		Name:        "mock",
+		Issuer:      issuer.server.URL,
+		ClientID:    mockClientID,
+		RedirectURL: "linkup://auth/callback",
+		Scopes:      []string{"openid", "email"},
+	}
+}
+
+func (issuer *mockIssuer) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 issuer.server.URL,
+		"authorization_endpoint": issuer.server.URL + "/authorize",
+		"token_endpoint":         issuer.server.URL + "/token",
+		"jwks_uri":               issuer.server.URL + "/jwks",
+	})
+}
+
+func (issuer *mockIssuer) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	public := issuer.key.PublicKey
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "mock-key",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}},
+	})
+}
+
+func (issuer *mockIssuer) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+
+	code := r.PostForm.Get("code")
+	grant, found := issuer.grants[code]
+	delete(issuer.grants, code) // codes are single use
+	if !found || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("client_id") != mockClientID {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	// RFC 7636 S256: BASE64URL(SHA256(code_verifier)) must equal the challenge
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		http.Error(w, `{"error":"invalid_grant","error_description":"PKCE verification failed"}`, http.StatusBadRequest)
+		return
+	}
+
+	nonce := grant.nonce
+	if issuer.nonceOverride != "" {
+		nonce = issuer.nonceOverride
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            issuer.server.URL,
+		"sub":            issuer.subject,
+		"aud":            mockClientID,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          issuer.email,
+		"email_verified": issuer.emailVerified,
+	})
+	token.Header["kid"] = "mock-key"
+
+	idToken, err := token.SignedString(issuer.key)
+	if err != nil {
+		http.Error(w, `{"error":"server_error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+// grant issues a code the way the provider's login page would once the user signs in.
+func (issuer *mockIssuer) grant(challenge string, nonce string) string {
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+
+	code := uuid.NewString()
+	issuer.grants[code] = mockGrant{challenge: challenge, nonce: nonce}
+	return code
+}
+
+// authorize checks an authorization URL from StartOIDCLogin and returns a code for it.
+func (issuer *mockIssuer) authorize(t *testing.T, authorizationURL string) string {
+	t.Helper()
+
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authorizationURL, issuer.server.URL+"/authorize?") {
+		t.Fatalf("authorization_url %q is not the discovered endpoint", authorizationURL)
+	}
+
+	params := parsed.Query()
+	for name, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             mockClientID,
+		"redirect_uri":          "linkup://auth/callback",
+		"code_challenge_method": "S256",
+	} {
+		if got := params.Get(name); got != want {
+			t.Fatalf("authorization_url %s = %q, want %q", name, got, want)
+		}
+	}
+	if params.Get("code_challenge") == "" || params.Get("nonce") == "" || params.Get("state") == "" {
+		t.Fatalf("authorization_url %q is missing code_challenge, nonce or state", authorizationURL)
+	}
+
+	return issuer.grant(params.Get("code_challenge"), params.Get("nonce"))
+}
+
+// useOIDCProviders replaces the configured providers for one test.
+func useOIDCProviders(t *testing.T, providers ...*OIDCProvider) {
+	oidcLoadOnce.Do(func() {})
+
+	previous := oidcProviders
+	oidcProviders = make(map[string]*OIDCProvider)
+	for _, provider := range providers {
+		oidcProviders[provider.Name] = provider
+	}
+	t.Cleanup(func() { oidcProviders = previous })
+}
+
+func TestOIDCExchangeCodeChecksPKCE(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+	ctx := context.Background()
+
+	verifier, err := randomURLString(48)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := issuer.grant(pkceChallenge(verifier), "the-nonce")
+	claims, err := provider.exchangeCode(ctx, code, verifier, "the-nonce")
+	if err != nil {
+		t.Fatalf("exchangeCode with the right verifier: %v", err)
+	}
+	if claims.Subject != issuer.subject || claims.Email != issuer.email || !claims.emailVerified() {
+		t.Errorf("claims = %+v, want subject %s and verified email %s", claims, issuer.subject, issuer.email)
+	}
+
+	code = issuer.grant(pkceChallenge(verifier), "the-nonce")
+	if _, err := provider.exchangeCode(ctx, code, verifier+"x", "the-nonce"); err == nil {
+		t.Error("exchangeCode accepted a verifier that doesn't match the challenge")
+	}
+
+	// A plain challenge (the verifier itself) is not S256
+	code = issuer.grant(verifier, "the-nonce")
+	if _, err := provider.exchangeCode(ctx, code, verifier, "the-nonce"); err == nil {
+		t.Error("exchangeCode succeeded against a plain code challenge")
+	}
+}
+
+func TestOIDCExchangeCodeRejectsNonceMismatch(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+
+	code := issuer.grant(pkceChallenge("verifier"), "sent-nonce")
+	_, err := provider.exchangeCode(context.Background(), code, "verifier", "expected-nonce")
+	if err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("exchangeCode error = %v, want a nonce mismatch", err)
+	}
+}
+
+func TestListOIDCProvidersIsSorted(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useOIDCProviders(t,
+		&OIDCProvider{Name: "umich"},
+		&OIDCProvider{Name: "google"},
+		&OIDCProvider{Name: "msu"},
+		&OIDCProvider{Name: "apple"},
+	)
+
+	for i := 0; i < 5; i++ {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		ListOIDCProviders(c)
+
+		var response struct {
+			Providers []string `json:"providers"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(response.Providers, ","); got != "apple,google,msu,umich" {
+			t.Fatalf("providers = %s, want apple,google,msu,umich", got)
+		}
+	}
+}
+
+// oidcTestRouter serves the OIDC routes against TEST_DATABASE_URL.
+func oidcTestRouter(t *testing.T) (*gin.Engine, *pgxpool.Pool) {
+	t.Helper()
+
+	connectionURL := os.Getenv("TEST_DATABASE_URL")
+	if connectionURL == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := pgxpool.New(context.Background(), connectionURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("db", db)
+		c.Next()
+	})
+	router.GET("/api/users/oidc/:provider/start", StartOIDCLogin)
+	router.POST("/api/users/oidc/:provider/callback", FinishOIDCLogin)
+	return router, db
+}
+
+// createOIDCTestUser adds an account with email, removed again after the test.
+func createOIDCTestUser(t *testing.T, db *pgxpool.Pool, email string) uuid.UUID {
+	t.Helper()
+	ctx := context.Background()
+
+	var userID uuid.UUID
+	suffix := uuid.NewString()[:8]
+	phone, err := rand.Int(rand.Reader, big.NewInt(1e10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.QueryRow(ctx, `
+		INSERT INTO users (username, email, password_hash, name, phone_number)
+		VALUES ($1, $2, 'not-a-password-hash', 'OIDC Test', $3)
+		RETURNING user_id;
+	`, "oidc_"+suffix, email, fmt.Sprintf("%010d", phone)).Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(context.Background(), `DELETE FROM users WHERE user_id = $1;`, userID) })
+
+	// trigger_create_user_profile has added the user_profiles row
+	return userID
+}
+
+func startOIDCTestLogin(t *testing.T, router *gin.Engine) (string, string) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/users/oidc/mock/start?device_name=test", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("start = %d %s", recorder.Code, recorder.Body)
+	}
+
+	var response struct {
+		AuthorizationURL string `json:"authorization_url"`
+		State            string `json:"state"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response.AuthorizationURL, response.State
+}
+
+func finishOIDCTestLogin(router *gin.Engine, code string, state string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"code": code, "state": state})
+	request := httptest.NewRequest(http.MethodPost, "/api/users/oidc/mock/callback", strings.NewReader(string(body)))
+	request.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestOIDCLoginLinksVerifiedEmail(t *testing.T) {
+	router, db := oidcTestRouter(t)
+	issuer := newMockIssuer(t)
+	useOIDCProviders(t, issuer.provider())
+	userID := createOIDCTestUser(t, db, strings.ToUpper(issuer.email))
+
+	authorizationURL, state := startOIDCTestLogin(t, router)
+	code := issuer.authorize(t, authorizationURL)
+
+	recorder := finishOIDCTestLogin(router, code, state)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("callback = %d %s, want 202", recorder.Code, recorder.Body)
+	}
+
+	var success AuthResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &success); err != nil {
+		t.Fatal(err)
+	}
+	if success.UserID != userID {
+		t.Errorf("logged in as %s, want %s", success.UserID, userID)
+	}
+
+	var linkedUserID uuid.UUID
+	var verified bool
+	err := db.QueryRow(context.Background(), `
+		SELECT identity.user_id, profile.verified_email
+		FROM user_identities identity
+		JOIN user_profiles profile ON profile.user_id = identity.user_id
+		WHERE identity.provider = 'mock' AND identity.subject = $1;
+	`, issuer.subject).Scan(&linkedUserID, &verified)
+	if err != nil {
+		t.Fatalf("identity was not linked: %v", err)
+	}
+	if linkedUserID != userID || !verified {
+		t.Errorf("linked to %s with verified_email %v, want %s and true", linkedUserID, verified, userID)
+	}
+
+	// Once linked, the identity signs in even after the provider's email changes
+	issuer.email = "renamed-" + issuer.email
+	authorizationURL, state = startOIDCTestLogin(t, router)
+	recorder = finishOIDCTestLogin(router, issuer.authorize(t, authorizationURL), state)
+	if recorder.Code != http.StatusAccepted {
+		t.Errorf("second login = %d %s, want 202", recorder.Code, recorder.Body)
+	}
+}
+
+func TestOIDCLoginRefusesUnverifiedEmail(t *testing.T) {
+	router, db := oidcTestRouter(t)
+	issuer := newMockIssuer(t)
+	issuer.emailVerified = false
+	useOIDCProviders(t, issuer.provider())
+	createOIDCTestUser(t, db, issuer.email)
+
+	authorizationURL, state := startOIDCTestLogin(t, router)
+	recorder := finishOIDCTestLogin(router, issuer.authorize(t, authorizationURL), state)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("callback = %d %s, want 403", recorder.Code, recorder.Body)
+	}
+}
+
+func TestOIDCLoginChecksPKCEVerifier(t *testing.T) {
+	router, db := oidcTestRouter(t)
+	issuer := newMockIssuer(t)
+	useOIDCProviders(t, issuer.provider())
+	createOIDCTestUser(t, db, issuer.email)
+
+	authorizationURL, state := startOIDCTestLogin(t, router)
+	code := issuer.authorize(t, authorizationURL)
+
+	// The stored verifier must be the one behind the challenge in the URL
+	var verifier string
+	err := db.QueryRow(context.Background(), `SELECT code_verifier FROM oidc_login_states WHERE state = $1;`, state).Scan(&verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _ := url.Parse(authorizationURL)
+	sum := sha256.Sum256([]byte(verifier))
+	if challenge := parsed.Query().Get("code_challenge"); challenge != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Fatalf("code_challenge %s is not S256 of the stored verifier", challenge)
+	}
+
+	// Someone replaying the code without the verifier gets nowhere
+	_, err = db.Exec(context.Background(), `UPDATE oidc_login_states SET code_verifier = $2 WHERE state = $1;`, state, verifier+"x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := finishOIDCTestLogin(router, code, state)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("callback = %d %s, want 401", recorder.Code, recorder.Body)
+	}
+}
+
+func TestOIDCLoginRejectsNonceMismatch(t *testing.T) {
+	router, db := oidcTestRouter(t)
+	issuer := newMockIssuer(t)
+	issuer.nonceOverride = "a-nonce-from-another-login"
+	useOIDCProviders(t, issuer.provider())
+	createOIDCTestUser(t, db, issuer.email)
+
+	authorizationURL, state := startOIDCTestLogin(t, router)
+	recorder := finishOIDCTestLogin(router, issuer.authorize(t, authorizationURL), state)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("callback = %d %s, want 401", recorder.Code, recorder.Body)
+	}
+}
+
+func TestOIDCLoginStateIsSingleUse(t *testing.T) {
+	router, db := oidcTestRouter(t)
+	issuer := newMockIssuer(t)
+	useOIDCProviders(t, issuer.provider())
+	createOIDCTestUser(t, db, issuer.email)
+
+	authorizationURL, state := startOIDCTestLogin(t, router)
+	recorder := finishOIDCTestLogin(router, issuer.authorize(t, authorizationURL), state)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("first callback = %d %s, want 202", recorder.Code, recorder.Body)
+	}
+
+	recorder = finishOIDCTestLogin(router, issuer.authorize(t, authorizationURL), state)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("reused state = %d %s, want 400", recorder.Code, recorder.Body)
+	}
+}
+
+func TestOIDCLoginStateExpires(t *testing.T) {
+	router, db := oidcTestRouter(t)
+	issuer := newMockIssuer(t)
+	useOIDCProviders(t, issuer.provider())
+	createOIDCTestUser(t, db, issuer.email)
+
+	authorizationURL, state := startOIDCTestLogin(t, router)
+	code := issuer.authorize(t, authorizationURL)
+
+	// A fresh state must not read as expired whatever the session time zone
+	var secondsLeft float64
+	err := db.QueryRow(context.Background(), `
+		SELECT EXTRACT(EPOCH FROM expires_at - NOW()) FROM oidc_login_states WHERE state = $1;
+	`, state).Scan(&secondsLeft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secondsLeft <= 0 || secondsLeft > oidcStateTTL.Seconds() {
+		t.Fatalf("new state expires in %.0fs, want within %s", secondsLeft, oidcStateTTL)
+	}
+
+	_, err = db.Exec(context.Background(), `UPDATE oidc_login_states SET expires_at = NOW() - INTERVAL '1 second' WHERE state = $1;`, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := finishOIDCTestLogin(router, code, state)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expired state = %d %s, want 400", recorder.Code, recorder.Body)
+	}
+}
@@ -0,0 +1,302 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"server/api/notify"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	otpTTL            = 10 * time.Minute
+	otpMaxAttempts    = 5
+	otpResendInterval = time.Minute
+)
+
+var (
+	errOTPTooSoon   = errors.New("a code was sent too recently")
+	errOTPInvalid   = errors.New("invalid or expired code")
+	errOTPExhausted = errors.New("too many attempts")
+)
+
+// newOTP returns a uniformly random 6-digit code.
+func newOTP() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// createOTP stores a hashed code for the user and purpose, replacing any that
+// is still pending, and returns the plain code to deliver. Only one code per
+// purpose can be sent each otpResendInterval.
+func createOTP(ctx context.Context, db *pgxpool.Pool, userID uuid.UUID, purpose string, destination string) (string, error) {
+	code, err := newOTP()
+	if err != nil {
+		return "", err
+	}
+
+	codeHash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var lastSent time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT created_at FROM one_time_codes
+		WHERE user_id = $1 AND purpose = $2
+		ORDER BY created_at DESC
+		LIMIT 1
+		FOR UPDATE;
+	`, userID, purpose).Scan(&lastSent)
+
+	if err != nil && err != pgx.ErrNoRows {
+		return "", err
+	}
+	if err == nil && time.Since(lastSent) < otpResendInterval {
+		return "", errOTPTooSoon
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE one_time_codes SET consumed_at = NOW()
+		WHERE user_id = $1 AND purpose = $2 AND consumed_at IS NULL;
+	`, userID, purpose)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO one_time_codes (user_id, purpose, destination, code_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5);
+	`, userID, purpose, destination, string(codeHash), time.Now().Add(otpTTL))
+	if err != nil {
+		return "", err
+	}
+
+	return code, tx.Commit(ctx)
+}
+
+// consumeOTP checks a code against the user's pending code for the purpose and
+// returns the destination it was sent to. Every check counts as an attempt; the
+// code is burned after otpMaxAttempts wrong guesses or one right one.
+func consumeOTP(ctx context.Context, db *pgxpool.Pool, userID uuid.UUID, purpose string, code string) (string, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var codeID uuid.UUID
+	var destination, codeHash string
+	var attempts int
+
+	err = tx.QueryRow(ctx, `
+		SELECT code_id, destination, code_hash, attempts
+		FROM one_time_codes
+		WHERE user_id = $1 AND purpose = $2 AND consumed_at IS NULL AND expires_at > NOW()
+		ORDER BY created_at DESC
+		LIMIT 1
+		FOR UPDATE;
+	`, userID, purpose).Scan(&codeID, &destination, &codeHash, &attempts)
+
+	if err == pgx.ErrNoRows {
+		return "", errOTPInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if attempts >= otpMaxAttempts {
+		return "", errOTPExhausted
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(codeHash), []byte(code)) != nil {
+		attempts++
+		query := `UPDATE one_time_codes SET attempts = $2 WHERE code_id = $1;`
+		if attempts >= otpMaxAttempts {
+			query = `UPDATE one_time_codes SET attempts = $2, consumed_at = NOW() WHERE code_id = $1;`
+		}
+		if _, err = tx.Exec(ctx, query, codeID, attempts); err != nil {
+			return "", err
+		}
+		if err = tx.Commit(ctx); err != nil {
+			return "", err
+		}
+		if attempts >= otpMaxAttempts {
+			return "", errOTPExhausted
+		}
+		return "", errOTPInvalid
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE one_time_codes SET consumed_at = NOW() WHERE code_id = $1;`, codeID)
+	if err != nil {
+		return "", err
+	}
+
+	return destination, tx.Commit(ctx)
+}
+
+/*
+====================
+RequestPhoneVerification
+
+Purpose: Text a 6-digit code to the authenticated user's phone number.
+Codes expire after 10 minutes and a new one can be requested once a minute.
+
+Endpoint: POST /api/users/phone/verify
+Authorization: Bearer token required
+
+Response:
+	- Success: 202 Accepted
+	- Conflict: 409 (phone number already verified)
+	- Too Many Requests: 429
+	- Server Error: 500
+*/
+func RequestPhoneVerification(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	sms := c.MustGet("sms").(notify.SMSSender)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var phoneNumber string
+	var verified bool
+	err = db.QueryRow(ctx, `
+		SELECT u.phone_number, profile.verified_phone_number
+		FROM users u
+		JOIN user_profiles profile ON u.user_id = profile.user_id
+		WHERE u.user_id = $1;
+	`, userID).Scan(&phoneNumber, &verified)
+
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if verified {
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "Phone number already verified"})
+		return
+	}
+
+	code, err := createOTP(ctx, db, userID, "verify_phone", phoneNumber)
+	if err == errOTPTooSoon {
+		c.Header("Retry-After", fmt.Sprint(int(otpResendInterval.Seconds())))
+		c.IndentedJSON(http.StatusTooManyRequests, gin.H{"error": "Please wait before requesting another code"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error creating one-time code: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	err = sms.SendSMS(ctx, phoneNumber, "Your LinkUp verification code is "+code+". It expires in 10 minutes.")
+	if err != nil {
+		fmt.Printf("Error sending verification SMS: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusAccepted, gin.H{"message": "Verification code sent"})
+}
+
+/*
+====================
+ConfirmPhoneVerification
+
+Purpose: Confirm the code texted by RequestPhoneVerification.
+
+Endpoint: POST /api/users/phone/verify/confirm
+Authorization: Bearer token required
+
+Body (JSON):
+	{
+		"code": "123456"
+	}
+
+Response:
+	- Success: 200 OK
+	- Bad Request: 400 (wrong, expired or missing code)
+	- Gone: 410 (the phone number changed since the code was sent)
+	- Too Many Requests: 429 (code burned after 5 wrong attempts, request a new one)
+	- Server Error: 500
+*/
+func ConfirmPhoneVerification(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	phoneNumber, err := consumeOTP(ctx, db, userID, "verify_phone", request.Code)
+	switch err {
+	case nil:
+	case errOTPInvalid:
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired code"})
+		return
+	case errOTPExhausted:
+		c.IndentedJSON(http.StatusTooManyRequests, gin.H{"error": "Too many attempts, request a new code"})
+		return
+	default:
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	query := `
+		UPDATE user_profiles profile
+		SET verified_phone_number = true
+		FROM users u
+		WHERE profile.user_id = u.user_id
+		  AND u.user_id = $1
+		  AND u.phone_number = $2
+		RETURNING profile.user_id;
+	`
+
+	err = db.QueryRow(ctx, query, userID, phoneNumber).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusGone, gin.H{"error": "This code was sent to a phone number no longer on the account"})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "Phone number verified"})
+}
@@ -0,0 +1,315 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"server/api/notify"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordResetTTL            = 30 * time.Minute
+	passwordResetResendInterval = 2 * time.Minute
+)
+
+// validatePassword enforces the length limits; bcrypt ignores anything past 72 bytes.
+func validatePassword(password string) error {
+	if len(password) < 8 {
+		return errors.New("password must be at least 8 characters")
+	}
+	if len(password) > 72 {
+		return errors.New("password must be at most 72 bytes")
+	}
+	return nil
+}
+
+// setPassword stores a new password hash and logs the user out everywhere.
+func setPassword(ctx context.Context, db *pgxpool.Pool, userID uuid.UUID, password string) error {
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, `UPDATE users SET password_hash = $2 WHERE user_id = $1;`, userID, passwordHash)
+	if err != nil {
+		return err
+	}
+
+	_, err = RevokeUserSessions(ctx, db, userID)
+	return err
+}
+
+/*
+====================
+ChangePassword
+
+Purpose: Change the authenticated user's password. Every session, including the
+current one, is revoked; the response carries fresh tokens for this device.
+
+Endpoint: PUT /api/users/password
+Authorization: Bearer token required
+
+Body (JSON):
+	{
+		"old_password": "SecurePass123",
+		"new_password": "EvenMoreSecure456",
+		"device_name": "Jeff's iPhone"  // optional
+	}
+
+Response:
+	- Success: 200 OK (same body as login)
+	- Bad Request: 400 (missing fields or new password too short/long)
+	- Unauthorized: 401 (old password is wrong)
+	- Server Error: 500
+*/
+func ChangePassword(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		OldPassword string `json:"old_password" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required"`
+		DeviceName  string `json:"device_name"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	if err := validatePassword(request.NewPassword); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user := UserInfo{UserID: userID, DeviceName: request.DeviceName}
+	var passwordHash string
+
+	err = db.QueryRow(ctx, `SELECT username, password_hash FROM users WHERE user_id = $1;`, userID).Scan(&user.Username, &passwordHash)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(request.OldPassword)) != nil {
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "Old password is incorrect"})
+		return
+	}
+
+	if err = setPassword(ctx, db, userID, request.NewPassword); err != nil {
+		fmt.Printf("Error changing password: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	success := AuthResponse{UserID: userID, Username: user.Username}
+	if err = issueTokens(ctx, db, c, user, &success); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, success)
+}
+
+/*
+====================
+ForgotPassword
+
+Purpose: Send a single-use password reset link. The link goes by email, or by
+SMS when the account was looked up by phone number. It expires in 30 minutes.
+
+Endpoint: POST /api/users/password/forgot
+Authorization: None
+
+Body (JSON, one identifier):
+	{
+		"email": "testuser1@example.com"
+	}
+
+Response:
+	- Success: 202 Accepted, whether or not the account exists
+	- Bad Request: 400 (no identifier)
+*/
+func ForgotPassword(c *gin.Context) {
+	var request struct {
+		Username    string `json:"username"`
+		Email       string `json:"email"`
+		PhoneNumber string `json:"phone_number"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	// Reuse the login normalization; it insists on a password, which a reset doesn't have
+	lookup := UserInfo{Username: request.Username, Email: request.Email, PhoneNumber: request.PhoneNumber, Password: "placeholder"}
+	if _, err := ValidateUserInput(&lookup); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	mailer := c.MustGet("mailer").(notify.Mailer)
+	sms := c.MustGet("sms").(notify.SMSSender)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Always answer the same way so this can't be used to find accounts
+	accepted := gin.H{"message": "If that account exists, a reset link is on its way"}
+
+	query := `SELECT user_id, email, phone_number FROM users WHERE LOWER(username) = LOWER($1);`
+	identifier := strings.TrimSpace(lookup.Username)
+	if lookup.Email != "" {
+		query = `SELECT user_id, email, phone_number FROM users WHERE LOWER(email) = LOWER($1);`
+		identifier = lookup.Email
+	} else if lookup.PhoneNumber != "" {
+		query = `SELECT user_id, email, phone_number FROM users WHERE phone_number = $1;`
+		identifier = lookup.PhoneNumber
+	}
+
+	var userID uuid.UUID
+	var email, phoneNumber string
+	err := db.QueryRow(ctx, query, identifier).Scan(&userID, &email, &phoneNumber)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			fmt.Printf("Error looking up account for reset: %v\n", err)
+		}
+		c.IndentedJSON(http.StatusAccepted, accepted)
+		return
+	}
+
+	token, tokenHash, err := newOpaqueToken()
+	if err != nil {
+		c.IndentedJSON(http.StatusAccepted, accepted)
+		return
+	}
+
+	insert := `
+		INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+		SELECT $1, $2, $3
+		WHERE NOT EXISTS (
+			SELECT 1 FROM password_reset_tokens
+			WHERE user_id = $1 AND created_at > NOW() - make_interval(secs => $4)
+		)
+		RETURNING user_id;
+	`
+
+	err = db.QueryRow(ctx, insert, userID, tokenHash, time.Now().Add(passwordResetTTL), passwordResetResendInterval.Seconds()).Scan(&userID)
+	if err != nil {
+		// pgx.ErrNoRows means one was sent moments ago
+		c.IndentedJSON(http.StatusAccepted, accepted)
+		return
+	}
+
+	link := appBaseURL() + "/reset-password?token=" + token
+	if lookup.PhoneNumber != "" {
+		err = sms.SendSMS(ctx, phoneNumber, "Reset your LinkUp password: "+link+" (expires in 30 minutes)")
+	} else {
+		body := "Someone asked to reset the password on your LinkUp account.\n\n" +
+			"Open the link below to choose a new one. It expires in 30 minutes and works once.\n\n" +
+			link + "\n\n" +
+			"If this wasn't you, you can ignore this email."
+		err = mailer.SendMail(ctx, email, "Reset your LinkUp password", body)
+	}
+	if err != nil {
+		fmt.Printf("Error sending password reset: %v\n", err)
+	}
+
+	c.IndentedJSON(http.StatusAccepted, accepted)
+}
+
+/*
+====================
+ResetPassword
+
+Purpose: Set a new password with a token from ForgotPassword. The token works
+once, and every session on the account is revoked.
+
+Endpoint: POST /api/users/password/reset
+Authorization: None (the reset token is the credential)
+
+Body (JSON):
+	{
+		"token": "token-from-the-link",
+		"new_password": "EvenMoreSecure456"
+	}
+
+Response:
+	- Success: 200 OK
+	- Bad Request: 400 (invalid, used or expired token, or bad password)
+	- Server Error: 500
+*/
+func ResetPassword(c *gin.Context) {
+	var request struct {
+		Token       string `json:"token" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	if err := validatePassword(request.NewPassword); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE password_reset_tokens
+		SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id;
+	`
+
+	var userID uuid.UUID
+	err := db.QueryRow(ctx, query, hashOpaqueToken(request.Token)).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	// Any other links still in flight are dead now too
+	_, err = db.Exec(ctx, `
+		UPDATE password_reset_tokens SET used_at = NOW()
+		WHERE user_id = $1 AND used_at IS NULL;
+	`, userID)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if err = setPassword(ctx, db, userID, request.NewPassword); err != nil {
+		fmt.Printf("Error resetting password: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "Password updated, please log in again"})
+}
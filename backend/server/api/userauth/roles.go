@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+Every account has one role, stored in users.role and copied into the access
+token:
+
+	- user: everyone
+	- moderator: works the report queue and can suspend, log out and edit
+	  functions for any user
+	- campus_admin: the same for users whose school is their university
+	  (users.role_university_id), and manages that university's buildings
+	- superadmin: everything, including roles and universities
+
+A role change logs the user out, so tokens never carry a stale role for long.
+*/
+
+const (
+	RoleUser        = "user"
+	RoleModerator   = "moderator"
+	RoleCampusAdmin = "campus_admin"
+	RoleSuperadmin  = "superadmin"
+)
+
+// ValidRole reports whether role is one of the roles above.
+func ValidRole(role string) bool {
+	switch role {
+	case RoleUser, RoleModerator, RoleCampusAdmin, RoleSuperadmin:
+		return true
+	}
+	return false
+}
+
+// loadRole fills in the role a user's next access token carries.
+func loadRole(ctx context.Context, db *pgxpool.Pool, user *UserInfo) error {
+	return db.QueryRow(ctx, `
+		SELECT role::TEXT, role_university_id FROM users WHERE user_id = $1;
+	`, user.UserID).Scan(&user.Role, &user.RoleUniversityID)
+}
+
+// AdminMiddleware only lets through accounts with one of the given roles, or
+// superadmins. Must run after AuthMiddleware.
+func AdminMiddleware(roles ...string) gin.HandlerFunc {
+	allowed := map[string]bool{RoleSuperadmin: true}
+	for _, role := range roles {
+		allowed[role] = true
+	}
+
+	return func(c *gin.Context) {
+		if !allowed[c.GetString("role")] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// CampusScope is the university a campus admin is limited to, or nil when the
+// caller may act on every user. Admin queries filter with
+// ($n::UUID IS NULL OR profile.school_id = $n).
+func CampusScope(c *gin.Context) *uuid.UUID {
+	if c.GetString("role") != RoleCampusAdmin {
+		return nil
+	}
+	universityID, _ := c.Get("role_university_id")
+	scope, _ := universityID.(uuid.UUID)
+	return &scope
+}
+
+// PromoteBootstrapAdmins makes the accounts listed (comma separated) in
+// ADMIN_USER_IDS superadmins, so a fresh deployment has someone who can hand
+// out the other roles.
+func PromoteBootstrapAdmins(ctx context.Context, db *pgxpool.Pool) error {
+	var userIDs []uuid.UUID
+	for _, id := range strings.Split(os.Getenv("ADMIN_USER_IDS"), ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		userID, err := uuid.Parse(id)
+		if err != nil {
+			return fmt.Errorf("ADMIN_USER_IDS: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	_, err := db.Exec(ctx, `
+		UPDATE users SET role = 'superadmin', role_university_id = NULL
+		WHERE user_id = ANY($1) AND role <> 'superadmin';
+	`, userIDs)
+	return err
+}
@@ -0,0 +1,379 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// How long AuthMiddleware trusts its cached view of a session before going
+// back to the database. Revocations made by this process take effect
+// immediately; revocations made elsewhere take at most this long.
+const sessionCacheTTL = 30 * time.Second
+
+type Session struct {
+	SessionID  uuid.UUID `json:"session_id"`
+	DeviceName string    `json:"device_name"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+type sessionCacheEntry struct {
+	userID    uuid.UUID
+	revoked   bool
+	checkedAt time.Time
+}
+
+type sessionCache struct {
+	mu      sync.Mutex
+	entries map[uuid.UUID]sessionCacheEntry
+}
+
+var sessions = &sessionCache{entries: make(map[uuid.UUID]sessionCacheEntry)}
+
+// isActive reports whether the session exists, belongs to userID and has not
+// been revoked. A cache miss also bumps the session's last_seen_at, so active
+// sessions are touched at most once per sessionCacheTTL.
+func (cache *sessionCache) isActive(ctx context.Context, db *pgxpool.Pool, sessionID uuid.UUID, userID uuid.UUID) bool {
+	cache.mu.Lock()
+	entry, found := cache.entries[sessionID]
+	cache.mu.Unlock()
+
+	if found && time.Since(entry.checkedAt) < sessionCacheTTL {
+		return !entry.revoked && entry.userID == userID
+	}
+
+	query := `
+		UPDATE sessions
+		SET last_seen_at = CASE WHEN revoked_at IS NULL THEN NOW() ELSE last_seen_at END
+		WHERE session_id = $1
+		RETURNING user_id, revoked_at IS NOT NULL;
+	`
+
+	entry = sessionCacheEntry{checkedAt: time.Now()}
+	err := db.QueryRow(ctx, query, sessionID).Scan(&entry.userID, &entry.revoked)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			fmt.Printf("Error checking session: %v\n", err)
+			return false
+		}
+		entry.revoked = true
+	}
+
+	cache.mu.Lock()
+	cache.entries[sessionID] = entry
+	cache.pruneLocked()
+	cache.mu.Unlock()
+
+	return !entry.revoked && entry.userID == userID
+}
+
+func (cache *sessionCache) markRevoked(sessionIDs ...uuid.UUID) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for _, sessionID := range sessionIDs {
+		entry := cache.entries[sessionID]
+		entry.revoked = true
+		entry.checkedAt = time.Now()
+		cache.entries[sessionID] = entry
+	}
+}
+
+// pruneLocked drops stale entries so the cache doesn't grow without bound.
+func (cache *sessionCache) pruneLocked() {
+	if len(cache.entries) < 10000 {
+		return
+	}
+	for sessionID, entry := range cache.entries {
+		if time.Since(entry.checkedAt) >= sessionCacheTTL {
+			delete(cache.entries, sessionID)
+		}
+	}
+}
+
+func createSession(ctx context.Context, db *pgxpool.Pool, userID uuid.UUID, deviceName string, ipAddress string) (uuid.UUID, error) {
+	if len(deviceName) > 100 {
+		deviceName = deviceName[:100]
+	}
+
+	query := `
+		INSERT INTO sessions (user_id, device_name, ip_address)
+		VALUES ($1, $2, $3)
+		RETURNING session_id;
+	`
+
+	var sessionID uuid.UUID
+	err := db.QueryRow(ctx, query, userID, deviceName, ipAddress).Scan(&sessionID)
+	return sessionID, err
+}
+
+// revokeSessionTx marks a session revoked and revokes its outstanding refresh tokens.
+func revokeSessionTx(ctx context.Context, tx pgx.Tx, sessionID uuid.UUID) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE session_id = $1 AND revoked_at IS NULL;
+	`, sessionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE session_id = $1 AND revoked_at IS NULL;
+	`, sessionID)
+	return err
+}
+
+// RevokeUserSessions revokes every live session of a user and returns their ids.
+func RevokeUserSessions(ctx context.Context, db *pgxpool.Pool, userID uuid.UUID) ([]uuid.UUID, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+		RETURNING session_id;
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL;
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	sessions.markRevoked(revoked...)
+	return revoked, nil
+}
+
+/*
+====================
+ListSessions
+
+Purpose: List the authenticated user's live sessions (one per logged in device).
+
+Endpoint: GET /api/users/sessions
+Authorization: Bearer token required
+
+Response:
+	- Success: 200 OK
+		{
+			"sessions": [
+				{
+					"session_id": "uuid",
+					"device_name": "Jeff's iPhone",
+					"ip_address": "141.211.0.1",
+					"created_at": "2024-11-02T15:00:00Z",
+					"last_seen_at": "2024-11-02T16:00:00Z",
+					"current": true
+				}
+			]
+		}
+	- Server Error: 500
+*/
+func ListSessions(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+	currentSessionID := c.GetString("session_id")
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT session_id, device_name, ip_address, created_at, last_seen_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY last_seen_at DESC;
+	`
+
+	rows, err := db.Query(ctx, query, userID)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer rows.Close()
+
+	userSessions := []Session{}
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(&session.SessionID, &session.DeviceName, &session.IPAddress, &session.CreatedAt, &session.LastSeenAt); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		session.Current = session.SessionID.String() == currentSessionID
+		userSessions = append(userSessions, session)
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"sessions": userSessions})
+}
+
+/*
+====================
+RevokeSession
+
+Purpose: Log a single device out. Its access token stops working immediately and
+its refresh token can no longer be used.
+
+Endpoint: DELETE /api/users/sessions/:id
+Authorization: Bearer token required
+
+Response:
+	- Success: 200 OK
+	- Bad Request: 400 (invalid session id)
+	- Not Found: 404 (no live session with that id belongs to the user)
+	- Server Error: 500
+*/
+func RevokeSession(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	revokeOwnSession(c, userID, sessionID)
+}
+
+/*
+====================
+Logout
+
+Purpose: Revoke the session the request was made with.
+
+Endpoint: POST /api/users/logout
+Authorization: Bearer token required
+
+Response:
+	- Success: 200 OK
+	- Server Error: 500
+*/
+func Logout(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.GetString("session_id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	revokeOwnSession(c, userID, sessionID)
+}
+
+func revokeOwnSession(c *gin.Context, userID uuid.UUID, sessionID uuid.UUID) {
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var owner uuid.UUID
+	err = tx.QueryRow(ctx, `
+		SELECT user_id FROM sessions
+		WHERE session_id = $1 AND revoked_at IS NULL
+		FOR UPDATE;
+	`, sessionID).Scan(&owner)
+
+	if err == pgx.ErrNoRows || (err == nil && owner != userID) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if err = revokeSessionTx(ctx, tx, sessionID); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	sessions.markRevoked(sessionID)
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+/*
+====================
+RevokeAllSessions
+
+Purpose: Log out everywhere, including the device making the request.
+
+Endpoint: DELETE /api/users/sessions
+Authorization: Bearer token required
+
+Response:
+	- Success: 200 OK
+		{
+			"revoked": 3
+		}
+	- Server Error: 500
+*/
+func RevokeAllSessions(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	revoked, err := RevokeUserSessions(ctx, db, userID)
+	if err != nil {
+		fmt.Printf("Error revoking sessions: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"revoked": len(revoked)})
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+A suspended account can't log in or refresh its tokens until the suspension
+runs out (suspended_until) or is lifted. A suspension with no end lasts until
+lifted. While suspended, user_profiles.active is false.
+*/
+
+var errAccountSuspended = errors.New("account suspended")
+
+// accountSuspended reports whether a user is suspended right now.
+func accountSuspended(ctx context.Context, db *pgxpool.Pool, userID uuid.UUID) (bool, error) {
+	var suspended bool
+	err := db.QueryRow(ctx, `
+		SELECT suspended_at IS NOT NULL AND (suspended_until IS NULL OR suspended_until > NOW())
+		FROM users WHERE user_id = $1;
+	`, userID).Scan(&suspended)
+	return suspended, err
+}
+
+// endExpiredSuspension clears a suspension that has run out and puts the user
+// back on the map. Called whenever a login succeeds.
+func endExpiredSuspension(ctx context.Context, db *pgxpool.Pool, userID uuid.UUID) error {
+	var restored uuid.UUID
+	err := db.QueryRow(ctx, `
+		UPDATE users SET suspended_at = NULL, suspended_until = NULL
+		WHERE user_id = $1 AND suspended_until <= NOW()
+		RETURNING user_id;
+	`, userID).Scan(&restored)
+
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, `UPDATE user_profiles SET active = visibility <> 'hidden' WHERE user_id = $1;`, userID)
+	return err
+}
+
+// SuspendAccount suspends a user until the given time, or until lifted when
+// until is nil, and reports whether the user exists. It only writes in tx; once
+// tx commits the caller logs the user out with RevokeUserSessions.
+func SuspendAccount(ctx context.Context, tx pgx.Tx, userID uuid.UUID, until *time.Time) (bool, error) {
+	result, err := tx.Exec(ctx, `
+		UPDATE users SET suspended_at = NOW(), suspended_until = $2
+		WHERE user_id = $1;
+	`, userID, until)
+	if err != nil || result.RowsAffected() == 0 {
+		return false, err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE user_profiles SET active = false WHERE user_id = $1;`, userID)
+	return err == nil, err
+}
+
+// LiftSuspension ends a user's suspension early and reports whether they were
+// suspended. Accounts waiting to be deleted or in ghost mode stay inactive.
+func LiftSuspension(ctx context.Context, tx pgx.Tx, userID uuid.UUID) (bool, error) {
+	var deleted bool
+	err := tx.QueryRow(ctx, `
+		UPDATE users SET suspended_at = NULL, suspended_until = NULL
+		WHERE user_id = $1 AND suspended_at IS NOT NULL
+		RETURNING deleted_at IS NOT NULL;
+	`, userID).Scan(&deleted)
+
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE user_profiles SET active = NOT $2 AND visibility <> 'hidden' WHERE user_id = $1;
+	`, userID, deleted)
+	return err == nil, err
+}
@@ -0,0 +1,306 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// throttlePolicy decides how long a key is blocked after its nth failure in a
+// row. The first freeAttempts failures cost nothing, then each one doubles the
+// wait up to maxBackoff, and lockoutAfter failures lock the key for lockout.
+// Failures older than window are forgotten.
+type throttlePolicy struct {
+	freeAttempts int
+	maxBackoff   time.Duration
+	lockoutAfter int
+	lockout      time.Duration
+	window       time.Duration
+}
+
+var (
+	// Per account (or per unknown identifier, so the two look alike)
+	accountThrottle = throttlePolicy{
+		freeAttempts: 3,
+		maxBackoff:   5 * time.Minute,
+		lockoutAfter: 10,
+		lockout:      30 * time.Minute,
+		window:       time.Hour,
+	}
+
+	// Per client IP, looser since campus networks share addresses
+	ipThrottle = throttlePolicy{
+		freeAttempts: 20,
+		maxBackoff:   5 * time.Minute,
+		lockoutAfter: 100,
+		lockout:      30 * time.Minute,
+		window:       time.Hour,
+	}
+)
+
+func (policy throttlePolicy) delayAfter(failures int) time.Duration {
+	if failures >= policy.lockoutAfter {
+		return policy.lockout
+	}
+	if failures <= policy.freeAttempts {
+		return 0
+	}
+
+	delay := time.Duration(math.Pow(2, float64(failures-policy.freeAttempts))) * time.Second
+	if delay > policy.maxBackoff {
+		delay = policy.maxBackoff
+	}
+	return delay
+}
+
+// Throttle keys. Existing accounts are keyed by user id so every way of
+// logging in shares one counter; identifiers that match no account get the
+// same treatment under their own key.
+func accountThrottleKey(userID uuid.UUID) string {
+	return "user:" + userID.String()
+}
+
+func identifierThrottleKey(identifier string) string {
+	return "identifier:" + strings.ToLower(strings.TrimSpace(identifier))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// throttleWait returns how long the caller must wait before any of the keys
+// may try again, or zero.
+func throttleWait(ctx context.Context, db *pgxpool.Pool, keys ...string) (time.Duration, error) {
+	var lockedUntil *time.Time
+	err := db.QueryRow(ctx, `
+		SELECT MAX(locked_until) FROM login_throttle
+		WHERE throttle_key = ANY($1) AND locked_until > NOW();
+	`, keys).Scan(&lockedUntil)
+
+	if err != nil || lockedUntil == nil {
+		return 0, err
+	}
+	return time.Until(*lockedUntil), nil
+}
+
+// recordLoginFailure counts a failure against a key and blocks it per policy.
+// Reaching the lockout threshold is written to the audit log.
+func recordLoginFailure(ctx context.Context, db *pgxpool.Pool, key string, policy throttlePolicy, userID *uuid.UUID, ip string) error {
+	query := `
+		INSERT INTO login_throttle (throttle_key, failures, last_failure_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (throttle_key) DO UPDATE
+		SET failures = CASE
+		        WHEN login_throttle.last_failure_at < NOW() - make_interval(secs => $2) THEN 1
+		        ELSE login_throttle.failures + 1
+		    END,
+		    last_failure_at = NOW()
+		RETURNING failures;
+	`
+
+	var failures int
+	if err := db.QueryRow(ctx, query, key, policy.window.Seconds()).Scan(&failures); err != nil {
+		return err
+	}
+
+	delay := policy.delayAfter(failures)
+	if delay == 0 {
+		return nil
+	}
+
+	_, err := db.Exec(ctx, `
+		UPDATE login_throttle SET locked_until = NOW() + make_interval(secs => $2)
+		WHERE throttle_key = $1;
+	`, key, delay.Seconds())
+	if err != nil {
+		return err
+	}
+
+	if failures >= policy.lockoutAfter {
+		return writeAuthAudit(ctx, db, "lockout", key, userID, ip, fmt.Sprintf("%d failed logins, locked for %s", failures, policy.lockout))
+	}
+	return nil
+}
+
+// clearLoginFailures resets a key after a successful login, noting in the
+// audit log when that ends a lockout.
+func clearLoginFailures(ctx context.Context, db *pgxpool.Pool, key string, policy throttlePolicy, userID *uuid.UUID, ip string) error {
+	var failures int
+	err := db.QueryRow(ctx, `DELETE FROM login_throttle WHERE throttle_key = $1 RETURNING failures;`, key).Scan(&failures)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if failures >= policy.lockoutAfter {
+		return writeAuthAudit(ctx, db, "unlock", key, userID, ip, "lockout expired, successful login")
+	}
+	return nil
+}
+
+func writeAuthAudit(ctx context.Context, db *pgxpool.Pool, eventType string, key string, userID *uuid.UUID, ip string, details string) error {
+	_, err := db.Exec(ctx, `
+		INSERT INTO auth_audit_log (event_type, throttle_key, user_id, ip_address, details)
+		VALUES ($1, $2, $3, $4, $5);
+	`, eventType, key, userID, ip, details)
+	return err
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// burnPasswordCheck spends as long as a real bcrypt comparison so a login for
+// an unknown account takes as long as a wrong password.
+func burnPasswordCheck(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), 12)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
+type AuthAuditEvent struct {
+	EventID     int64      `json:"event_id"`
+	EventType   string     `json:"event_type"` // "lockout" or "unlock"
+	ThrottleKey string     `json:"throttle_key"`
+	UserID      *uuid.UUID `json:"user_id"`
+	IPAddress   string     `json:"ip_address"`
+	Details     string     `json:"details"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+/*
+====================
+ListAuthAudit
+
+Purpose: Admin view of account lockouts and unlocks, newest first.
+
+Endpoint: GET /api/admin/auth-audit
+Authorization: Bearer token required (superadmin)
+
+Query Params:
+	- user_id: only events for this account (optional)
+	- event_type: "lockout" or "unlock" (optional)
+	- limit: max events, default 100, max 500 (optional)
+
+Response:
+	- Success: 200 OK
+		{
+			"events": [
+				{
+					"event_id": 12,
+					"event_type": "lockout",
+					"throttle_key": "user:uuid",
+					"user_id": "uuid",
+					"ip_address": "141.211.0.1",
+					"details": "10 failed logins, locked for 30m0s",
+					"created_at": "2024-11-02T15:00:00Z"
+				}
+			]
+		}
+	- Bad Request: 400 (invalid user_id)
+	- Server Error: 500
+*/
+func ListAuthAudit(c *gin.Context) {
+	var userID *uuid.UUID
+	if userIDString := c.Query("user_id"); userIDString != "" {
+		parsed, err := uuid.Parse(userIDString)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		userID = &parsed
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT event_id, event_type, throttle_key, user_id, ip_address, details, created_at
+		FROM auth_audit_log
+		WHERE ($1::UUID IS NULL OR user_id = $1)
+		  AND ($2 = '' OR event_type = $2)
+		ORDER BY created_at DESC
+		LIMIT $3;
+	`
+
+	rows, err := db.Query(ctx, query, userID, c.Query("event_type"), limit)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer rows.Close()
+
+	events := []AuthAuditEvent{}
+	for rows.Next() {
+		var event AuthAuditEvent
+		if err := rows.Scan(&event.EventID, &event.EventType, &event.ThrottleKey, &event.UserID, &event.IPAddress, &event.Details, &event.CreatedAt); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		events = append(events, event)
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"events": events})
+}
+
+/*
+====================
+UnlockAccount
+
+Purpose: Admin override that clears an account's failed login counter and lockout.
+
+Endpoint: POST /api/admin/users/:id/unlock
+Authorization: Bearer token required (moderator or superadmin)
+
+Response:
+	- Success: 200 OK
+	- Bad Request: 400 (invalid user id)
+	- Server Error: 500
+*/
+func UnlockAccount(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	key := accountThrottleKey(userID)
+	_, err = db.Exec(ctx, `DELETE FROM login_throttle WHERE throttle_key = $1;`, key)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	err = writeAuthAudit(ctx, db, "unlock", key, &userID, c.ClientIP(), "unlocked by admin "+c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "Account unlocked"})
+}
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var errRefreshTokenReused = errors.New("refresh token reused")
+
+// newOpaqueToken returns a random opaque token (refresh and password reset
+// tokens) and the hash that gets stored. Only the hash is ever written to the
+// database.
+func newOpaqueToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashOpaqueToken(token), nil
+}
+
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// storeRefreshToken saves a new refresh token for a session. Every refresh
+// token issued for a session forms one family, so reuse of an old token can
+// take down the whole session.
+func storeRefreshToken(ctx context.Context, q pgxQuerier, userID uuid.UUID, sessionID uuid.UUID) (string, uuid.UUID, error) {
+	token, tokenHash, err := newOpaqueToken()
+	if err != nil {
+		return "", uuid.Nil, err
+	}
+
+	query := `
+		INSERT INTO refresh_tokens (user_id, session_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING token_id;
+	`
+
+	var tokenID uuid.UUID
+	err = q.QueryRow(ctx, query, userID, sessionID, tokenHash, time.Now().Add(refreshTokenTTL)).Scan(&tokenID)
+	if err != nil {
+		return "", uuid.Nil, err
+	}
+
+	return token, tokenID, nil
+}
+
+// pgxQuerier is satisfied by both *pgxpool.Pool and pgx.Tx.
+type pgxQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// issueTokens starts a new session and fills in the access and refresh tokens
+// of a successful login or signup.
+func issueTokens(ctx context.Context, db *pgxpool.Pool, c *gin.Context, user UserInfo, response *AuthResponse) error {
+	suspended, err := accountSuspended(ctx, db, user.UserID)
+	if err != nil {
+		return err
+	}
+	if suspended {
+		return errAccountSuspended
+	}
+	if err := endExpiredSuspension(ctx, db, user.UserID); err != nil {
+		return err
+	}
+
+	// Logging in during the deletion grace period keeps the account
+	if err := cancelAccountDeletion(ctx, db, user.UserID); err != nil {
+		return err
+	}
+
+	if err := loadRole(ctx, db, &user); err != nil {
+		return err
+	}
+
+	sessionID, err := createSession(ctx, db, user.UserID, user.DeviceName, c.ClientIP())
+	if err != nil {
+		return err
+	}
+
+	accessToken, err := GenerateJWT(user, sessionID)
+	if err != nil {
+		return err
+	}
+
+	refreshToken, _, err := storeRefreshToken(ctx, db, user.UserID, sessionID)
+	if err != nil {
+		return err
+	}
+
+	response.AccessToken = accessToken
+	response.RefreshToken = refreshToken
+	response.ExpiresIn = int(accessTokenTTL.Seconds())
+
+	return nil
+}
+
+// rotateRefreshToken exchanges a refresh token for a new one in the same session.
+// Presenting a token that was already rotated or revoked revokes the whole session.
+func rotateRefreshToken(ctx context.Context, db *pgxpool.Pool, presented string) (UserInfo, uuid.UUID, string, error) {
+	var user UserInfo
+	var sessionID uuid.UUID
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return user, sessionID, "", err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		SELECT rt.token_id, rt.session_id, rt.expires_at, rt.replaced_by IS NOT NULL OR rt.revoked_at IS NOT NULL,
+		       s.revoked_at IS NOT NULL OR (u.suspended_at IS NOT NULL AND (u.suspended_until IS NULL OR u.suspended_until > NOW())),
+		       u.user_id, u.username, u.role::TEXT, u.role_university_id
+		FROM refresh_tokens rt
+		JOIN sessions s ON rt.session_id = s.session_id
+		JOIN users u ON rt.user_id = u.user_id
+		WHERE rt.token_hash = $1
+		FOR UPDATE OF rt;
+	`
+
+	var tokenID uuid.UUID
+	var expiresAt time.Time
+	var spent, sessionRevoked bool
+
+	err = tx.QueryRow(ctx, query, hashOpaqueToken(presented)).Scan(&tokenID, &sessionID, &expiresAt, &spent, &sessionRevoked, &user.UserID, &user.Username, &user.Role, &user.RoleUniversityID)
+	if err != nil {
+		return user, sessionID, "", err
+	}
+
+	if sessionRevoked {
+		return user, sessionID, "", pgx.ErrNoRows
+	}
+
+	if spent {
+		// Someone is replaying a token we already handed a successor for, so
+		// assume it leaked and kill the session it belongs to.
+		if err = revokeSessionTx(ctx, tx, sessionID); err != nil {
+			return user, sessionID, "", err
+		}
+		if err = tx.Commit(ctx); err != nil {
+			return user, sessionID, "", err
+		}
+		sessions.markRevoked(sessionID)
+		return user, sessionID, "", errRefreshTokenReused
+	}
+
+	if time.Now().After(expiresAt) {
+		return user, sessionID, "", pgx.ErrNoRows
+	}
+
+	newToken, newTokenID, err := storeRefreshToken(ctx, tx, user.UserID, sessionID)
+	if err != nil {
+		return user, sessionID, "", err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE refresh_tokens SET replaced_by = $2 WHERE token_id = $1;`, tokenID, newTokenID)
+	if err != nil {
+		return user, sessionID, "", err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE sessions SET last_seen_at = NOW() WHERE session_id = $1;`, sessionID)
+	if err != nil {
+		return user, sessionID, "", err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return user, sessionID, "", err
+	}
+
+	return user, sessionID, newToken, nil
+}
+
+/*
+====================
+RefreshAccessToken
+
+Purpose: Exchange a refresh token for a new access token and a new refresh token.
+The presented refresh token is single use.
+
+Endpoint: POST /api/users/token/refresh
+Authorization: None (the refresh token is the credential)
+
+Body (JSON):
+	{
+		"refresh_token": "opaque-token"
+	}
+
+Response:
+	- Success: 200 OK (same body as login)
+	- Bad Request: 400 (missing refresh_token)
+	- Unauthorized: 401 (unknown, expired, revoked or reused token, or a suspended account)
+	- Server Error: 500
+*/
+func RefreshAccessToken(c *gin.Context) {
+	var request struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	user, sessionID, refreshToken, err := rotateRefreshToken(ctx, db, request.RefreshToken)
+	if err != nil {
+		if errors.Is(err, errRefreshTokenReused) {
+			fmt.Printf("Refresh token reuse detected, revoked session %s of user %s\n", sessionID, user.UserID)
+		}
+		if errors.Is(err, errRefreshTokenReused) || errors.Is(err, pgx.ErrNoRows) {
+			c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+
+		fmt.Printf("Error rotating refresh token: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	accessToken, err := GenerateJWT(user, sessionID)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, AuthResponse{
+		UserID:       user.UserID,
+		Username:     user.Username,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	})
+}
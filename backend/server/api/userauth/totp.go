@@ -0,0 +1,587 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// RFC 6238 parameters. These are the defaults every authenticator app assumes,
+// so they are also what goes in the provisioning URI.
+const (
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpSkew          = 1 // steps accepted either side of now, for clock drift
+	totpIssuer        = "LinkUp"
+	recoveryCodes     = 10
+	challengeTTL      = 5 * time.Minute
+	challengeAudience = "login-2fa"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// hotp is RFC 4226: HMAC-SHA1 of the counter, dynamically truncated to digits.
+func hotp(secret []byte, counter uint64, digits int) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], counter)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulus := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%modulus)
+}
+
+// verifyTOTP is RFC 6238: HOTP over the number of periods since the Unix
+// epoch. It checks a code against the steps around now and returns the
+// step it matched. Steps at or before lastStep are refused so a code can't be replayed.
+func verifyTOTP(encodedSecret string, code string, now time.Time, lastStep int64) (int64, bool) {
+	secret, err := totpEncoding.DecodeString(strings.ToUpper(encodedSecret))
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.ReplaceAll(code, " ", "")
+	current := now.Unix() / totpPeriod
+
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected := hotp(secret, uint64(step), totpDigits)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func totpProvisioningURI(username string, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + username)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// newRecoveryCodes returns codes shaped like "k3d9-x7qp" and their bcrypt hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+	codes := make([]string, recoveryCodes)
+	hashes := make([]string, recoveryCodes)
+	buf := make([]byte, 8)
+
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		for j := range buf {
+			buf[j] = alphabet[int(buf[j])%len(alphabet)]
+		}
+		codes[i] = string(buf[:4]) + "-" + string(buf[4:])
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(codes[i]), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, nil, err
+		}
+		hashes[i] = string(hash)
+	}
+
+	return codes, hashes, nil
+}
+
+// twoFactorEnabled reports whether the user has confirmed a TOTP enrollment.
+func twoFactorEnabled(ctx context.Context, db *pgxpool.Pool, userID uuid.UUID) (bool, error) {
+	var enabled bool
+	err := db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM user_totp WHERE user_id = $1 AND enabled_at IS NOT NULL);
+	`, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// checkSecondFactor accepts either a TOTP code or an unused recovery code.
+func checkSecondFactor(ctx context.Context, db *pgxpool.Pool, userID uuid.UUID, code string, recoveryCode string) (bool, error) {
+	if code != "" {
+		var secret string
+		var lastStep int64
+		err := db.QueryRow(ctx, `
+			SELECT secret, last_used_step FROM user_totp
+			WHERE user_id = $1 AND enabled_at IS NOT NULL;
+		`, userID).Scan(&secret, &lastStep)
+		if err != nil {
+			return false, err
+		}
+
+		step, ok := verifyTOTP(secret, code, time.Now(), lastStep)
+		if !ok {
+			return false, nil
+		}
+
+		// Only one request can claim a step
+		tag, err := db.Exec(ctx, `
+			UPDATE user_totp SET last_used_step = $2
+			WHERE user_id = $1 AND last_used_step < $2;
+		`, userID, step)
+		return err == nil && tag.RowsAffected() == 1, err
+	}
+
+	if recoveryCode == "" {
+		return false, nil
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT code_id, code_hash FROM totp_recovery_codes
+		WHERE user_id = $1 AND used_at IS NULL;
+	`, userID)
+	if err != nil {
+		return false, err
+	}
+
+	type storedCode struct {
+		codeID   uuid.UUID
+		codeHash string
+	}
+	stored, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (storedCode, error) {
+		var sc storedCode
+		err := row.Scan(&sc.codeID, &sc.codeHash)
+		return sc, err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	recoveryCode = strings.ToLower(strings.TrimSpace(recoveryCode))
+	for _, sc := range stored {
+		if bcrypt.CompareHashAndPassword([]byte(sc.codeHash), []byte(recoveryCode)) != nil {
+			continue
+		}
+		tag, err := db.Exec(ctx, `
+			UPDATE totp_recovery_codes SET used_at = NOW()
+			WHERE code_id = $1 AND used_at IS NULL;
+		`, sc.codeID)
+		return err == nil && tag.RowsAffected() == 1, err
+	}
+
+	return false, nil
+}
+
+// Challenge tokens prove the password step of a 2FA login passed. Like email
+// verification tokens they carry an audience so they can't be used as access tokens.
+type loginChallengeClaims struct {
+	UserID     uuid.UUID `json:"user_id"`
+	Username   string    `json:"username"`
+	DeviceName string    `json:"device_name"`
+	jwt.RegisteredClaims
+}
+
+type TwoFactorChallenge struct {
+	UserID            uuid.UUID `json:"user_id"`
+	TwoFactorRequired bool      `json:"two_factor_required"`
+	ChallengeToken    string    `json:"challenge_token"`
+	ExpiresIn         int       `json:"expires_in"`
+}
+
+func newLoginChallenge(user UserInfo) (TwoFactorChallenge, error) {
+	claims := &loginChallengeClaims{
+		UserID:     user.UserID,
+		Username:   user.Username,
+		DeviceName: user.DeviceName,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Audience:  jwt.ClaimStrings{challengeAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(challengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token, err := signToken(claims)
+	if err != nil {
+		return TwoFactorChallenge{}, err
+	}
+
+	return TwoFactorChallenge{
+		UserID:            user.UserID,
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresIn:         int(challengeTTL.Seconds()),
+	}, nil
+}
+
+/*
+====================
+EnrollTwoFactor
+
+Purpose: Start TOTP enrollment. Returns a new secret and the otpauth:// URI to
+render as a QR code. Nothing changes for login until ConfirmTwoFactor succeeds;
+calling this again replaces an unconfirmed secret.
+
+Endpoint: POST /api/users/2fa/enroll
+Authorization: Bearer token required
+
+Response:
+	- Success: 201 Created
+		{
+			"secret": "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP",
+			"provisioning_uri": "otpauth://totp/LinkUp:testuser1?algorithm=SHA1&digits=6&issuer=LinkUp&period=30&secret=..."
+		}
+	- Conflict: 409 (2FA is already on)
+	- Server Error: 500
+*/
+func EnrollTwoFactor(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0
+		WHERE user_totp.enabled_at IS NULL
+		RETURNING user_id;
+	`
+
+	err = db.QueryRow(ctx, query, userID, secret).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, gin.H{
+		"secret":           secret,
+		"provisioning_uri": totpProvisioningURI(c.GetString("username"), secret),
+	})
+}
+
+/*
+====================
+ConfirmTwoFactor
+
+Purpose: Finish enrollment with a code from the authenticator app. Turns 2FA on
+and returns one-time recovery codes, which are shown only this once.
+
+Endpoint: POST /api/users/2fa/confirm
+Authorization: Bearer token required
+
+Body (JSON):
+	{
+		"code": "123456"
+	}
+
+Response:
+	- Success: 200 OK
+		{
+			"recovery_codes": ["k3d9-x7qp", ...]
+		}
+	- Bad Request: 400 (wrong code)
+	- Not Found: 404 (no pending enrollment)
+	- Server Error: 500
+*/
+func ConfirmTwoFactor(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var secret string
+	err = db.QueryRow(ctx, `
+		SELECT secret FROM user_totp WHERE user_id = $1 AND enabled_at IS NULL;
+	`, userID).Scan(&secret)
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "No pending two-factor enrollment"})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	step, ok := verifyTOTP(secret, request.Code, time.Now(), 0)
+	if !ok {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE user_totp SET enabled_at = NOW(), last_used_step = $2
+		WHERE user_id = $1;
+	`, userID, step)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1;`, userID)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	for _, hash := range hashes {
+		_, err = tx.Exec(ctx, `INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES ($1, $2);`, userID, hash)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+/*
+====================
+DisableTwoFactor
+
+Purpose: Turn 2FA off. Needs the password and a current code (or a recovery code).
+
+Endpoint: DELETE /api/users/2fa
+Authorization: Bearer token required
+
+Body (JSON):
+	{
+		"password": "SecurePass123",
+		"code": "123456"  // or "recovery_code": "k3d9-x7qp"
+	}
+
+Response:
+	- Success: 200 OK
+	- Unauthorized: 401 (wrong password or code)
+	- Server Error: 500
+*/
+func DisableTwoFactor(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		Password     string `json:"password" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var passwordHash string
+	err = db.QueryRow(ctx, `SELECT password_hash FROM users WHERE user_id = $1;`, userID).Scan(&passwordHash)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(request.Password)) != nil {
+		c.IndentedJSON(http.StatusUnauthorized, nil)
+		return
+	}
+
+	ok, err := checkSecondFactor(ctx, db, userID, request.Code, request.RecoveryCode)
+	if err != nil && err != pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	if !ok {
+		c.IndentedJSON(http.StatusUnauthorized, nil)
+		return
+	}
+
+	_, err = db.Exec(ctx, `DELETE FROM user_totp WHERE user_id = $1;`, userID)
+	if err == nil {
+		_, err = db.Exec(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1;`, userID)
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+/*
+====================
+LoginTwoFactor
+
+Purpose: Second step of login for accounts with 2FA. Trades the challenge token
+from LoginUser plus a TOTP or recovery code for the real tokens.
+
+Endpoint: POST /api/users/login/2fa
+Authorization: None (the challenge token is the credential)
+
+Body (JSON):
+	{
+		"challenge_token": "token-from-login",
+		"code": "123456"  // or "recovery_code": "k3d9-x7qp"
+	}
+
+Response:
+	- Success: 202 Accepted (same body as login)
+	- Bad Request: 400 (missing fields)
+	- Unauthorized: 401 (bad or expired challenge, wrong code)
+	- Forbidden: 403 (account suspended)
+	- Too Many Requests: 429 (too many wrong codes)
+	- Server Error: 500
+*/
+func LoginTwoFactor(c *gin.Context) {
+	var request struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var claims loginChallengeClaims
+	_, err := jwt.ParseWithClaims(request.ChallengeToken, &claims, verificationKey,
+		jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}),
+		jwt.WithAudience(challengeAudience),
+		jwt.WithExpirationRequired())
+
+	if err != nil {
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "Login challenge expired, log in again"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ip := c.ClientIP()
+	key := "2fa:" + claims.UserID.String()
+
+	wait, err := throttleWait(ctx, db, key, ipThrottleKey(ip))
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", fmt.Sprint(int(wait.Seconds())+1))
+		c.IndentedJSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, try again later"})
+		return
+	}
+
+	ok, err := checkSecondFactor(ctx, db, claims.UserID, request.Code, request.RecoveryCode)
+	if err != nil && err != pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	if !ok {
+		if err := recordLoginFailure(ctx, db, key, accountThrottle, &claims.UserID, ip); err != nil {
+			fmt.Printf("Error recording 2FA failure: %v\n", err)
+		}
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	if err = clearLoginFailures(ctx, db, key, accountThrottle, &claims.UserID, ip); err != nil {
+		fmt.Printf("Error clearing 2FA failures: %v\n", err)
+	}
+
+	user := UserInfo{UserID: claims.UserID, Username: claims.Username, DeviceName: claims.DeviceName}
+	success := AuthResponse{UserID: claims.UserID, Username: claims.Username}
+
+	if err = issueTokens(ctx, db, c, user, &success); err != nil {
+		if errors.Is(err, errAccountSuspended) {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Account suspended"})
+			return
+		}
+		fmt.Printf("Error issuing tokens: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusAccepted, success)
+}
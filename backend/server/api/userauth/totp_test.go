@@ -0,0 +1,104 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// The shared secret both RFCs use for their SHA-1 test vectors.
+var rfcSecret = []byte("12345678901234567890")
+
+// RFC 4226 Appendix D.
+func TestHOTPVectors(t *testing.T) {
+	expected := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+
+	for counter, want := range expected {
+		if got := hotp(rfcSecret, uint64(counter), 6); got != want {
+			t.Errorf("hotp(counter %d) = %s, want %s", counter, got, want)
+		}
+	}
+}
+
+// RFC 6238 Appendix B, SHA-1 rows.
+var totpVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestTOTPVectors(t *testing.T) {
+	for _, vector := range totpVectors {
+		step := uint64(vector.unix / totpPeriod)
+		if got := hotp(rfcSecret, step, 8); got != vector.code {
+			t.Errorf("hotp at %d = %s, want %s", vector.unix, got, vector.code)
+		}
+	}
+}
+
+func TestVerifyTOTPVectors(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfcSecret)
+
+	// verifyTOTP checks 6 digit codes, which are the last 6 of the RFC's 8.
+	for _, vector := range totpVectors {
+		code := vector.code[len(vector.code)-totpDigits:]
+		step, ok := verifyTOTP(secret, code, time.Unix(vector.unix, 0), 0)
+		if !ok {
+			t.Errorf("verifyTOTP(%s at %d) refused a valid code", code, vector.unix)
+			continue
+		}
+		if want := vector.unix / totpPeriod; step != want {
+			t.Errorf("verifyTOTP(%s at %d) matched step %d, want %d", code, vector.unix, step, want)
+		}
+	}
+}
+
+func TestVerifyTOTPDriftWindow(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfcSecret)
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		step     int64
+		lastStep int64
+		accepted bool
+	}{
+		{"current step", current, 0, true},
+		{"one step behind", current - 1, 0, true},
+		{"one step ahead", current + 1, 0, true},
+		{"two steps behind", current - 2, 0, false},
+		{"two steps ahead", current + 2, 0, false},
+		{"replayed step", current, current, false},
+		{"older than last used", current - 1, current, false},
+		{"newer than last used", current + 1, current, true},
+	}
+
+	for _, test := range tests {
+		code := hotp(rfcSecret, uint64(test.step), totpDigits)
+		step, ok := verifyTOTP(secret, code, now, test.lastStep)
+		if ok != test.accepted {
+			t.Errorf("%s: accepted = %v, want %v", test.name, ok, test.accepted)
+		}
+		if ok && step != test.step {
+			t.Errorf("%s: matched step %d, want %d", test.name, step, test.step)
+		}
+	}
+
+	wrong := []byte(hotp(rfcSecret, uint64(current), totpDigits))
+	wrong[0] = '0' + (wrong[0]-'0'+1)%10
+	if _, ok := verifyTOTP(secret, string(wrong), now, 0); ok {
+		t.Error("verifyTOTP accepted a wrong code")
+	}
+	if _, ok := verifyTOTP("not base32!", "123456", now, 0); ok {
+		t.Error("verifyTOTP accepted a code for a malformed secret")
+	}
+}
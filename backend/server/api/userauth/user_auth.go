@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"net/http"
 	"net/mail"
+	"os"
 	"regexp"
 	"strings"
 	"time"
 
+	"server/api/notify"
+
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 
@@ -25,31 +28,32 @@ type UserInfo struct {
 	Password    string    `json:"password"`
 	Name        string    `json:"name"`
 	PhoneNumber string    `json:"phone_number"`
+	DeviceName  string    `json:"device_name"`
 	UserID      uuid.UUID `json:"user_id"`
-}
 
-type AuthResponse struct {
-	UserID      uuid.UUID `json:"user_id"`
-	Username    string    `json:"username"`
-	AccessToken string    `json:"access_token"`
+	// Never bound from a request, always read from the database
+	Role             string     `json:"-"`
+	RoleUniversityID *uuid.UUID `json:"-"`
 }
 
-type JWTSecret struct {
-	Secret  string
-	Created jwt.NumericDate
+type AuthResponse struct {
+	UserID       uuid.UUID `json:"user_id"`
+	Username     string    `json:"username"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresIn    int       `json:"expires_in"` // seconds until the access token expires
 }
 
 type Claims struct {
-	UserID   uuid.UUID `json:"user_id"`
-	Username string    `json:"username"`
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	SessionID uuid.UUID `json:"sid"`
+	Role      string    `json:"role"`
+	// Only for campus admins, the university they administer
+	RoleUniversityID *uuid.UUID `json:"role_university_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// Development refresh token
-func getJWTSecret() string {
-	return "nGcKdoMsxUeoVICLQYGX4CG2S4rs2e1QRasxo29yPbM="
-}
-
 func ValidateUserInput(userInfo *UserInfo) (bool, error) {
 	// Emails
 	if userInfo.Email == "" && userInfo.PhoneNumber == "" && userInfo.Username == "" {
@@ -97,20 +101,23 @@ func ValidateUserInput(userInfo *UserInfo) (bool, error) {
 	return true, nil
 }
 
-func GenerateJWT(user UserInfo) (string, error) {
+func GenerateJWT(user UserInfo, sessionID uuid.UUID) (string, error) {
 	claims := &Claims{
-		UserID:   user.UserID,
-		Username: user.Username,
+		UserID:           user.UserID,
+		Username:         user.Username,
+		SessionID:        sessionID,
+		Role:             user.Role,
+		RoleUniversityID: user.RoleUniversityID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(365 * 24 * time.Hour)),
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	// Create the token with claims and sign it
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(getJWTSecret()))
+	// Sign with the active key; its kid goes in the header
+	tokenString, err := signToken(claims)
 	if err != nil {
 		return "", err
 	}
@@ -119,18 +126,15 @@ func GenerateJWT(user UserInfo) (string, error) {
 }
 
 func validateJWT(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrSignatureInvalid
-		}
-		return []byte(getJWTSecret()), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, verificationKey,
+		jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}))
 
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+	// Tokens minted for other purposes (email links and so on) carry an audience
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid && len(claims.Audience) == 0 {
 		return claims, nil
 	}
 
@@ -161,10 +165,28 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		db := c.MustGet("db").(*pgxpool.Pool)
+		if !sessions.isActive(c.Request.Context(), db, claims.SessionID, claims.UserID) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session revoked"})
+			c.Abort()
+			return
+		}
+
 		// Store user info in context
 		c.Set("user_id", claims.UserID.String())
 		fmt.Println("UserID: " + claims.UserID.String())
 		c.Set("username", claims.Username)
+		c.Set("session_id", claims.SessionID.String())
+
+		// Tokens from before roles existed belong to plain users
+		role := claims.Role
+		if role == "" {
+			role = RoleUser
+		}
+		c.Set("role", role)
+		if claims.RoleUniversityID != nil {
+			c.Set("role_university_id", *claims.RoleUniversityID)
+		}
 
 		c.Next()
 	}
@@ -221,32 +243,109 @@ func LoginUser(c *gin.Context) {
 	}
 
 	var success AuthResponse
+	loginByPhone := user.Username == "" && user.Email == ""
+	ip := c.ClientIP()
 
 	err = db.QueryRow(ctx, query, id).Scan(&success.UserID, &success.Username, &passwordHash)
 
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			c.IndentedJSON(http.StatusNotFound, nil)
-			return
-		}
-
+	if err != nil && err != pgx.ErrNoRows {
 		fmt.Printf("Database error: %v\n", err)
 		c.IndentedJSON(http.StatusInternalServerError, nil)
 		return
 	}
 
+	// Unknown identifiers are throttled and answered exactly like wrong
+	// passwords, so neither the status code nor the timing reveals whether an
+	// account exists
+	accountExists := err == nil
+	var accountID *uuid.UUID
+	accountKey := identifierThrottleKey(id)
+	if accountExists {
+		accountID = &success.UserID
+		accountKey = accountThrottleKey(success.UserID)
+	}
+
+	wait, err := throttleWait(ctx, db, accountKey, ipThrottleKey(ip))
+	if err != nil {
+		fmt.Printf("Error checking login throttle: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", fmt.Sprint(int(wait.Seconds())+1))
+		c.IndentedJSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, try again later"})
+		return
+	}
+
+	if accountExists {
+		err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(user.Password))
+	} else {
+		burnPasswordCheck(user.Password)
+	}
+
+	if !accountExists || err != nil {
+		if err := recordLoginFailure(ctx, db, accountKey, accountThrottle, accountID, ip); err != nil {
+			fmt.Printf("Error recording login failure: %v\n", err)
+		}
+		if err := recordLoginFailure(ctx, db, ipThrottleKey(ip), ipThrottle, nil, ip); err != nil {
+			fmt.Printf("Error recording login failure: %v\n", err)
+		}
+		c.IndentedJSON(http.StatusUnauthorized, nil)
+		return
+	}
+
+	if err = clearLoginFailures(ctx, db, accountKey, accountThrottle, accountID, ip); err != nil {
+		fmt.Printf("Error clearing login failures: %v\n", err)
+	}
+
 	user.UserID = success.UserID
 	user.Username = success.Username
 
-	err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(user.Password))
+	// Phone login only proves the caller knows a number, so it can be limited
+	// to numbers the owner has confirmed
+	if loginByPhone && os.Getenv("REQUIRE_VERIFIED_PHONE_LOGIN") == "true" {
+		var verified bool
+		err = db.QueryRow(ctx, `SELECT verified_phone_number FROM user_profiles WHERE user_id = $1;`, user.UserID).Scan(&verified)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		if !verified {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Verify your phone number before logging in with it"})
+			return
+		}
+	}
+
+	completeLogin(c, ctx, db, user, success)
+}
+
+// completeLogin answers a request whose first factor checked out: with a 2FA
+// challenge when the account has 2FA on, otherwise with a new session's tokens.
+func completeLogin(c *gin.Context, ctx context.Context, db *pgxpool.Pool, user UserInfo, success AuthResponse) {
+	// With 2FA on, the first factor only earns a short-lived challenge; the
+	// real tokens come from LoginTwoFactor
+	hasTwoFactor, err := twoFactorEnabled(ctx, db, user.UserID)
 	if err != nil {
-		fmt.Println("Wrong Password!")
-		c.IndentedJSON(http.StatusUnauthorized, nil)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+	if hasTwoFactor {
+		challenge, err := newLoginChallenge(user)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, nil)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, challenge)
 		return
 	}
 
-	success.AccessToken, err = GenerateJWT(user)
+	err = issueTokens(ctx, db, c, user, &success)
 	if err != nil {
+		if errors.Is(err, errAccountSuspended) {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Account suspended"})
+			return
+		}
+		fmt.Printf("Error issuing tokens: %v\n", err)
 		c.IndentedJSON(http.StatusInternalServerError, nil)
 		return
 	}
@@ -282,7 +381,7 @@ func SignupUser(c *gin.Context) {
 	}
 
 	query := `
-		SELECT username FROM users WHERE username = $1 OR phone_number = $2 OR email = $3;
+		SELECT username FROM users WHERE LOWER(username) = LOWER($1) OR phone_number = $2 OR email = $3;
 	`
 
 	err = db.QueryRow(ctx, query, user.Username, user.PhoneNumber, user.Email).Scan()
@@ -309,11 +408,23 @@ func SignupUser(c *gin.Context) {
 	user.UserID = success.UserID
 	user.Username = success.Username
 
-	success.AccessToken, err = GenerateJWT(user)
+	err = issueTokens(ctx, db, c, user, &success)
 	if err != nil {
+		fmt.Printf("Error issuing tokens: %v\n", err)
 		c.IndentedJSON(http.StatusInternalServerError, nil)
 		return
 	}
 
+	// The account works without a verified email, so a mail failure shouldn't
+	// fail the signup; the user can ask for a resend.
+	mailer := c.MustGet("mailer").(notify.Mailer)
+	_, err = db.Exec(ctx, `UPDATE user_profiles SET verification_email_sent_at = NOW() WHERE user_id = $1;`, user.UserID)
+	if err == nil {
+		err = sendVerificationEmail(ctx, mailer, user.UserID, user.Email)
+	}
+	if err != nil {
+		fmt.Printf("Error sending verification email: %v\n", err)
+	}
+
 	c.IndentedJSON(http.StatusCreated, success)
 }
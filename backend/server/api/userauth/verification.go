@@ -0,0 +1,245 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"server/api/notify"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	emailVerificationTTL      = 24 * time.Hour
+	emailVerificationAudience = "verify-email"
+	emailResendInterval       = 2 * time.Minute
+)
+
+// Verification tokens are signed with the same keys as access tokens but
+// carry an audience, which validateJWT refuses, so neither can stand in for
+// the other.
+type emailVerificationClaims struct {
+	UserID uuid.UUID `json:"user_id"`
+	Email  string    `json:"email"`
+	jwt.RegisteredClaims
+}
+
+func appBaseURL() string {
+	if baseURL := os.Getenv("APP_BASE_URL"); baseURL != "" {
+		return strings.TrimSuffix(baseURL, "/")
+	}
+	return "http://localhost:8080"
+}
+
+func sendVerificationEmail(ctx context.Context, mailer notify.Mailer, userID uuid.UUID, email string) error {
+	claims := &emailVerificationClaims{
+		UserID: userID,
+		Email:  email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{emailVerificationAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(emailVerificationTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token, err := signToken(claims)
+	if err != nil {
+		return err
+	}
+
+	link := appBaseURL() + "/verify-email?token=" + token
+	body := "Welcome to LinkUp!\n\n" +
+		"Confirm your email address by opening the link below. It expires in 24 hours.\n\n" +
+		link + "\n\n" +
+		"If you didn't sign up for LinkUp you can ignore this email."
+
+	return mailer.SendMail(ctx, email, "Confirm your LinkUp email", body)
+}
+
+/*
+====================
+VerifyEmail
+
+Purpose: Confirm the email address from the link sent at signup.
+
+Endpoint: POST /api/users/verify-email
+Authorization: None (the token in the link is the credential)
+
+Body (JSON):
+	{
+		"token": "token-from-the-link"
+	}
+
+Response:
+	- Success: 200 OK
+	- Bad Request: 400 (missing, malformed or expired token)
+	- Gone: 410 (the account's email changed since the link was sent)
+	- Server Error: 500
+*/
+func VerifyEmail(c *gin.Context) {
+	var request struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var claims emailVerificationClaims
+	_, err := jwt.ParseWithClaims(request.Token, &claims, verificationKey,
+		jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}),
+		jwt.WithAudience(emailVerificationAudience),
+		jwt.WithExpirationRequired())
+
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE user_profiles profile
+		SET verified_email = true
+		FROM users u
+		WHERE profile.user_id = u.user_id
+		  AND u.user_id = $1
+		  AND LOWER(u.email) = LOWER($2)
+		RETURNING profile.user_id;
+	`
+
+	var userID uuid.UUID
+	err = db.QueryRow(ctx, query, claims.UserID, claims.Email).Scan(&userID)
+
+	if err == pgx.ErrNoRows {
+		c.IndentedJSON(http.StatusGone, gin.H{"error": "This link is for an email address no longer on the account"})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+/*
+====================
+ResendVerificationEmail
+
+Purpose: Send a fresh verification link to the authenticated user's email.
+Limited to one email every two minutes.
+
+Endpoint: POST /api/users/verify-email/resend
+Authorization: Bearer token required
+
+Response:
+	- Success: 202 Accepted
+	- Conflict: 409 (email already verified)
+	- Too Many Requests: 429 (asked again too soon)
+	- Server Error: 500
+*/
+func ResendVerificationEmail(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	mailer := c.MustGet("mailer").(notify.Mailer)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var email string
+	var verified bool
+	err = db.QueryRow(ctx, `
+		SELECT u.email, profile.verified_email
+		FROM users u
+		JOIN user_profiles profile ON u.user_id = profile.user_id
+		WHERE u.user_id = $1;
+	`, userID).Scan(&email, &verified)
+
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if verified {
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "Email already verified"})
+		return
+	}
+
+	// Claim the send slot atomically so two quick taps can't both send
+	query := `
+		UPDATE user_profiles
+		SET verification_email_sent_at = NOW()
+		WHERE user_id = $1
+		  AND (verification_email_sent_at IS NULL OR verification_email_sent_at < NOW() - make_interval(secs => $2))
+		RETURNING user_id;
+	`
+
+	err = db.QueryRow(ctx, query, userID, emailResendInterval.Seconds()).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		c.Header("Retry-After", fmt.Sprint(int(emailResendInterval.Seconds())))
+		c.IndentedJSON(http.StatusTooManyRequests, gin.H{"error": "Please wait before requesting another email"})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if err = sendVerificationEmail(ctx, mailer, userID, email); err != nil {
+		fmt.Printf("Error sending verification email: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
+
+// RequireVerifiedEmail rejects users whose email isn't verified when enabled.
+// It also records the requirement in the context as "require_verified_email"
+// so handlers that reach other users (like the linkup broadcast) can apply it
+// to them too. Must run after AuthMiddleware.
+func RequireVerifiedEmail(enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !enabled {
+			c.Next()
+			return
+		}
+
+		db := c.MustGet("db").(*pgxpool.Pool)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		var verified bool
+		err := db.QueryRow(ctx, `SELECT verified_email FROM user_profiles WHERE user_id = $1;`, c.MustGet("user_id").(string)).Scan(&verified)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, nil)
+			c.Abort()
+			return
+		}
+
+		if !verified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Verify your email to use this feature"})
+			c.Abort()
+			return
+		}
+
+		c.Set("require_verified_email", true)
+		c.Next()
+	}
+}
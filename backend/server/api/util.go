@@ -1,15 +1,15 @@
 package api
 
 import (
-	"context"
 	"fmt"
 	urllib "net/url"
 	"strings"
 	"time"
 
+	"server/api/geo"
+
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type LocationCoordinates struct {
@@ -55,22 +55,12 @@ func UpdateLastLocationMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Update database
-		db := c.MustGet("db").(*pgxpool.Pool)
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		// Update both last_active_location and last_active timestamp
-		query := `
-			UPDATE user_profiles 
-			SET last_active_location = ST_SetSRID(ST_MakePoint($2, $3), 4326)::geography,
-				last_active = CURRENT_TIMESTAMP
-			WHERE user_id = $1;
-		`
-
-		_, err = db.Exec(ctx, query, userID, longitude, latitude)
-		if err != nil {
-			fmt.Printf("Error updating last location: %v\n", err)
+		// Queue it; the pipeline writes in batches. Points too close in time
+		// and space to the last stored one aren't even queued
+		locations := c.MustGet("locations").(*LocationPipeline)
+		point := geo.Point{Latitude: latitude, Longitude: longitude}
+		if locationSamples.due(userID, point, time.Now()) {
+			locations.Submit(userID, point)
 		}
 	}
 }
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+Visibility ("ghost mode") decides who can see a user on the map:
+
+	- visible: anyone nearby (the default)
+	- friends: only accepted friends get the user's linkups and see them nearby
+	- hidden: nobody; location stops being recorded and the last one is forgotten
+
+Hidden can be timed (hidden_until) or last until turned off. While hidden,
+user_profiles.active is false.
+*/
+
+const maxHiddenDuration = 7 * 24 * time.Hour
+
+type Visibility struct {
+	Mode        string     `json:"mode"` // "visible", "friends" or "hidden"
+	HiddenUntil *time.Time `json:"hidden_until"`
+}
+
+/*
+====================
+GetVisibility
+
+Purpose: The authenticated user's current visibility.
+
+Endpoint: GET /api/users/visibility
+Authorization: Bearer token required
+
+Response:
+	- Success: 200 OK
+		{
+			"mode": "hidden",
+			"hidden_until": "2024-11-02T18:00:00Z"  // null when visible, friends-only or hidden until turned off
+		}
+	- Server Error: 500
+*/
+func GetVisibility(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var visibility Visibility
+	err = db.QueryRow(ctx, `
+		SELECT
+			CASE WHEN visibility = 'hidden' AND hidden_until <= NOW() THEN 'visible' ELSE visibility::TEXT END,
+			CASE WHEN hidden_until > NOW() THEN hidden_until END
+		FROM user_profiles WHERE user_id = $1;
+	`, userID).Scan(&visibility.Mode, &visibility.HiddenUntil)
+
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, visibility)
+}
+
+/*
+====================
+SetVisibility
+
+Purpose: Change who can see the authenticated user. Going hidden also erases
+their last known location so they drop off the map right away.
+
+Endpoint: PUT /api/users/visibility
+Authorization: Bearer token required
+
+Body (JSON):
+	{
+		"mode": "hidden",
+		"duration_minutes": 120  // optional, hidden only; omit to stay hidden until turned off
+	}
+
+Response:
+	- Success: 200 OK (same body as GetVisibility)
+	- Bad Request: 400 (unknown mode, or a duration that isn't between 1 minute and 7 days)
+	- Server Error: 500
+*/
+func SetVisibility(c *gin.Context) {
+	userID, err := uuid.Parse(c.MustGet("user_id").(string))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	var request struct {
+		Mode            string `json:"mode" binding:"required"`
+		DurationMinutes int    `json:"duration_minutes"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, nil)
+		return
+	}
+
+	visibility := Visibility{Mode: request.Mode}
+
+	switch request.Mode {
+	case "visible", "friends":
+		if request.DurationMinutes != 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Only hidden mode can be timed"})
+			return
+		}
+	case "hidden":
+		if request.DurationMinutes != 0 {
+			duration := time.Duration(request.DurationMinutes) * time.Minute
+			if duration < time.Minute || duration > maxHiddenDuration {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Duration must be between 1 minute and 7 days"})
+				return
+			}
+			until := time.Now().Add(duration)
+			visibility.HiddenUntil = &until
+		}
+	default:
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Mode must be visible, friends or hidden"})
+		return
+	}
+
+	db := c.MustGet("db").(*pgxpool.Pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE user_profiles profile
+		SET visibility = $2,
+			hidden_until = $3,
+			active = $2 <> 'hidden' AND u.deleted_at IS NULL AND u.suspended_at IS NULL,
+			last_active_location = CASE WHEN $2 = 'hidden' THEN NULL ELSE profile.last_active_location END
+		FROM users u
+		WHERE profile.user_id = u.user_id
+		  AND profile.user_id = $1
+		RETURNING profile.user_id;
+	`
+
+	err = db.QueryRow(ctx, query, userID, visibility.Mode, visibility.HiddenUntil).Scan(&userID)
+	if err != nil {
+		fmt.Printf("Error setting visibility: %v\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	// Coming back shouldn't wait out the sampling interval of an old point
+	locationSamples.forget(userID)
+
+	c.IndentedJSON(http.StatusOK, visibility)
+}
+
+// ExpireHiddenProfiles brings users whose timed ghost mode ran out back onto
+// the map. Accounts waiting to be deleted or suspended stay inactive.
+func ExpireHiddenProfiles(ctx context.Context, db *pgxpool.Pool) error {
+	_, err := db.Exec(ctx, `
+		UPDATE user_profiles profile
+		SET visibility = 'visible', hidden_until = NULL, active = u.deleted_at IS NULL AND u.suspended_at IS NULL
+		FROM users u
+		WHERE profile.user_id = u.user_id
+		  AND profile.visibility = 'hidden'
+		  AND profile.hidden_until <= NOW();
+	`)
+	return err
+}
+
+// StartVisibilityExpiry runs ExpireHiddenProfiles every interval until ctx is done.
+func StartVisibilityExpiry(ctx context.Context, db *pgxpool.Pool, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			expireCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			if err := ExpireHiddenProfiles(expireCtx, db); err != nil {
+				fmt.Printf("Error expiring ghost mode: %v\n", err)
+			}
+			cancel()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
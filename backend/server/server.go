@@ -4,9 +4,19 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"server/api"
+	"server/api/admin"
 	"server/api/events"
+	"server/api/media"
+	"server/api/moderation"
+	"server/api/notify"
+	"server/api/tags"
 	auth "server/api/userauth"
 
 	"github.com/gin-gonic/gin"
@@ -33,14 +43,71 @@ func main() {
 
 	fmt.Println("✅ Successfully connected to SQL Database")
 
+	if err := auth.LoadSigningKeys(); err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+
+	if err := auth.LoadOIDCProviders(); err != nil {
+		log.Fatalf("Failed to load OIDC providers: %v", err)
+	}
+
+	mailer, err := notify.MailerFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to set up mailer: %v", err)
+	}
+
+	sms, err := notify.SMSSenderFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to set up SMS sender: %v", err)
+	}
+
+	storage, err := media.StorageFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to set up media storage: %v", err)
+	}
+
+	// Accounts in ADMIN_USER_IDS become superadmins, who hand out the other roles
+	if err := auth.PromoteBootstrapAdmins(context.Background(), dbConnection); err != nil {
+		log.Fatalf("Failed to promote bootstrap admins: %v", err)
+	}
+
+	// Hard delete accounts whose deletion grace period has run out
+	auth.StartAccountPurger(context.Background(), dbConnection, storage, time.Hour)
+
+	// Daily location history partitions, and dropping the expired ones
+	if err := api.StartLocationHistoryMaintenance(context.Background(), dbConnection, time.Hour); err != nil {
+		log.Fatalf("Failed to prepare location history partitions: %v", err)
+	}
+
+	// Bring timed ghost mode users back onto the map
+	api.StartVisibilityExpiry(context.Background(), dbConnection, time.Minute)
+
+	// Lets campuses turn off discovery for accounts without a confirmed email
+	requireVerifiedEmail := auth.RequireVerifiedEmail(os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true")
+
+	// Location header reports are coalesced per user and written in batches
+	locations := api.NewLocationPipeline(dbConnection, 2*time.Second, 500, 50000)
+	locations.Start()
+
 	router := gin.Default()
 
-	// Attach DB to every request context
+	// Attach DB, senders, media storage and the location pipeline to every request context
 	router.Use(func(c *gin.Context) {
 		c.Set("db", dbConnection)
+		c.Set("mailer", mailer)
+		c.Set("sms", sms)
+		c.Set("media", storage)
+		c.Set("locations", locations)
 		c.Next()
 	})
 
+	router.GET("/.well-known/jwks.json", auth.GetJWKS)
+
+	// Uploaded files, when they are kept on this server's disk
+	if local, ok := storage.(*media.LocalStorage); ok {
+		router.Static(media.LocalRoute, local.Dir)
+	}
+
 	// ───────────────────────────────
 	//  Public routes (signup, login)
 	// ───────────────────────────────
@@ -50,6 +117,14 @@ func main() {
 		{
 			userRoutes.POST("/signup", auth.SignupUser)
 			userRoutes.POST("/login", auth.LoginUser)
+			userRoutes.POST("/login/2fa", auth.LoginTwoFactor)
+			userRoutes.POST("/token/refresh", auth.RefreshAccessToken)
+			userRoutes.POST("/verify-email", auth.VerifyEmail)
+			userRoutes.POST("/password/forgot", auth.ForgotPassword)
+			userRoutes.POST("/password/reset", auth.ResetPassword)
+			userRoutes.GET("/oidc", auth.ListOIDCProviders)
+			userRoutes.GET("/oidc/:provider/start", auth.StartOIDCLogin)
+			userRoutes.POST("/oidc/:provider/callback", auth.FinishOIDCLogin)
 		}
 	}
 
@@ -64,36 +139,178 @@ func main() {
 		{
 			meetupRoutes.POST("", events.CreateMeetup)
 			meetupRoutes.GET("", events.GetUserMeetups)
+			meetupRoutes.GET("/:id/ratings", events.GetFunctionRatings)
+			meetupRoutes.POST("/:id/ratings", events.RateAttendee)
 		}
 		linkupRoutes := protectedRoutes.Group("/linkups")
 		{
-			linkupRoutes.POST("", events.CreateLinkup)
-			linkupRoutes.GET("/nearby", events.GetNearbyLinkups)
+			linkupRoutes.POST("", requireVerifiedEmail, events.CreateLinkup)
+			linkupRoutes.GET("/nearby", requireVerifiedEmail, events.GetNearbyLinkups)
 			linkupRoutes.GET("", events.GetUserLinkups)
 			linkupRoutes.POST("/:id/join", events.JoinLinkup)
 			linkupRoutes.DELETE("/:id", events.CancelLinkup)
+			linkupRoutes.GET("/:id/ratings", events.GetFunctionRatings)
+			linkupRoutes.POST("/:id/ratings", events.RateAttendee)
+		}
+
+		protectedRoutes.POST("/reports", moderation.CreateReport)
+
+		tagRoutes := protectedRoutes.Group("/tags")
+		{
+			tagRoutes.GET("", tags.ListTags)
+			tagRoutes.GET("/autocomplete", tags.AutocompleteTags)
+			tagRoutes.GET("/trending", tags.TrendingTags)
 		}
 
 		userRoutes := protectedRoutes.Group("/users")
 		{
 			// ⚡ NEW SEARCH ROUTE
 			userRoutes.GET("/search", api.SearchUsers)
+			userRoutes.POST("/logout", auth.Logout)
+			userRoutes.PUT("/password", auth.ChangePassword)
+
+			twoFactorRoutes := userRoutes.Group("/2fa")
+			{
+				twoFactorRoutes.POST("/enroll", auth.EnrollTwoFactor)
+				twoFactorRoutes.POST("/confirm", auth.ConfirmTwoFactor)
+				twoFactorRoutes.DELETE("", auth.DisableTwoFactor)
+			}
+			userRoutes.POST("/verify-email/resend", auth.ResendVerificationEmail)
+			userRoutes.POST("/phone/verify", auth.RequestPhoneVerification)
+			userRoutes.POST("/phone/verify/confirm", auth.ConfirmPhoneVerification)
+
+			sessionRoutes := userRoutes.Group("/sessions")
+			{
+				sessionRoutes.GET("", auth.ListSessions)
+				sessionRoutes.DELETE("", auth.RevokeAllSessions)
+				sessionRoutes.DELETE("/:id", auth.RevokeSession)
+			}
 
 			friendRoutes := userRoutes.Group("/friend")
 			{
 				friendRoutes.GET("", api.GetFriends)
 				friendRoutes.POST("", api.SendFriendRequest)
 				friendRoutes.PUT("", api.AcceptFriendRequest)
+				friendRoutes.DELETE("/:id", api.RemoveFriend)
+				friendRoutes.GET("/suggestions", requireVerifiedEmail, api.GetFriendSuggestions)
+				friendRoutes.GET("/requests/incoming", api.ListIncomingFriendRequests)
+				friendRoutes.GET("/requests/outgoing", api.ListOutgoingFriendRequests)
+				friendRoutes.DELETE("/requests/:id", api.CancelFriendRequest)
+			}
+
+			blockRoutes := userRoutes.Group("/blocks")
+			{
+				blockRoutes.GET("", api.ListBlockedUsers)
+				blockRoutes.POST("", api.BlockUser)
+				blockRoutes.DELETE("/:id", api.UnblockUser)
 			}
 
 			userRoutes.GET("", api.GetUserProfile)
+			userRoutes.PATCH("", api.UpdateProfile)
 			userRoutes.PUT("", api.UpdateProfile)
+			userRoutes.DELETE("", auth.DeleteAccount)
+			userRoutes.GET("/export", api.ExportUserData)
 			userRoutes.PUT("/location", api.UpdateUserLocation)
+			userRoutes.GET("/location/precision", api.GetLocationPrecision)
+			userRoutes.PUT("/location/precision", api.SetLocationPrecision)
+			userRoutes.GET("/location/history", api.GetLocationHistory)
+			userRoutes.DELETE("/location/history", api.DeleteLocationHistory)
+			userRoutes.GET("/visibility", api.GetVisibility)
+			userRoutes.PUT("/visibility", api.SetVisibility)
+			userRoutes.POST("/avatar", media.UploadAvatar)
+			userRoutes.DELETE("/avatar", media.DeleteAvatar)
+			userRoutes.GET("/privacy", api.GetPrivacySettings)
+			userRoutes.PUT("/privacy", api.SetPrivacySettings)
+		}
+	}
+
+	// ───────────────────────────────
+	//  Admin routes
+	// ───────────────────────────────
+	adminRoutes := router.Group("/api/admin")
+	adminRoutes.Use(auth.AuthMiddleware())
+	adminRoutes.Use(auth.AdminMiddleware(auth.RoleModerator, auth.RoleCampusAdmin))
+	{
+		moderatorsOnly := auth.AdminMiddleware(auth.RoleModerator)
+		campusAdminsOnly := auth.AdminMiddleware(auth.RoleCampusAdmin)
+		superadminsOnly := auth.AdminMiddleware()
+
+		adminRoutes.GET("/auth-audit", superadminsOnly, auth.ListAuthAudit)
+		adminRoutes.GET("/location-pipeline", superadminsOnly, api.GetLocationPipelineStats)
+
+		adminUserRoutes := adminRoutes.Group("/users")
+		{
+			adminUserRoutes.GET("", admin.LookupUsers)
+			adminUserRoutes.GET("/:id", admin.GetUser)
+			adminUserRoutes.POST("/:id/suspend", admin.SuspendUser)
+			adminUserRoutes.POST("/:id/unsuspend", admin.UnsuspendUser)
+			adminUserRoutes.POST("/:id/logout", admin.ForceLogout)
+			adminUserRoutes.POST("/:id/unlock", moderatorsOnly, auth.UnlockAccount)
+			adminUserRoutes.PUT("/:id/role", superadminsOnly, admin.SetUserRole)
+		}
+
+		adminFunctionRoutes := adminRoutes.Group("/functions")
+		{
+			adminFunctionRoutes.GET("", admin.ListFunctions)
+			adminFunctionRoutes.GET("/:id", admin.GetFunction)
+			adminFunctionRoutes.PATCH("/:id", admin.UpdateFunction)
+			adminFunctionRoutes.DELETE("/:id", admin.DeleteFunction)
+		}
+
+		universityRoutes := adminRoutes.Group("/universities")
+		{
+			universityRoutes.GET("", admin.ListUniversities)
+			universityRoutes.POST("", superadminsOnly, admin.CreateUniversity)
+			universityRoutes.PUT("/:id", campusAdminsOnly, admin.UpdateUniversity)
+			universityRoutes.DELETE("/:id", superadminsOnly, admin.DeleteUniversity)
+			universityRoutes.GET("/:id/buildings", admin.ListBuildings)
+			universityRoutes.POST("/:id/buildings", campusAdminsOnly, admin.CreateBuilding)
+		}
+		adminRoutes.PUT("/buildings/:id", campusAdminsOnly, admin.UpdateBuilding)
+		adminRoutes.DELETE("/buildings/:id", campusAdminsOnly, admin.DeleteBuilding)
+
+		// Reports aren't tied to a campus, so only moderators work the queue
+		reportRoutes := adminRoutes.Group("/reports", moderatorsOnly)
+		{
+			reportRoutes.GET("", moderation.ListReports)
+			reportRoutes.POST("/:id/assign", moderation.AssignReport)
+			reportRoutes.POST("/:id/resolve", moderation.ResolveReport)
+			reportRoutes.POST("/:id/action", moderation.ActionReport)
+		}
+		adminRoutes.GET("/moderation-audit", moderatorsOnly, moderation.ListModerationAudit)
+
+		adminTagRoutes := adminRoutes.Group("/tags", moderatorsOnly)
+		{
+			adminTagRoutes.POST("", admin.CreateTag)
+			adminTagRoutes.POST("/:id/aliases", admin.AddTagAlias)
 		}
 	}
 
 	// ───────────────────────────────
 	//  Start server
 	// ───────────────────────────────
-	router.Run("localhost:8080")
+	server := &http.Server{Addr: "localhost:8080", Handler: router}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	// On Ctrl+C or SIGTERM, finish in-flight requests, then write the queued locations
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	<-stop.Done()
+
+	fmt.Println("Shutting down...")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancelShutdown()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		fmt.Printf("Error shutting down server: %v\n", err)
+	}
+	if err := locations.Close(shutdownCtx); err != nil {
+		fmt.Printf("Error flushing locations: %v\n", err)
+	}
+	fmt.Printf("Location pipeline: %s\n", locations.Stats())
 }